@@ -0,0 +1,112 @@
+// Package catalog reads and writes the book catalog in the interchange
+// formats used by publishers and libraries.
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/meynay/BookStore/models"
+	"github.com/meynay/BookStore/store"
+)
+
+// Record is one book read from an import file. Row is the line in a CSV
+// file or the position of the product in an ONIX message, and Err is set
+// when the row can't be imported.
+type Record struct {
+	Row  int
+	Book models.Book
+	Err  error
+}
+
+// check validates the identifying fields and fills in both ISBN forms.
+// Either column may hold either form; when both are given they must be the
+// same book.
+func (r *Record) check(isbn13, isbn10 string) {
+	if r.Err != nil {
+		return
+	}
+	r.Book.Title = strings.TrimSpace(r.Book.Title)
+	if r.Book.Title == "" {
+		r.Err = errors.New("title is required")
+		return
+	}
+	if isbn13 == "" && isbn10 == "" {
+		r.Err = errors.New("isbn13 or isbn is required")
+		return
+	}
+	for _, isbn := range []string{isbn13, isbn10} {
+		if isbn == "" {
+			continue
+		}
+		got13, got10, err := ParseISBN(isbn)
+		if err != nil {
+			r.Err = fmt.Errorf("%w %q", err, isbn)
+			return
+		}
+		if r.Book.Isbn13 != "" && r.Book.Isbn13 != got13 {
+			r.Err = fmt.Errorf("isbn %q doesn't match isbn13 %q", isbn10, isbn13)
+			return
+		}
+		r.Book.Isbn13, r.Book.Isbn = got13, got10
+	}
+}
+
+// Read parses an import file in the given format, "csv" or "onix". An
+// empty format is guessed from the file name.
+func Read(format, filename string, r io.Reader) ([]Record, error) {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".csv":
+			format = "csv"
+		case ".xml", ".onix":
+			format = "onix"
+		}
+	}
+	switch format {
+	case "csv":
+		return ReadCSV(r)
+	case "onix":
+		return ReadONIX(r)
+	}
+	return nil, fmt.Errorf("unknown import format %q", format)
+}
+
+// Import writes the valid records through the book store in one
+// transaction and reports the outcome of every row. Rejected rows don't
+// stop the others from being imported.
+func Import(books store.BookStore, records []Record, dryRun bool) (models.ImportReport, error) {
+	report := models.ImportReport{DryRun: dryRun, Rows: make([]models.ImportRow, len(records))}
+	valid := []models.Book{}
+	index := []int{}
+	for i, record := range records {
+		row := models.ImportRow{Row: record.Row, Isbn13: record.Book.Isbn13, Title: record.Book.Title}
+		if record.Err != nil {
+			row.Status, row.Error = models.ImportRejected, record.Err.Error()
+			report.Rejected++
+		} else {
+			valid = append(valid, record.Book)
+			index = append(index, i)
+		}
+		report.Rows[i] = row
+	}
+	if len(valid) == 0 {
+		return report, nil
+	}
+	statuses, err := books.Import(valid, dryRun)
+	if err != nil {
+		return report, err
+	}
+	for i, status := range statuses {
+		report.Rows[index[i]].Status = status
+		if status == models.ImportCreated {
+			report.Created++
+		} else {
+			report.Updated++
+		}
+	}
+	return report, nil
+}
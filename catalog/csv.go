@@ -0,0 +1,133 @@
+package catalog
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meynay/BookStore/models"
+)
+
+// CSVColumns are the columns understood by ReadCSV and written by the CSV
+// export. Only title and one of isbn13/isbn are required.
+var CSVColumns = []string{"isbn13", "isbn", "title", "authors", "genres", "publisher", "format", "language", "pages", "publication_date", "price", "quantity_sale", "quantity_lib", "description", "image_url"}
+
+// ReadCSV reads a catalog file with a header row. Multiple authors or genres
+// are separated by ";" and an author may carry a role after ":", as in
+// "Jane Doe:translator".
+func ReadCSV(r io.Reader) ([]Record, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		columns[name] = i
+	}
+	if _, ok := columns["title"]; !ok {
+		return nil, errors.New("header has no title column")
+	}
+	_, has13 := columns["isbn13"]
+	_, has10 := columns["isbn"]
+	if !has13 && !has10 {
+		return nil, errors.New("header has no isbn13 or isbn column")
+	}
+	records := []Record{}
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			var perr *csv.ParseError
+			if !errors.As(err, &perr) {
+				return nil, err
+			}
+			records = append(records, Record{Row: perr.StartLine, Err: perr.Err})
+			continue
+		}
+		get := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(fields) {
+				return strings.TrimSpace(fields[i])
+			}
+			return ""
+		}
+		record := Record{Row: line}
+		record.Book = models.Book{
+			Title:       get("title"),
+			Publisher:   get("publisher"),
+			Format:      get("format"),
+			Language:    get("language"),
+			Description: get("description"),
+			ImageUrl:    get("image_url"),
+			Genres:      splitList(get("genres")),
+			Authors:     []models.AuthorR{},
+		}
+		for _, author := range splitList(get("authors")) {
+			name, role, _ := strings.Cut(author, ":")
+			record.Book.Authors = append(record.Book.Authors, models.AuthorR{Author: strings.TrimSpace(name), Role: strings.TrimSpace(role)})
+		}
+		numbers := []struct {
+			column string
+			value  *int
+		}{
+			{"pages", &record.Book.NumberOfPages},
+			{"price", &record.Book.Price},
+			{"quantity_sale", &record.Book.QuantityForSale},
+			{"quantity_lib", &record.Book.QuantityInLib},
+		}
+		for _, n := range numbers {
+			if record.Err == nil {
+				*n.value, record.Err = parseNumber(n.column, get(n.column))
+			}
+		}
+		if record.Err == nil {
+			record.Book.PublicationDate, record.Err = parseDate(get("publication_date"))
+		}
+		record.check(get("isbn13"), get("isbn"))
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseNumber(column, s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(strings.ReplaceAll(s, ",", ""))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s %q is not a valid number", column, s)
+	}
+	return n, nil
+}
+
+// parseDate accepts a full date, a year and month or just a year.
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01", "2006", "20060102"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("publication_date %q is not a valid date", s)
+}
@@ -0,0 +1,75 @@
+package catalog
+
+import (
+	"encoding/xml"
+	"fmt"
+
+	"github.com/meynay/BookStore/models"
+)
+
+const dcNamespace = "http://purl.org/dc/elements/1.1/"
+
+// DCRecord is a simple Dublin Core record. The wrapping element and its
+// namespace differ between protocols, e.g. oai_dc:dc for OAI-PMH.
+type DCRecord struct {
+	XMLName      xml.Name
+	Namespaces   []xml.Attr `xml:",any,attr"`
+	Titles       []string   `xml:"dc:title"`
+	Creators     []string   `xml:"dc:creator"`
+	Contributors []string   `xml:"dc:contributor"`
+	Subjects     []string   `xml:"dc:subject"`
+	Descriptions []string   `xml:"dc:description"`
+	Publishers   []string   `xml:"dc:publisher"`
+	Dates        []string   `xml:"dc:date"`
+	Types        []string   `xml:"dc:type"`
+	Formats      []string   `xml:"dc:format"`
+	Identifiers  []string   `xml:"dc:identifier"`
+	Languages    []string   `xml:"dc:language"`
+	Relations    []string   `xml:"dc:relation"`
+}
+
+func appendNonEmpty(values []string, value string) []string {
+	if value == "" {
+		return values
+	}
+	return append(values, value)
+}
+
+// DublinCore maps a book onto Dublin Core inside an element named element
+// (with its prefix) that belongs to namespace.
+func DublinCore(book models.Book, element, prefix, namespace string) DCRecord {
+	record := DCRecord{
+		XMLName: xml.Name{Local: element},
+		Namespaces: []xml.Attr{
+			{Name: xml.Name{Local: "xmlns:" + prefix}, Value: namespace},
+			{Name: xml.Name{Local: "xmlns:dc"}, Value: dcNamespace},
+		},
+		Titles: []string{book.Title},
+		Types:  []string{"Text"},
+	}
+	for _, a := range book.Authors {
+		if a.Role == "" {
+			record.Creators = append(record.Creators, a.Author)
+		} else {
+			record.Contributors = append(record.Contributors, fmt.Sprintf("%s (%s)", a.Author, a.Role))
+		}
+	}
+	record.Subjects = append(record.Subjects, book.Genres...)
+	record.Descriptions = appendNonEmpty(record.Descriptions, book.Description)
+	record.Publishers = appendNonEmpty(record.Publishers, book.Publisher)
+	if !book.PublicationDate.IsZero() {
+		record.Dates = append(record.Dates, book.PublicationDate.Format("2006-01-02"))
+	}
+	record.Formats = appendNonEmpty(record.Formats, book.Format)
+	if book.NumberOfPages > 0 {
+		record.Formats = append(record.Formats, fmt.Sprintf("%d pages", book.NumberOfPages))
+	}
+	if book.Isbn13 != "" {
+		record.Identifiers = append(record.Identifiers, "urn:isbn:"+book.Isbn13)
+	}
+	record.Languages = appendNonEmpty(record.Languages, book.Language)
+	if book.Series != "" {
+		record.Relations = append(record.Relations, book.Series)
+	}
+	return record
+}
@@ -0,0 +1,124 @@
+package catalog
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/meynay/BookStore/models"
+	"github.com/meynay/BookStore/store"
+)
+
+// Writer encodes books one at a time. Close flushes whatever the format
+// needs after the last book.
+type Writer interface {
+	Write(book models.Book) error
+	Close() error
+}
+
+// ExportFormat describes one of the formats Export can write.
+type ExportFormat struct {
+	ContentType string
+	Extension   string
+	New         func(w io.Writer) Writer
+}
+
+var ExportFormats = map[string]ExportFormat{
+	"csv":     {"text/csv; charset=utf-8", "csv", NewCSVWriter},
+	"jsonl":   {"application/jsonl; charset=utf-8", "jsonl", NewJSONLinesWriter},
+	"marc":    {"application/marc", "mrc", NewMARCWriter},
+	"marcxml": {"application/marcxml+xml; charset=utf-8", "xml", NewMARCXMLWriter},
+}
+
+// Export streams the whole catalog through a writer of the given format.
+func Export(books store.BookStore, format string, w io.Writer) error {
+	f, ok := ExportFormats[format]
+	if !ok {
+		return fmt.Errorf("unknown export format %q", format)
+	}
+	writer := f.New(w)
+	if err := books.Each(writer.Write); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+// NewCSVWriter writes the CSVColumns that ReadCSV reads back, so an export
+// can be edited and imported again.
+func NewCSVWriter(w io.Writer) Writer {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (cw *csvWriter) Write(book models.Book) error {
+	if !cw.header {
+		cw.header = true
+		if err := cw.w.Write(CSVColumns); err != nil {
+			return err
+		}
+	}
+	authors := []string{}
+	for _, a := range book.Authors {
+		if a.Role != "" {
+			authors = append(authors, a.Author+":"+a.Role)
+		} else {
+			authors = append(authors, a.Author)
+		}
+	}
+	date := ""
+	if !book.PublicationDate.IsZero() {
+		date = book.PublicationDate.Format("2006-01-02")
+	}
+	return cw.w.Write([]string{
+		book.Isbn13,
+		book.Isbn,
+		book.Title,
+		strings.Join(authors, ";"),
+		strings.Join(book.Genres, ";"),
+		book.Publisher,
+		book.Format,
+		book.Language,
+		strconv.Itoa(book.NumberOfPages),
+		date,
+		strconv.Itoa(book.Price),
+		strconv.Itoa(book.QuantityForSale),
+		strconv.Itoa(book.QuantityInLib),
+		book.Description,
+		book.ImageUrl,
+	})
+}
+
+func (cw *csvWriter) Close() error {
+	if !cw.header {
+		cw.header = true
+		cw.w.Write(CSVColumns)
+	}
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+type jsonLinesWriter struct {
+	encoder *json.Encoder
+}
+
+// NewJSONLinesWriter writes one book per line in the GetBook JSON shape.
+func NewJSONLinesWriter(w io.Writer) Writer {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return &jsonLinesWriter{encoder: encoder}
+}
+
+func (jw *jsonLinesWriter) Write(book models.Book) error {
+	return jw.encoder.Encode(book)
+}
+
+func (jw *jsonLinesWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,82 @@
+package catalog
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalidISBN = errors.New("invalid ISBN")
+
+// compactISBN drops the separators people put in ISBNs.
+func compactISBN(s string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "", " ", "").Replace(strings.TrimSpace(s)))
+}
+
+func validISBN10(isbn string) bool {
+	if len(isbn) != 10 {
+		return false
+	}
+	sum := 0
+	for i, r := range isbn {
+		var digit int
+		switch {
+		case r >= '0' && r <= '9':
+			digit = int(r - '0')
+		case r == 'X' && i == 9:
+			digit = 10
+		default:
+			return false
+		}
+		sum += (10 - i) * digit
+	}
+	return sum%11 == 0
+}
+
+func isbn13Check(first12 string) byte {
+	sum := 0
+	for i, r := range first12 {
+		digit := int(r - '0')
+		if i%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+func validISBN13(isbn string) bool {
+	if len(isbn) != 13 {
+		return false
+	}
+	for _, r := range isbn {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return isbn13Check(isbn[:12]) == isbn[12]
+}
+
+// ParseISBN validates an ISBN-10 or ISBN-13 and returns both forms. The
+// ISBN-10 is empty for 979 books, which have none.
+func ParseISBN(s string) (isbn13, isbn10 string, err error) {
+	isbn := compactISBN(s)
+	switch {
+	case validISBN13(isbn):
+		isbn13 = isbn
+	case validISBN10(isbn):
+		isbn13 = "978" + isbn[:9]
+		isbn13 += string(isbn13Check(isbn13))
+		return isbn13, isbn, nil
+	default:
+		return "", "", ErrInvalidISBN
+	}
+	if strings.HasPrefix(isbn13, "978") {
+		sum := 0
+		for i, r := range isbn13[3:12] {
+			sum += (10 - i) * int(r-'0')
+		}
+		check := (11 - sum%11) % 11
+		isbn10 = isbn13[3:12] + string("0123456789X"[check])
+	}
+	return isbn13, isbn10, nil
+}
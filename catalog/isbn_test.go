@@ -0,0 +1,68 @@
+package catalog
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseISBN(t *testing.T) {
+	tests := []struct {
+		in             string
+		isbn13, isbn10 string
+	}{
+		{"0-306-40615-2", "9780306406157", "0306406152"},
+		{"978-0-306-40615-7", "9780306406157", "0306406152"},
+		{"080442957X", "9780804429573", "080442957X"},
+		{"080442957x", "9780804429573", "080442957X"},
+		{" 978 0 8044 2957 3 ", "9780804429573", "080442957X"},
+		{"964-6194-70-2", "9789646194700", "9646194702"},
+		{"979-10-90636-07-1", "9791090636071", ""},
+	}
+	for _, tt := range tests {
+		isbn13, isbn10, err := ParseISBN(tt.in)
+		if err != nil || isbn13 != tt.isbn13 || isbn10 != tt.isbn10 {
+			t.Errorf("ParseISBN(%q) = %q, %q, %v; want %q, %q", tt.in, isbn13, isbn10, err, tt.isbn13, tt.isbn10)
+		}
+	}
+}
+
+func TestParseISBNInvalid(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"0-306-40615-3",     // wrong ISBN-10 check digit
+		"978-0-306-40615-8", // wrong ISBN-13 check digit
+		"X306406152",        // X only stands for 10 in the last place
+		"97803064061X7",
+		"030640615",
+		"97803064061577",
+		"isbn0306406152",
+	} {
+		if _, _, err := ParseISBN(in); !errors.Is(err, ErrInvalidISBN) {
+			t.Errorf("ParseISBN(%q): got %v, want ErrInvalidISBN", in, err)
+		}
+	}
+}
+
+func TestCheckDigits(t *testing.T) {
+	for first12, want := range map[string]byte{
+		"978030640615": '7',
+		"978080442957": '3',
+		"979109063607": '1',
+		"978000000000": '2',
+	} {
+		if got := isbn13Check(first12); got != want {
+			t.Errorf("isbn13Check(%q) = %c, want %c", first12, got, want)
+		}
+	}
+	for isbn, want := range map[string]bool{
+		"0306406152": true,
+		"080442957X": true,
+		"0000000000": true,
+		"0306406153": false,
+		"030640615":  false,
+	} {
+		if got := validISBN10(isbn); got != want {
+			t.Errorf("validISBN10(%q) = %v, want %v", isbn, got, want)
+		}
+	}
+}
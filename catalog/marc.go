@@ -0,0 +1,287 @@
+package catalog
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/meynay/BookStore/models"
+)
+
+const MARCNamespace = "http://www.loc.gov/MARC21/slim"
+
+const (
+	marcSubfield    = 0x1F
+	marcFieldEnd    = 0x1E
+	marcRecordEnd   = 0x1D
+	marcMaxRecord   = 99999
+	marcDescription = 8000
+)
+
+type marcSubfieldValue struct {
+	code  byte
+	value string
+}
+
+// marcField is a control field when value is set, a data field otherwise.
+type marcField struct {
+	tag        string
+	ind1, ind2 byte
+	value      string
+	subfields  []marcSubfieldValue
+}
+
+func (f *marcField) add(code byte, value string) {
+	if value != "" {
+		f.subfields = append(f.subfields, marcSubfieldValue{code, value})
+	}
+}
+
+// marcFields maps a book onto MARC21 bibliographic fields.
+func marcFields(book models.Book) []marcField {
+	fields := []marcField{{tag: "001", value: strconv.Itoa(book.Id)}}
+	year := "    "
+	if !book.PublicationDate.IsZero() {
+		year = fmt.Sprintf("%04d", book.PublicationDate.Year())
+	}
+	language := "   "
+	if len(book.Language) == 3 {
+		language = book.Language
+	}
+	// 008: entry date, single known date, unknown place, language, other source
+	fields = append(fields, marcField{tag: "008", value: time.Now().Format("060102") + "s" + year + "    " + "xx " + fmt.Sprintf("%17s", "") + language + " d"})
+	for _, isbn := range []string{book.Isbn13, book.Isbn} {
+		if isbn != "" {
+			f := marcField{tag: "020", ind1: ' ', ind2: ' '}
+			f.add('a', isbn)
+			f.add('q', book.Format)
+			fields = append(fields, f)
+		}
+	}
+	var main *models.AuthorR
+	added := []marcField{}
+	for i, a := range book.Authors {
+		if main == nil && a.Role == "" {
+			main = &book.Authors[i]
+			continue
+		}
+		f := marcField{tag: "700", ind1: '1', ind2: ' '}
+		f.add('a', a.Author)
+		role := a.Role
+		if role == "" {
+			role = "author"
+		}
+		f.add('e', role)
+		added = append(added, f)
+	}
+	titleInd := byte('0')
+	if main != nil {
+		f := marcField{tag: "100", ind1: '1', ind2: ' '}
+		f.add('a', main.Author)
+		f.add('e', "author")
+		fields = append(fields, f)
+		titleInd = '1'
+	}
+	title := marcField{tag: "245", ind1: titleInd, ind2: '0'}
+	title.add('a', book.Title)
+	fields = append(fields, title)
+	if book.Publisher != "" || year != "    " {
+		f := marcField{tag: "264", ind1: ' ', ind2: '1'}
+		f.add('b', book.Publisher)
+		if year != "    " {
+			f.add('c', year)
+		}
+		fields = append(fields, f)
+	}
+	if book.NumberOfPages > 0 {
+		f := marcField{tag: "300", ind1: ' ', ind2: ' '}
+		f.add('a', fmt.Sprintf("%d pages", book.NumberOfPages))
+		fields = append(fields, f)
+	}
+	if book.Price > 0 {
+		f := marcField{tag: "365", ind1: ' ', ind2: ' '}
+		f.add('b', strconv.Itoa(book.Price))
+		fields = append(fields, f)
+	}
+	if book.Series != "" {
+		f := marcField{tag: "490", ind1: '0', ind2: ' '}
+		f.add('a', book.Series)
+		if book.SeriesVolume > 0 {
+			f.add('v', strconv.Itoa(book.SeriesVolume))
+		}
+		fields = append(fields, f)
+	}
+	if book.Description != "" {
+		description := book.Description
+		// keep binary records under the 99999 byte limit of the leader
+		if len(description) > marcDescription {
+			description = description[:marcDescription]
+			for !utf8.ValidString(description) {
+				description = description[:len(description)-1]
+			}
+		}
+		f := marcField{tag: "520", ind1: ' ', ind2: ' '}
+		f.add('a', description)
+		fields = append(fields, f)
+	}
+	for _, genre := range book.Genres {
+		f := marcField{tag: "650", ind1: ' ', ind2: '4'}
+		f.add('a', genre)
+		fields = append(fields, f)
+	}
+	fields = append(fields, added...)
+	if book.ImageUrl != "" {
+		f := marcField{tag: "856", ind1: '4', ind2: '2'}
+		f.add('3', "Cover image")
+		f.add('u', book.ImageUrl)
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// marcRecord encodes the fields in ISO 2709 and returns the record with
+// its leader.
+func marcRecord(fields []marcField) ([]byte, error) {
+	var directory, data bytes.Buffer
+	for _, f := range fields {
+		start := data.Len()
+		if f.value != "" {
+			data.WriteString(f.value)
+		} else {
+			data.WriteByte(f.ind1)
+			data.WriteByte(f.ind2)
+			for _, s := range f.subfields {
+				data.WriteByte(marcSubfield)
+				data.WriteByte(s.code)
+				data.WriteString(s.value)
+			}
+		}
+		data.WriteByte(marcFieldEnd)
+		fmt.Fprintf(&directory, "%s%04d%05d", f.tag, data.Len()-start, start)
+	}
+	directory.WriteByte(marcFieldEnd)
+	base := 24 + directory.Len()
+	length := base + data.Len() + 1
+	if length > marcMaxRecord {
+		return nil, fmt.Errorf("MARC record of %d bytes is too long", length)
+	}
+	record := make([]byte, 0, length)
+	record = fmt.Appendf(record, "%05dnam a22%05d   4500", length, base)
+	record = append(record, directory.Bytes()...)
+	record = append(record, data.Bytes()...)
+	return append(record, marcRecordEnd), nil
+}
+
+type marcWriter struct {
+	w io.Writer
+}
+
+// NewMARCWriter writes binary MARC21 records with UTF-8 data.
+func NewMARCWriter(w io.Writer) Writer {
+	return &marcWriter{w: w}
+}
+
+func (mw *marcWriter) Write(book models.Book) error {
+	record, err := marcRecord(marcFields(book))
+	if err != nil {
+		return fmt.Errorf("book %d: %w", book.Id, err)
+	}
+	_, err = mw.w.Write(record)
+	return err
+}
+
+func (mw *marcWriter) Close() error {
+	return nil
+}
+
+type marcXMLSubfield struct {
+	Code  string `xml:"code,attr"`
+	Value string `xml:",chardata"`
+}
+
+type marcXMLControlField struct {
+	Tag   string `xml:"tag,attr"`
+	Value string `xml:",chardata"`
+}
+
+type marcXMLDataField struct {
+	Tag       string            `xml:"tag,attr"`
+	Ind1      string            `xml:"ind1,attr"`
+	Ind2      string            `xml:"ind2,attr"`
+	Subfields []marcXMLSubfield `xml:"subfield"`
+}
+
+// MARCRecord is a MARCXML <record>.
+type MARCRecord struct {
+	XMLName       xml.Name              `xml:"record"`
+	Xmlns         string                `xml:"xmlns,attr,omitempty"`
+	Leader        string                `xml:"leader"`
+	ControlFields []marcXMLControlField `xml:"controlfield"`
+	DataFields    []marcXMLDataField    `xml:"datafield"`
+}
+
+// MARCXML returns the book as a MARCXML record. Set Xmlns to
+// MARCNamespace when embedding it in other XML responses.
+func MARCXML(book models.Book) (MARCRecord, error) {
+	fields := marcFields(book)
+	binary, err := marcRecord(fields)
+	if err != nil {
+		return MARCRecord{}, fmt.Errorf("book %d: %w", book.Id, err)
+	}
+	record := MARCRecord{Leader: string(binary[:24])}
+	for _, f := range fields {
+		if f.value != "" {
+			record.ControlFields = append(record.ControlFields, marcXMLControlField{Tag: f.tag, Value: f.value})
+			continue
+		}
+		data := marcXMLDataField{Tag: f.tag, Ind1: string(f.ind1), Ind2: string(f.ind2)}
+		for _, s := range f.subfields {
+			data.Subfields = append(data.Subfields, marcXMLSubfield{Code: string(s.code), Value: s.value})
+		}
+		record.DataFields = append(record.DataFields, data)
+	}
+	return record, nil
+}
+
+type marcXMLWriter struct {
+	w       io.Writer
+	encoder *xml.Encoder
+	started bool
+}
+
+// NewMARCXMLWriter writes a MARCXML <collection>.
+func NewMARCXMLWriter(w io.Writer) Writer {
+	return &marcXMLWriter{w: w, encoder: xml.NewEncoder(w)}
+}
+
+func (mw *marcXMLWriter) start() error {
+	if mw.started {
+		return nil
+	}
+	mw.started = true
+	_, err := io.WriteString(mw.w, xml.Header+`<collection xmlns="`+MARCNamespace+`">`)
+	return err
+}
+
+func (mw *marcXMLWriter) Write(book models.Book) error {
+	if err := mw.start(); err != nil {
+		return err
+	}
+	record, err := MARCXML(book)
+	if err != nil {
+		return err
+	}
+	return mw.encoder.Encode(record)
+}
+
+func (mw *marcXMLWriter) Close() error {
+	if err := mw.start(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(mw.w, "</collection>\n")
+	return err
+}
@@ -0,0 +1,120 @@
+package catalog
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/meynay/BookStore/models"
+)
+
+func TestMARCKnownRecord(t *testing.T) {
+	record, err := marcRecord([]marcField{
+		{tag: "001", value: "7"},
+		{tag: "245", ind1: '0', ind2: '0', subfields: []marcSubfieldValue{{'a', "T"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "00058nam a2200049   4500" +
+		"001000200000" + "245000600002" + "\x1e" +
+		"7\x1e" + "00\x1faT\x1e" + "\x1d"
+	if string(record) != want {
+		t.Errorf("record\n got %q\nwant %q", record, want)
+	}
+}
+
+// parseMARC reads an ISO 2709 record back using only the leader and the
+// directory, the way other systems will.
+func parseMARC(t *testing.T, record []byte) []marcField {
+	t.Helper()
+	length, _ := strconv.Atoi(string(record[:5]))
+	base, _ := strconv.Atoi(string(record[12:17]))
+	if length != len(record) || record[len(record)-1] != marcRecordEnd {
+		t.Fatalf("leader says %d bytes, record has %d", length, len(record))
+	}
+	if record[base-1] != marcFieldEnd {
+		t.Fatalf("base address %d doesn't follow the directory", base)
+	}
+	fields := []marcField{}
+	for entry := record[24 : base-1]; len(entry) > 0; entry = entry[12:] {
+		tag := string(entry[:3])
+		size, _ := strconv.Atoi(string(entry[3:7]))
+		start, _ := strconv.Atoi(string(entry[7:12]))
+		data := record[base+start : base+start+size]
+		if data[len(data)-1] != marcFieldEnd {
+			t.Fatalf("field %s at %d doesn't end where the directory says", tag, start)
+		}
+		data = data[:len(data)-1]
+		if tag < "010" {
+			fields = append(fields, marcField{tag: tag, value: string(data)})
+			continue
+		}
+		f := marcField{tag: tag, ind1: data[0], ind2: data[1]}
+		for _, s := range bytes.Split(data[2:], []byte{marcSubfield})[1:] {
+			f.subfields = append(f.subfields, marcSubfieldValue{s[0], string(s[1:])})
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func TestMARCRoundTrip(t *testing.T) {
+	book := models.Book{
+		Id:              12,
+		Title:           "کلیدر",
+		Isbn13:          "9789643510000",
+		Format:          "paperback",
+		Publisher:       "نشر چشمه",
+		PublicationDate: time.Date(1989, 1, 1, 0, 0, 0, 0, time.UTC),
+		Language:        "per",
+		NumberOfPages:   2836,
+		Price:           1500000,
+		Series:          "کلیدر",
+		SeriesVolume:    1,
+		Description:     strings.Repeat("گل ", 3000),
+		Genres:          []string{"رمان"},
+		Authors:         []models.AuthorR{{Author: "محمود دولت‌آبادی"}, {Author: "مترجم", Role: "translator"}},
+		ImageUrl:        "https://example.com/covers/12.jpg",
+	}
+	fields := marcFields(book)
+	var out bytes.Buffer
+	w := NewMARCWriter(&out)
+	if err := w.Write(book); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if got := parseMARC(t, out.Bytes()); !reflect.DeepEqual(got, fields) {
+		t.Errorf("parsed back\n got %+v\nwant %+v", got, fields)
+	}
+	for _, f := range fields {
+		if f.tag == "520" {
+			description := f.subfields[0].value
+			if len(description) > marcDescription || !utf8.ValidString(description) {
+				t.Errorf("description of %d bytes, valid %v", len(description), utf8.ValidString(description))
+			}
+		}
+	}
+
+	xmlRecord, err := MARCXML(book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xmlRecord.Leader != out.String()[:24] {
+		t.Errorf("MARCXML leader %q, binary %q", xmlRecord.Leader, out.String()[:24])
+	}
+}
+
+func TestMARCTooLong(t *testing.T) {
+	book := models.Book{Id: 1, Title: "x"}
+	for i := 0; i < 6000; i++ {
+		book.Genres = append(book.Genres, "genre"+strconv.Itoa(i))
+	}
+	if err := NewMARCWriter(&bytes.Buffer{}).Write(book); err == nil || !strings.Contains(err.Error(), "too long") {
+		t.Errorf("record over 99999 bytes: got %v", err)
+	}
+}
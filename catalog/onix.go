@@ -0,0 +1,247 @@
+package catalog
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/meynay/BookStore/models"
+)
+
+// onixProduct holds the parts of an ONIX 3.0 <Product> (reference tags) that
+// map onto a book.
+type onixProduct struct {
+	Identifiers []struct {
+		Type  string `xml:"ProductIDType"`
+		Value string `xml:"IDValue"`
+	} `xml:"ProductIdentifier"`
+	Descriptive struct {
+		Form   string `xml:"ProductForm"`
+		Titles []struct {
+			Type     string `xml:"TitleType"`
+			Elements []struct {
+				Text          string `xml:"TitleText"`
+				Prefix        string `xml:"TitlePrefix"`
+				WithoutPrefix string `xml:"TitleWithoutPrefix"`
+				Subtitle      string `xml:"Subtitle"`
+			} `xml:"TitleElement"`
+		} `xml:"TitleDetail"`
+		Contributors []struct {
+			Roles     []string `xml:"ContributorRole"`
+			Name      string   `xml:"PersonName"`
+			Inverted  string   `xml:"PersonNameInverted"`
+			Corporate string   `xml:"CorporateName"`
+		} `xml:"Contributor"`
+		Languages []struct {
+			Role string `xml:"LanguageRole"`
+			Code string `xml:"LanguageCode"`
+		} `xml:"Language"`
+		Extents []struct {
+			Type  string `xml:"ExtentType"`
+			Value string `xml:"ExtentValue"`
+			Unit  string `xml:"ExtentUnit"`
+		} `xml:"Extent"`
+		Subjects []struct {
+			Heading string `xml:"SubjectHeadingText"`
+		} `xml:"Subject"`
+	} `xml:"DescriptiveDetail"`
+	Collateral struct {
+		Texts []struct {
+			Type string `xml:"TextType"`
+			Text string `xml:"Text"`
+		} `xml:"TextContent"`
+		Resources []struct {
+			Type     string `xml:"ResourceContentType"`
+			Versions []struct {
+				Link string `xml:"ResourceLink"`
+			} `xml:"ResourceVersion"`
+		} `xml:"SupportingResource"`
+	} `xml:"CollateralDetail"`
+	Publishing struct {
+		Publishers []struct {
+			Role string `xml:"PublishingRole"`
+			Name string `xml:"PublisherName"`
+		} `xml:"Publisher"`
+		Dates []struct {
+			Role string `xml:"PublishingDateRole"`
+			Date string `xml:"Date"`
+		} `xml:"PublishingDate"`
+	} `xml:"PublishingDetail"`
+	Supplies []struct {
+		Details []struct {
+			Prices []struct {
+				Amount string `xml:"PriceAmount"`
+			} `xml:"Price"`
+		} `xml:"SupplyDetail"`
+	} `xml:"ProductSupply"`
+}
+
+// onixForms maps ONIX ProductForm codes to the book_format values we use.
+var onixForms = map[string]string{
+	"BA": "Book",
+	"BB": "Hardcover",
+	"BC": "Paperback",
+	"EA": "ebook",
+	"ED": "ebook",
+	"AC": "Audio CD",
+	"AJ": "Audiobook",
+	"AN": "Audiobook",
+}
+
+// onixRoles maps ONIX ContributorRole codes to book_author roles. Authors
+// have an empty role like the books added by hand.
+var onixRoles = map[string]string{
+	"A01": "",
+	"A12": "illustrator",
+	"B01": "editor",
+	"B06": "translator",
+}
+
+// ReadONIX reads the products of an ONIX 3.0 message one by one, so large
+// feeds don't have to fit in memory as a tree.
+func ReadONIX(r io.Reader) ([]Record, error) {
+	decoder := xml.NewDecoder(r)
+	records := []Record{}
+	seenMessage := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "ONIXMessage":
+			seenMessage = true
+		case "ONIXmessage":
+			return nil, errors.New("short tag ONIX messages are not supported")
+		case "Product":
+			var product onixProduct
+			if err := decoder.DecodeElement(&product, &start); err != nil {
+				return nil, err
+			}
+			records = append(records, product.record(len(records)+1))
+		}
+	}
+	if !seenMessage {
+		return nil, errors.New("not an ONIX 3.0 message")
+	}
+	return records, nil
+}
+
+func (p onixProduct) record(row int) Record {
+	record := Record{Row: row}
+	book := &record.Book
+	book.Authors = []models.AuthorR{}
+	book.Genres = []string{}
+	var isbn13, isbn10 string
+	for _, id := range p.Identifiers {
+		switch id.Type {
+		case "15":
+			isbn13 = id.Value
+		case "02":
+			isbn10 = id.Value
+		}
+	}
+	for _, title := range p.Descriptive.Titles {
+		if title.Type != "01" || len(title.Elements) == 0 {
+			continue
+		}
+		element := title.Elements[0]
+		book.Title = element.Text
+		if book.Title == "" {
+			book.Title = strings.TrimSpace(element.Prefix + " " + element.WithoutPrefix)
+		}
+		if element.Subtitle != "" {
+			book.Title += ": " + element.Subtitle
+		}
+		break
+	}
+	book.Format = onixForms[p.Descriptive.Form]
+	for _, contributor := range p.Descriptive.Contributors {
+		name := contributor.Name
+		if name == "" {
+			name = contributor.Corporate
+		}
+		if name == "" {
+			if last, first, ok := strings.Cut(contributor.Inverted, ","); ok {
+				name = strings.TrimSpace(first) + " " + strings.TrimSpace(last)
+			} else {
+				name = contributor.Inverted
+			}
+		}
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		for _, code := range contributor.Roles {
+			if role, ok := onixRoles[code]; ok {
+				book.Authors = append(book.Authors, models.AuthorR{Author: name, Role: role})
+				break
+			}
+		}
+	}
+	for _, language := range p.Descriptive.Languages {
+		if language.Role == "01" {
+			book.Language = language.Code
+			break
+		}
+	}
+	for _, extent := range p.Descriptive.Extents {
+		// main content or total page count
+		if (extent.Type == "00" || extent.Type == "11") && (extent.Unit == "" || extent.Unit == "03") {
+			book.NumberOfPages, _ = strconv.Atoi(extent.Value)
+			break
+		}
+	}
+	for _, subject := range p.Descriptive.Subjects {
+		if heading := strings.TrimSpace(subject.Heading); heading != "" {
+			book.Genres = append(book.Genres, heading)
+		}
+	}
+	for _, text := range p.Collateral.Texts {
+		if text.Type == "03" {
+			book.Description = strings.TrimSpace(text.Text)
+			break
+		}
+	}
+	for _, resource := range p.Collateral.Resources {
+		// front cover
+		if resource.Type == "01" && len(resource.Versions) > 0 {
+			book.ImageUrl = resource.Versions[0].Link
+			break
+		}
+	}
+	for _, publisher := range p.Publishing.Publishers {
+		if publisher.Role == "" || publisher.Role == "01" {
+			book.Publisher = publisher.Name
+			break
+		}
+	}
+	for _, date := range p.Publishing.Dates {
+		if date.Role == "01" {
+			var err error
+			if book.PublicationDate, err = parseDate(date.Date); err != nil {
+				record.Err = fmt.Errorf("publishing date %q is not a valid date", date.Date)
+			}
+			break
+		}
+	}
+	if len(p.Supplies) > 0 && len(p.Supplies[0].Details) > 0 && len(p.Supplies[0].Details[0].Prices) > 0 {
+		amount := p.Supplies[0].Details[0].Prices[0].Amount
+		price, err := strconv.ParseFloat(strings.TrimSpace(amount), 64)
+		if err != nil && record.Err == nil {
+			record.Err = fmt.Errorf("price %q is not a valid number", amount)
+		}
+		book.Price = int(price)
+	}
+	record.check(isbn13, isbn10)
+	return record
+}
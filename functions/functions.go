@@ -1,39 +1,27 @@
 package functions
 
 import (
-	"context"
+	"crypto/hmac"
 	"crypto/rand"
-	"database/sql"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
-	"os"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/dgrijalva/jwt-go"
-	"github.com/go-redis/redis/v8"
+	"github.com/meynay/BookStore/keyring"
 	"github.com/meynay/BookStore/models"
 	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/gomail.v2"
 )
 
-var Redis_Host = os.Getenv("REDIS_HOST")
-var Redis_Port = os.Getenv("REDIS_PORT")
-
-var ctx = context.Background()
-var redisClient = redis.NewClient(&redis.Options{
-	Addr: Redis_Host + Redis_Port,
-})
-
-func BlacklistToken(token string, expiry time.Duration) error {
-	return redisClient.Set(ctx, token, "blacklisted", expiry).Err()
-}
-
-func IsTokenBlacklisted(token string) bool {
-	result, err := redisClient.Get(ctx, token).Result()
-	return err == nil && result == "blacklisted"
-}
-
 func ConvertToInterfaceSlice(bids []int) []interface{} {
 	result := make([]interface{}, len(bids))
 	for i, v := range bids {
@@ -55,24 +43,82 @@ func CompareHashAndPassword(hashed, pass string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hashed), []byte(pass))
 }
 
+// Keys signs and verifies the tokens handed out at sign in. main loads it
+// before serving.
+var Keys *keyring.Keyring
+
+func SignToken(claims jwt.Claims) (string, error) {
+	return Keys.Sign(claims)
+}
+
+func ParseToken(token string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(token, claims, Keys.Keyfunc)
+}
+
 func GetUserId(token string) int {
 	claims := &models.Claims{}
-	jwt.ParseWithClaims(token, claims,
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
+	ParseToken(token, claims)
 	return claims.Uid
 }
 
+func GetSessionId(token string) int {
+	claims := &models.Claims{}
+	ParseToken(token, claims)
+	return claims.Sid
+}
+
 func HashPassword(password string) (string, error) {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return "", err
 	}
-	log.Println(string(hash))
 	return string(hash), nil
 }
 
+const MinPasswordLength = 8
+
+// bcrypt ignores everything after 72 bytes
+const MaxPasswordLength = 72
+
+// commonPasswords are among the most used ones that would pass the other
+// rules.
+var commonPasswords = map[string]bool{
+	"password1": true, "password123": true, "passw0rd": true, "qwerty123": true,
+	"qwerty1234": true, "abc12345": true, "abcd1234": true, "asdf1234": true,
+	"admin123": true, "welcome1": true, "letmein1": true, "iloveyou1": true,
+	"1q2w3e4r": true, "q1w2e3r4": true, "1qaz2wsx": true, "zaq12wsx": true,
+	"bookstore1": true,
+}
+
+// ValidatePassword rejects passwords that are easy to guess: short ones,
+// ones without both letters and digits, well known ones and ones made from
+// the user's email address.
+func ValidatePassword(password, email string) error {
+	if utf8.RuneCountInString(password) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	if len(password) > MaxPasswordLength {
+		return fmt.Errorf("password must be at most %d bytes", MaxPasswordLength)
+	}
+	var letter, digit bool
+	for _, r := range password {
+		letter = letter || unicode.IsLetter(r)
+		digit = digit || unicode.IsDigit(r)
+	}
+	if !letter || !digit {
+		return errors.New("password must contain letters and digits")
+	}
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] {
+		return errors.New("password is too common")
+	}
+	name, _, _ := strings.Cut(strings.ToLower(email), "@")
+	if len(name) >= 4 && strings.Contains(lower, name) {
+		return errors.New("password must not contain your email address")
+	}
+	return nil
+}
+
 func Exists(value int, arr []int) bool {
 	for _, val := range arr {
 		if value == val {
@@ -123,6 +169,96 @@ func GenerateToken() (string, error) {
 	return base64.URLEncoding.EncodeToString(bytes), nil
 }
 
+// HashToken is how tokens handed to users are stored, so a leaked table
+// can't be used to sign in.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateApiKey makes a key for an api client. The prefix is shown to
+// tell keys apart, and the hash is all that gets stored.
+func GenerateApiKey() (key, prefix, hash string, err error) {
+	token, err := GenerateToken()
+	if err != nil {
+		return "", "", "", err
+	}
+	key = "bsk_" + strings.TrimRight(token, "=")
+	return key, key[:12], HashToken(key), nil
+}
+
+var ErrInvalidToken = errors.New("invalid token")
+var ErrExpiredToken = errors.New("token expired")
+
+// EmailTokenSecret signs the tokens mailed to users. main sets it before
+// serving; while it is empty no email token is accepted.
+var EmailTokenSecret []byte
+
+func emailTokenSignature(purpose, payload string) string {
+	mac := hmac.New(sha256.New, EmailTokenSecret)
+	mac.Write([]byte(purpose + "\n" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignEmailToken makes a token proving the user received mail at email. It
+// needs no storage; changing the address makes older tokens useless. The
+// purpose, like "verify-email", keeps a token from working for another one.
+func SignEmailToken(purpose string, uid int, email string, expires time.Time) string {
+	payload := fmt.Sprintf("%d:%d:%s", uid, expires.Unix(), email)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + emailTokenSignature(purpose, payload)
+}
+
+func ParseEmailToken(purpose, token string) (int, string, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || len(EmailTokenSecret) == 0 {
+		return 0, "", ErrInvalidToken
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || !hmac.Equal([]byte(signature), []byte(emailTokenSignature(purpose, string(raw)))) {
+		return 0, "", ErrInvalidToken
+	}
+	parts := strings.SplitN(string(raw), ":", 3)
+	if len(parts) != 3 {
+		return 0, "", ErrInvalidToken
+	}
+	uid, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", ErrInvalidToken
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, "", ErrInvalidToken
+	}
+	if time.Now().Unix() > expires {
+		return 0, "", ErrExpiredToken
+	}
+	return uid, parts[2], nil
+}
+
+func SendVerifyEmail(email, name, token string, config models.EmailConfig) error {
+	verifyLink := fmt.Sprintf("https://bikaransystem.work.gd/verify-email?token=%s", token)
+	subject := "Bookstore sign up"
+	body := fmt.Sprintf(`
+		<h1>Welcome %s<h1>
+        <p>We're glad that you decided to use our service. Hope you can find what you seek in our web app</p>
+        <p>Please confirm your email address before borrowing or buying books:</p>
+        <a href="%s">Verify email</a>
+    `, name, verifyLink)
+	return SendEmail(email, subject, body, config)
+}
+
+func SendUnlockEmail(email, token string, until time.Time, config models.EmailConfig) error {
+	unlockLink := fmt.Sprintf("https://bikaransystem.work.gd/unlock-account?token=%s", token)
+	subject := "Bookstore sign in locked"
+	body := fmt.Sprintf(`
+        <p>There were too many failed attempts to sign in to your account, so signing in is locked until %s.</p>
+        <p>If it was you, you can unlock it right away:</p>
+        <a href="%s">Unlock account</a>
+        <p>If it wasn't you, someone may be guessing your password. Consider changing it.</p>
+    `, until.Format("2006-01-02 15:04 MST"), unlockLink)
+	return SendEmail(email, subject, body, config)
+}
+
 func SendResetPassEmail(email, token string, config models.EmailConfig) error {
 	resetLink := fmt.Sprintf("https://bikaransystem.work.gd/reset-password?token=%s", token)
 	subject := "بازیابی رمز عبور"
@@ -135,12 +271,35 @@ func SendResetPassEmail(email, token string, config models.EmailConfig) error {
 	return SendEmail(email, subject, body, config)
 }
 
-func GetBorrowedBooks(result *sql.Rows) []models.LowBook {
-	books := []models.LowBook{}
-	for result.Next() {
-		var book models.LowBook
-		result.Scan(&book.Id, &book.Title, &book.ImageUrl, &book.Rate, &book.Count)
-		books = append(books, book)
-	}
-	return books
+var persianFolds = map[rune]rune{
+	'ي': 'ی', 'ى': 'ی', 'ك': 'ک', 'ة': 'ه', 'ۀ': 'ه',
+	'أ': 'ا', 'إ': 'ا', 'ٱ': 'ا', 'ؤ': 'و',
+}
+
+// NormalizePersian mirrors the normalize_fa SQL function: it folds Arabic
+// letters and digits onto Persian/ASCII ones and drops diacritics, tatweel
+// and zero-width joiners.
+func NormalizePersian(s string) string {
+	return strings.ToLower(strings.Map(func(r rune) rune {
+		switch {
+		case r >= '٠' && r <= '٩':
+			return '0' + r - '٠'
+		case r >= '۰' && r <= '۹':
+			return '0' + r - '۰'
+		case r >= '\u064B' && r <= '\u065F', r == '\u0670', r == '\u0640', r == '\u200C', r == '\u200D':
+			return -1
+		}
+		if folded, ok := persianFolds[r]; ok {
+			return folded
+		}
+		return r
+	}, s))
+}
+
+// SearchTerms splits normalized text into the words the full-text index
+// knows about.
+func SearchTerms(s string) []string {
+	return strings.FieldsFunc(NormalizePersian(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
 }
@@ -0,0 +1,62 @@
+package functions
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidatePassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		ok       bool
+	}{
+		{"good", "kashk2bademjan", true},
+		{"too short", "abc123", false},
+		{"eight persian letters", "رمزعبور۱۲", true},
+		{"short in characters, long in bytes", "رمز۱۲", false},
+		{"72 bytes", strings.Repeat("a1", 36), true},
+		{"73 bytes", strings.Repeat("a1", 36) + "b", false},
+		{"persian past 72 bytes", strings.Repeat("رم۱", 13), false},
+		{"no digits", "kashkbademjan", false},
+		{"no letters", "1234567890", false},
+		{"common", "password1", false},
+		{"contains email", "ali.rezaei99", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePassword(tt.password, "ali.rezaei@example.com")
+			if (err == nil) != tt.ok {
+				t.Errorf("ValidatePassword(%q) = %v, want ok %v", tt.password, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestEmailToken(t *testing.T) {
+	EmailTokenSecret = []byte("email-secret")
+	t.Cleanup(func() { EmailTokenSecret = nil })
+	token := SignEmailToken("verify-email", 7, "a@example.com", time.Now().Add(time.Hour))
+	if uid, email, err := ParseEmailToken("verify-email", token); err != nil || uid != 7 || email != "a@example.com" {
+		t.Fatalf("ParseEmailToken = %d, %q, %v", uid, email, err)
+	}
+	if _, _, err := ParseEmailToken("unlock-account", token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("other purpose: got %v, want ErrInvalidToken", err)
+	}
+	expired := SignEmailToken("verify-email", 7, "a@example.com", time.Now().Add(-time.Second))
+	if _, _, err := ParseEmailToken("verify-email", expired); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("expired: got %v, want ErrExpiredToken", err)
+	}
+
+	EmailTokenSecret = []byte("another-secret")
+	if _, _, err := ParseEmailToken("verify-email", token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("other secret: got %v, want ErrInvalidToken", err)
+	}
+	EmailTokenSecret = nil
+	unsigned := SignEmailToken("verify-email", 7, "a@example.com", time.Now().Add(time.Hour))
+	if _, _, err := ParseEmailToken("verify-email", unsigned); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("without a secret: got %v, want ErrInvalidToken", err)
+	}
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meynay/BookStore/functions"
+	"github.com/meynay/BookStore/models"
+	"github.com/meynay/BookStore/store"
+)
+
+// CLIENTCACHETTL is how long an instance trusts a key it looked up. Keys
+// revoked on another instance keep working here for up to that long; that
+// delay is accepted so keys aren't looked up on every request.
+const CLIENTCACHETTL = time.Minute
+
+// CLIENTRATELIMIT is the requests per minute of clients issued without a
+// limit of their own.
+const CLIENTRATELIMIT = 1000
+
+// PUBLICRATELIMIT is the requests per minute each ip may send to the apis
+// that take no api key, like OPDS and the covers.
+const PUBLICRATELIMIT = 120
+
+const APICLIENTKEY = "apiClient"
+
+// apiClient finds the client a key belongs to. Lookups are cached, which
+// also keeps last_used_at from being written on every request.
+func (app *App) apiClient(key string) (models.ApiClient, error) {
+	hash := functions.HashToken(key)
+	if client, ok := app.ClientCache.Get(hash); ok {
+		return client, nil
+	}
+	client, err := app.Clients.ByHash(hash)
+	if err != nil {
+		return client, err
+	}
+	now := time.Now()
+	if err := app.Clients.Touch(client.Id, now); err != nil {
+		log.Println("Couldn't record api key use:", err)
+	}
+	client.LastUsedAt = &now
+	app.ClientCache.Set(hash, client)
+	return client, nil
+}
+
+// allowRequest counts a request against the client's limit for the current
+// minute, setting the X-RateLimit headers on the way.
+func (app *App) allowRequest(c *gin.Context, client models.ApiClient) (bool, error) {
+	return app.allow(c, fmt.Sprintf("api:client:%d", client.Id), client.RateLimit)
+}
+
+// allow counts a request under key for the current minute. Counts live in
+// the attempt store, so the limit holds across instances.
+func (app *App) allow(c *gin.Context, key string, limit int) (bool, error) {
+	minute := time.Now().Truncate(time.Minute)
+	count, err := app.Attempts.Fail(fmt.Sprintf("%s:%d", key, minute.Unix()), time.Minute)
+	if err != nil {
+		return false, err
+	}
+	c.Header("X-RateLimit-Limit", strconv.Itoa(limit))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(max(limit-count, 0)))
+	if count > limit {
+		reset := minute.Add(time.Minute)
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(reset).Seconds())+1))
+		return false, nil
+	}
+	return true, nil
+}
+
+// PublicRateLimit stands in for ApiKeyCheck on the apis open without a key,
+// limiting each ip to PUBLICRATELIMIT requests a minute.
+func (app *App) PublicRateLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, err := app.allow(c, "api:ip:"+c.ClientIP(), PUBLICRATELIMIT)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+			return
+		}
+		if !allowed {
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"message": "rate limit exceeded, send an api key for a higher one"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireScope lets through clients whose key was issued with scope.
+func (app *App) RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, _ := c.Get(APICLIENTKEY)
+		client, _ := value.(models.ApiClient)
+		if !slices.Contains(client.Scopes, scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "api key lacks scope " + scope})
+			return
+		}
+		c.Next()
+	}
+}
+
+// api clients section
+
+func (app *App) ListApiClients(c *gin.Context) {
+	clients, err := app.Clients.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, clients)
+}
+
+// IssueApiClient registers a client and hands out its key. The key is only
+// ever shown in this response.
+func (app *App) IssueApiClient(c *gin.Context) {
+	var request struct {
+		Name      string   `json:"name" binding:"required"`
+		Scopes    []string `json:"scopes" binding:"required"`
+		RateLimit int      `json:"rate_limit"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, scope := range request.Scopes {
+		if !models.ValidScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown scope " + scope, "scopes": models.Scopes})
+			return
+		}
+	}
+	if request.RateLimit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rate_limit can't be negative"})
+		return
+	}
+	if request.RateLimit == 0 {
+		request.RateLimit = CLIENTRATELIMIT
+	}
+	key, prefix, hash, err := functions.GenerateApiKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	client := models.ApiClient{
+		Name:      request.Name,
+		Prefix:    prefix,
+		Hash:      hash,
+		Scopes:    request.Scopes,
+		RateLimit: request.RateLimit,
+	}
+	if err := app.Clients.Create(&client); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	app.audit(c, "apiclient.issue", "api_client", client.Id, nil, client)
+	c.JSON(http.StatusCreated, gin.H{"client": client, "key": key})
+}
+
+// RevokeApiClient stops the key at once on this instance. The others keep
+// accepting it until their cache of it expires, up to CLIENTCACHETTL.
+func (app *App) RevokeApiClient(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = app.Clients.Revoke(id)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "api client not found or already revoked"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// the cache is keyed by hash, which we don't have here
+	app.ClientCache.Clear()
+	app.audit(c, "apiclient.revoke", "api_client", id, nil, nil)
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("api key revoked, other instances may accept it for up to %s", CLIENTCACHETTL)})
+}
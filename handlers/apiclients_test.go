@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPublicRateLimit(t *testing.T) {
+	app := testApp(t)
+	engine := gin.New()
+	engine.GET("/.well-known/jwks.json", app.PublicRateLimit(), app.JWKS)
+	get := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/.well-known/jwks.json", nil)
+		req.RemoteAddr = ip + ":40000"
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+	for i := 0; i < PUBLICRATELIMIT; i++ {
+		if w := get("203.0.113.7"); w.Code != http.StatusOK {
+			t.Fatalf("request %d: got %d", i+1, w.Code)
+		}
+	}
+	w := get("203.0.113.7")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("over the limit: got %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := get("203.0.113.8"); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Remaining") != strconv.Itoa(PUBLICRATELIMIT-1) {
+		t.Errorf("other ip: got %d, remaining %s", w.Code, w.Header().Get("X-RateLimit-Remaining"))
+	}
+}
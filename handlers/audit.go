@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meynay/BookStore/functions"
+	"github.com/meynay/BookStore/models"
+	"github.com/meynay/BookStore/store"
+)
+
+var AuditCSVColumns = []string{"id", "created_at", "actor_id", "action", "target_type", "target_id", "ip", "diff"}
+
+// audit records an action of the signed in user. before and after are
+// compared field by field through their JSON form; either may be nil.
+func (app *App) audit(c *gin.Context, action, targetType string, targetId interface{}, before, after interface{}) {
+	app.auditAs(c, functions.GetUserId(c.GetHeader("Authorization")), action, targetType, targetId, before, after)
+}
+
+// auditAs is audit for requests without a token, like signing in. The action
+// already happened, so a failed write is logged instead of failing it.
+func (app *App) auditAs(c *gin.Context, actor int, action, targetType string, targetId interface{}, before, after interface{}) {
+	entry := models.AuditEntry{
+		ActorId:    actor,
+		Action:     action,
+		TargetType: targetType,
+		TargetId:   fmt.Sprint(targetId),
+		Diff:       auditDiff(before, after),
+		Ip:         c.ClientIP(),
+	}
+	if err := app.Audit.Record(&entry); err != nil {
+		log.Println("Couldn't save audit entry:", action, err)
+	}
+}
+
+func auditDiff(before, after interface{}) map[string]models.AuditChange {
+	prev, next := auditFields(before), auditFields(after)
+	diff := make(map[string]models.AuditChange)
+	for key, value := range next {
+		if !reflect.DeepEqual(prev[key], value) {
+			diff[key] = models.AuditChange{Before: prev[key], After: value}
+		}
+	}
+	for key, value := range prev {
+		if _, ok := next[key]; !ok {
+			diff[key] = models.AuditChange{Before: value}
+		}
+	}
+	return diff
+}
+
+// auditFields flattens a value to its JSON fields, leaving out secrets and
+// timestamps the entry has anyway.
+func auditFields(v interface{}) map[string]interface{} {
+	if v == nil {
+		return nil
+	}
+	fields := make(map[string]interface{})
+	raw, err := json.Marshal(v)
+	if err != nil || json.Unmarshal(raw, &fields) != nil {
+		return nil
+	}
+	delete(fields, "password")
+	delete(fields, "updated_at")
+	return fields
+}
+
+// audit section
+func (app *App) ListAudit(c *gin.Context) {
+	var filter models.AuditFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	entries, err := app.Audit.List(filter)
+	if errors.Is(err, store.ErrInvalidPage) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+// spreadsheetSafe keeps a cell from being run as a formula when the export
+// is opened in a spreadsheet. Users choose some of what ends up in it, like
+// their names.
+func spreadsheetSafe(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
+
+func (app *App) ExportAudit(c *gin.Context) {
+	var filter models.AuditFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename=audit.csv")
+	c.Status(http.StatusOK)
+	w := csv.NewWriter(c.Writer)
+	w.Write(AuditCSVColumns)
+	err := app.Audit.Each(filter, func(entry models.AuditEntry) error {
+		diff, err := json.Marshal(entry.Diff)
+		if err != nil {
+			return err
+		}
+		w.Write([]string{
+			strconv.Itoa(entry.Id),
+			entry.CreatedAt.Format(time.RFC3339),
+			strconv.Itoa(entry.ActorId),
+			spreadsheetSafe(entry.Action),
+			spreadsheetSafe(entry.TargetType),
+			spreadsheetSafe(entry.TargetId),
+			spreadsheetSafe(entry.Ip),
+			spreadsheetSafe(string(diff)),
+		})
+		return w.Error()
+	})
+	w.Flush()
+	// the status is already sent once rows start streaming, so a failure
+	// can only be logged
+	if err != nil {
+		log.Println("audit export failed:", err)
+	}
+}
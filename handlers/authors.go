@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meynay/BookStore/models"
+	"github.com/meynay/BookStore/store"
+)
+
+// authors section
+func (app *App) GetAuthors(c *gin.Context) {
+	var page models.Page
+	if err := c.ShouldBindQuery(&page); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	authors, err := app.Authors.List(c.Query("q"), page)
+	if errors.Is(err, store.ErrInvalidPage) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, authors)
+}
+
+func (app *App) GetAuthor(c *gin.Context) {
+	aid, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	author, err := app.Authors.Get(aid)
+	if errors.Is(err, store.ErrNotFound) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, author)
+}
+
+func (app *App) EditAuthor(c *gin.Context) {
+	aid, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	var author models.Author
+	if err := c.BindJSON(&author); err != nil {
+		return
+	}
+	if author.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "author name is required"})
+		return
+	}
+	author.Id = aid
+	before, err := app.Authors.Get(aid)
+	if errors.Is(err, store.ErrNotFound) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	err = app.Authors.Update(author)
+	if errors.Is(err, store.ErrNotFound) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	before.Books, author.Books = nil, nil
+	app.audit(c, "author.update", "author", aid, before, author)
+	app.Suggestions.Clear()
+	c.JSON(http.StatusOK, gin.H{"message": "author updated"})
+}
+
+func (app *App) MergeAuthors(c *gin.Context) {
+	aid, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	var request struct {
+		Duplicates []int `json:"duplicates" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, id := range request.Duplicates {
+		if id == aid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "can't merge an author into itself"})
+			return
+		}
+	}
+	err = app.Authors.Merge(aid, request.Duplicates)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "author not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	app.audit(c, "author.merge", "author", aid, nil, gin.H{"duplicates": request.Duplicates})
+	app.Suggestions.Clear()
+	c.JSON(http.StatusOK, gin.H{"message": "authors merged"})
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meynay/BookStore/catalog"
+)
+
+const MAXIMPORTSIZE = 64 << 20
+
+// catalog section
+func (app *App) ImportBooks(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MAXIMPORTSIZE)
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error occured during getting file"})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+	records, err := catalog.Read(c.Query("format"), header.Filename, file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	dryRun := c.Query("dry_run") == "true"
+	report, err := catalog.Import(app.Books, records, dryRun)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !dryRun && report.Created+report.Updated > 0 {
+		app.audit(c, "catalog.import", "catalog", header.Filename, nil, gin.H{"created": report.Created, "updated": report.Updated, "rejected": report.Rejected})
+		app.Suggestions.Clear()
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func (app *App) ExportBooks(c *gin.Context) {
+	name := c.DefaultQuery("format", "csv")
+	format, ok := catalog.ExportFormats[name]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv, jsonl, marc or marcxml"})
+		return
+	}
+	c.Header("Content-Type", format.ContentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=catalog.%s", format.Extension))
+	c.Status(http.StatusOK)
+	// the status is already sent once books start streaming, so a failure
+	// can only cut the response short
+	if err := catalog.Export(app.Books, name, c.Writer); err != nil {
+		c.Error(err)
+		c.Abort()
+	}
+}
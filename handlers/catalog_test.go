@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/meynay/BookStore/cache"
+	"github.com/meynay/BookStore/models"
+	"github.com/meynay/BookStore/store"
+)
+
+// countingBooks counts the suggestions that reach the store.
+type countingBooks struct {
+	store.BookStore
+	suggests *int
+}
+
+func (s countingBooks) Suggest(prefix string, limit int) ([]models.Suggestion, error) {
+	*s.suggests++
+	return s.BookStore.Suggest(prefix, limit)
+}
+
+func TestSuggest(t *testing.T) {
+	app := testApp(t)
+	app.Suggestions = cache.New[string, []models.Suggestion](10, time.Minute)
+	addBook(t, app, models.Book{Title: "کلیدر", Publisher: "نشر چشمه", Authors: []models.AuthorR{{Author: "محمود دولت‌آبادی"}}})
+	addBook(t, app, models.Book{Title: "سفر کلید", Genres: []string{"رمان"}})
+	var calls int
+	app.Books = countingBooks{app.Books, &calls}
+	suggest := func(q string) []models.Suggestion {
+		t.Helper()
+		w := serve(t, app.Suggest, "/suggest", "GET", "/suggest?q="+url.QueryEscape(q), 7, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Suggest(%q): got %d %s", q, w.Code, w.Body)
+		}
+		var suggestions []models.Suggestion
+		json.Unmarshal(w.Body.Bytes(), &suggestions)
+		return suggestions
+	}
+
+	got := suggest("کلید")
+	if len(got) != 2 || got[0].Text != "کلیدر" || got[0].Type != "book" || got[1].Text != "سفر کلید" {
+		t.Fatalf("Suggest = %+v, want the prefix match first", got)
+	}
+	// the Arabic yeh and kaf normalize onto the cached Persian prefix
+	if again := suggest("كليد"); len(again) != 2 || calls != 1 {
+		t.Errorf("second lookup: %d suggestions, %d store calls; want it served from the cache", len(again), calls)
+	}
+	if got := suggest("دولت"); len(got) != 1 || got[0].Type != "author" || calls != 2 {
+		t.Errorf("author prefix = %+v after %d calls", got, calls)
+	}
+	w := serve(t, app.Suggest, "/suggest", "GET", "/suggest?q=+", 7, "")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("blank query: got %d, want 400", w.Code)
+	}
+}
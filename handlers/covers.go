@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meynay/BookStore/images"
+	"github.com/meynay/BookStore/store"
+)
+
+const MAXCOVERSIZE = 10 << 20
+const COVERDIR = "covers"
+const COVERURLTTL = 24 * time.Hour
+
+// covers section
+func (app *App) UploadCover(c *gin.Context) {
+	bid, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	book, err := app.Books.Get(bid)
+	if errors.Is(err, store.ErrNotFound) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MAXCOVERSIZE+1<<20)
+	header, err := c.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) || err == nil && header.Size > MAXCOVERSIZE {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "cover images can't be larger than 10MB"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error occured during getting file"})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+	img, err := images.Decode(file)
+	if errors.Is(err, images.ErrNotImage) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, images.ErrTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	covers, err := images.Covers(bid, img)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var url string
+	for _, cover := range covers {
+		if err := app.Blobs.Put(COVERDIR+"/"+cover.Name, bytes.NewReader(cover.Data), cover.ContentType); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if cover.Variant == images.Full && cover.Format == "jpg" {
+			url = images.COVERPATH + cover.Name
+		}
+	}
+	if err := app.Books.SetImage(bid, url); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// the same picture uploaded again gets the same names, keep those
+	if book.ImageUrl != url {
+		for _, name := range images.CoverNames(book.ImageUrl) {
+			app.Blobs.Delete(COVERDIR + "/" + name)
+		}
+	}
+	app.audit(c, "book.cover", "book", bid, gin.H{"image_url": book.ImageUrl}, gin.H{"image_url": url})
+	c.JSON(http.StatusOK, gin.H{"image_url": url, "covers": images.CoverSet(url)})
+}
+
+// GetCover sends clients on to a signed url of the blob store. The names
+// hold a hash of the picture, so the redirect can be cached for a while.
+func (app *App) GetCover(c *gin.Context) {
+	name := path.Base(c.Param("file"))
+	url, err := app.Blobs.URL(COVERDIR+"/"+name, COVERURLTTL)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(COVERURLTTL.Seconds()/2)))
+	c.Redirect(http.StatusFound, url)
+}
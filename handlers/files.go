@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meynay/BookStore/store"
+)
+
+const FILESPATH = "/files/"
+
+// files section
+// ServeFile hands out files of the local blob store to whoever has a signed
+// url for them. Other backends serve their urls themselves.
+func (app *App) ServeFile(c *gin.Context) {
+	local, ok := app.Blobs.(*store.LocalBlobs)
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if !local.Verify(key, c.Query("expires"), c.Query("signature")) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	file, contentType, err := local.Get(key)
+	if errors.Is(err, store.ErrNotFound) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+	c.Header("Content-Type", contentType)
+	c.Header("Cache-Control", "private, max-age=3600")
+	c.Status(http.StatusOK)
+	io.Copy(c.Writer, file)
+}
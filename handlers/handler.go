@@ -1,45 +1,82 @@
 package handlers
 
 import (
-	"database/sql"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
-	"github.com/lib/pq"
+	"github.com/meynay/BookStore/cache"
 	"github.com/meynay/BookStore/functions"
+	"github.com/meynay/BookStore/images"
 	"github.com/meynay/BookStore/models"
+	"github.com/meynay/BookStore/store"
 )
 
 type App struct {
-	DB         *sql.DB
-	Email      models.EmailConfig
-	RateLimit  models.RateLimiter
-	ResetToken map[string]string
-	GetSignal  map[int]chan (bool)
+	store.Stores
+	Blobs       store.BlobStore
+	Attempts    store.AttemptStore
+	Suggestions *cache.Cache[string, []models.Suggestion]
+	ClientCache *cache.Cache[string, models.ApiClient]
+	Email       models.EmailConfig
+	RateLimit   models.RateLimiter
+	// TwoFactorRoles must enroll an authenticator app before using their
+	// permissions.
+	TwoFactorRoles []string
+	GetSignal      map[int]chan (bool)
 }
 
 const RATELIMIT = 200
 const DURATION = time.Minute
+const SUGGESTLIMIT = 10
+const PROFILEURLTTL = 15 * time.Minute
+const MAXAVATARSIZE = 5 << 20
+const VERIFYTOKENTTL = 24 * time.Hour
+const RESETTOKENTTL = 15 * time.Minute
 
 // middlewares
+
+// ApiKeyCheck lets through requests carrying the key of an api client that
+// is within its rate limit. RequireScope decides what the client may reach.
 func (app *App) ApiKeyCheck() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		api_key := c.GetHeader("x-api-key")
-		API_KEY := os.Getenv("API_KEY")
-		if api_key == API_KEY {
-			c.Next()
-		} else {
+		if api_key == "" {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		client, err := app.apiClient(api_key)
+		if errors.Is(err, store.ErrNotFound) {
 			c.AbortWithStatus(http.StatusForbidden)
+			return
 		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+			return
+		}
+		allowed, err := app.allowRequest(c, client)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+			return
+		}
+		if !allowed {
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"message": "api key rate limit exceeded"})
+			return
+		}
+		c.Set(APICLIENTKEY, client)
+		c.Next()
 	}
 }
 
@@ -47,15 +84,38 @@ func (app *App) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenValue := c.GetHeader("Authorization")
 		claims := &models.Claims{}
-		tkn, err := jwt.ParseWithClaims(tokenValue, claims,
-			func(token *jwt.Token) (interface{}, error) {
-				return []byte(os.Getenv("JWT_SECRET")), nil
-			})
+		tkn, err := functions.ParseToken(tokenValue, claims)
 		if err != nil {
 			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		if tkn == nil || !tkn.Valid || claims.Type != models.TokenAccess {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
 		}
-		if tkn == nil || !tkn.Valid || functions.IsTokenBlacklisted(tokenValue) {
+		// signing out ends the session, which takes its access tokens with it
+		session, err := app.Sessions.Active(claims.Sid)
+		if errors.Is(err, store.ErrNotFound) || err == nil && session.Uid != claims.Uid {
 			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+			return
+		}
+		// suspensions and bans take effect on tokens handed out before them
+		user, err := app.Users.Get(claims.Uid)
+		if errors.Is(err, store.ErrNotFound) {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+			return
+		}
+		if user.Blocked(time.Now()) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": blockedMessage(user)})
+			return
 		}
 		c.Next()
 	}
@@ -88,73 +148,78 @@ func (app *App) DDOSPrevent() gin.HandlerFunc {
 	}
 }
 
+// RequirePermission lets the request through only when the role of the
+// signed in user has every one of the permissions.
+func (app *App) RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := app.Users.Get(functions.GetUserId(c.GetHeader("Authorization")))
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+			return
+		}
+		for _, permission := range permissions {
+			if !models.HasPermission(user.Role, permission) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "missing permission " + permission})
+				return
+			}
+		}
+		if app.twoFactorRequired(user.Role) {
+			tf, err := app.TwoFactor.Get(user.Id)
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+				return
+			}
+			if !tf.Enabled {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "enable two-factor authentication to use your role"})
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// RequireVerifiedEmail keeps users who haven't confirmed their address away
+// from borrowing and buying.
+func (app *App) RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := app.Users.Get(functions.GetUserId(c.GetHeader("Authorization")))
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+			return
+		}
+		if !user.EmailVerified {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "verify your email address first"})
+			return
+		}
+		c.Next()
+	}
+}
+
 // get books
 func (app *App) GetBooks(c *gin.Context) {
-	books := []models.LowBook{}
-	gotbooks, err := app.DB.Query("SELECT book_id, title, image_url, price, avg_rate, rate_count FROM book ORDER BY RANDOM() LIMIT 1000")
+	var page models.Page
+	if err := c.ShouldBindQuery(&page); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	books, err := app.Books.List(page)
+	if errors.Is(err, store.ErrInvalidPage) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer gotbooks.Close()
-	for gotbooks.Next() {
-		var book_id int
-		var title string
-		var image_url string
-		var price, count int
-		var rate float64
-		if err := gotbooks.Scan(&book_id, &title, &image_url, &price, &rate, &count); err != nil {
-			log.Println("Couldn't bind book")
-		} else {
-			book := models.LowBook{
-				Title:    title,
-				Id:       book_id,
-				ImageUrl: image_url,
-				Price:    price,
-				Rate:     rate,
-				Count:    count,
-			}
-			books = append(books, book)
-		}
-	}
 	c.JSON(http.StatusOK, books)
 }
 
 func (app *App) GetNewBooks(c *gin.Context) {
-	res, err := app.DB.Query("SELECT * FROM newbook")
-	if err != nil {
-		c.AbortWithStatus(http.StatusNotFound)
-		return
-	}
-	bids := []int{}
-	for res.Next() {
-		var bid int
-		var t time.Time
-		res.Scan(&bid, &t)
-		if time.Since(t) > time.Duration(720)*time.Hour {
-			app.DB.Exec("DELETE * FROM newbook WHERE book_id=$1", bid)
-		} else {
-			bids = append(bids, bid)
-		}
-	}
-	res.Close()
-	placeholders := []string{}
-	for i := range bids {
-		placeholders = append(placeholders, fmt.Sprintf("$%d", i+1))
-	}
-	query := fmt.Sprintf("SELECT book_id, title, image_url, price, avg_rate, rate_count FROM book WHERE book_id IN (%s)", strings.Join(placeholders, ", "))
-	res, err = app.DB.Query(query, functions.ConvertToInterfaceSlice(bids)...)
+	books, err := app.Books.NewBooks(time.Duration(720) * time.Hour)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	defer res.Close()
-	books := []models.LowBook{}
-	for res.Next() {
-		var book models.LowBook
-		res.Scan(&book.Id, &book.Title, &book.ImageUrl, &book.Price, &book.Rate, &book.Count)
-		books = append(books, book)
-	}
 	c.JSON(http.StatusOK, books)
 }
 
@@ -164,87 +229,33 @@ func (app *App) GetBook(c *gin.Context) {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
-	gotbooks, err := app.DB.Query("SELECT book_id, title, isbn, image_url, publication_date, isbn13, num_pages, publisher, book_format, description, price, quantity_sale, quantity_lib, avg_rate, rate_count FROM book WHERE book_id = $1", bid)
-	if err != nil || !gotbooks.Next() {
+	book, err := app.Books.Get(bid)
+	if errors.Is(err, store.ErrNotFound) {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
-	defer gotbooks.Close()
-	var book_id int
-	var title string
-	var isbn string
-	var image_url string
-	var publication_date time.Time
-	var isbn13 string
-	var num_pages int
-	var publisher string
-	var book_format string
-	var description string
-	var price int
-	var quantity_sale int
-	var quantity_lib, rate_count int
-	var avg_rate float64
-	if err := gotbooks.Scan(&book_id, &title, &isbn, &image_url, &publication_date, &isbn13, &num_pages, &publisher, &book_format, &description, &price, &quantity_sale, &quantity_lib, &avg_rate, &rate_count); err != nil {
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
-	} else {
-		genres := []string{}
-		genreRow, err := app.DB.Query("SELECT genre FROM book_genre WHERE book_id=$1", book_id)
-		if err == nil {
-			for genreRow.Next() {
-				var g string
-				if err := genreRow.Scan(&g); err != nil {
-					log.Println("Couldn't bind author")
-				} else {
-					genres = append(genres, g)
-				}
-			}
+	}
+	if book.WorkId != 0 {
+		if book.Editions, err = app.Books.Editions(bid); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+			return
 		}
-		authors := []models.AuthorR{}
-		authorRow, err := app.DB.Query("SELECT role, author_id FROM book_author WHERE book_id=$1", book_id)
-		if err == nil {
-			for authorRow.Next() {
-				var role, authorid string
-				if err := authorRow.Scan(&role, &authorid); err != nil {
-					log.Println("Couldn't bind author")
-				} else {
-					aid, _ := strconv.Atoi(authorid)
-					tempi, err := app.DB.Query("SELECT name FROM authors WHERE author_id=$1", aid)
-					if err == nil {
-						tempi.Next()
-						var name string
-						if err := tempi.Scan(&name); err == nil {
-							newauth := models.AuthorR{
-								Author: name,
-								Role:   role,
-							}
-							authors = append(authors, newauth)
-						}
-					}
-				}
-			}
+	}
+	if book.SeriesId != 0 {
+		next, err := app.Series.Next(bid)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+			return
 		}
-		book := models.Book{
-			Title:           title,
-			Id:              book_id,
-			Isbn:            isbn,
-			ImageUrl:        image_url,
-			PublicationDate: publication_date,
-			Isbn13:          isbn13,
-			NumberOfPages:   num_pages,
-			Publisher:       publisher,
-			Format:          book_format,
-			Description:     description,
-			QuantityForSale: quantity_sale,
-			QuantityInLib:   quantity_lib,
-			Price:           price,
-			Authors:         authors,
-			Genres:          genres,
-			AverageRate:     avg_rate,
-			RateCount:       rate_count,
+		if err == nil {
+			book.NextInSeries = &next
 		}
-		c.JSON(http.StatusOK, book)
 	}
+	book.Covers = images.CoverSet(book.ImageUrl)
+	c.JSON(http.StatusOK, book)
 }
 
 func (app *App) CheckIfFaved(c *gin.Context) {
@@ -254,12 +265,11 @@ func (app *App) CheckIfFaved(c *gin.Context) {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
-	res, err := app.DB.Query("SELECT * FROM user_fave WHERE book_id=$1 AND user_id=$2", bid, uid)
-	if err != nil || !res.Next() {
+	faved, err := app.Users.IsFaved(uid, bid)
+	if err != nil || !faved {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
-	res.Close()
 	c.String(http.StatusOK, "Added before")
 }
 
@@ -269,43 +279,74 @@ func (app *App) FilterBooks(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	startdate := time.Date(filters.StartDate, 1, 1, 0, 0, 0, 0, time.UTC)
-	enddate := time.Date(filters.EndDate, 12, 31, 23, 59, 59, 0, time.UTC)
-	var queryBuilder strings.Builder
-	var args []interface{}
-	queryBuilder.WriteString("SELECT book_id, title, image_url, price, avg_rate, rate_count FROM book WHERE")
-	queryBuilder.WriteString("(LOWER(title) LIKE $1 OR LOWER(publisher) LIKE $1) AND ")
-	args = append(args, fmt.Sprintf("%%%s%%", strings.ToLower(filters.Search)))
-	queryBuilder.WriteString("num_pages BETWEEN $2 AND $3 AND publication_date BETWEEN $4 AND $5 ")
-	args = append(args, filters.MinPages, filters.MaxPages, startdate, enddate)
-	if len(filters.Genres) > 0 {
-		queryBuilder.WriteString("AND book_id IN (SELECT book_id FROM book_genre WHERE genre = ANY($6)) ")
-		args = append(args, pq.Array(filters.Genres))
+	books, err := app.Books.Filter(filters)
+	if errors.Is(err, store.ErrInvalidPage) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	queryBuilder.WriteString("ORDER BY RANDOM() LIMIT 1000")
-	query := queryBuilder.String()
-	res, err := app.DB.Query(query, args...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer res.Close()
-	var books []models.LowBook
-	for res.Next() {
-		var b models.LowBook
-		if err := res.Scan(&b.Id, &b.Title, &b.ImageUrl, &b.Price, &b.Rate, &b.Count); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		books = append(books, b)
-	}
-	if len(books) == 0 {
+	if len(books.Books) == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"message": "No books found"})
 		return
 	}
 	c.JSON(http.StatusOK, books)
 }
 
+func (app *App) Search(c *gin.Context) {
+	var filters models.Filter
+	if err := c.ShouldBindQuery(&filters.Page); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filters.Search = c.Query("q")
+	// punctuation alone normalizes to nothing, which would match every book
+	if len(functions.SearchTerms(filters.Search)) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "empty search query"})
+		return
+	}
+	filters.Genres = c.QueryArray("genre")
+	filters.Formats = c.QueryArray("format")
+	filters.Authors = c.QueryArray("author")
+	filters.Languages = c.QueryArray("language")
+	books, err := app.Books.Filter(filters)
+	if errors.Is(err, store.ErrInvalidPage) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, books)
+}
+
+func (app *App) Suggest(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "empty search query"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(SUGGESTLIMIT)))
+	if err != nil || limit <= 0 || limit > 2*SUGGESTLIMIT {
+		limit = SUGGESTLIMIT
+	}
+	key := fmt.Sprintf("%d:%s", limit, functions.NormalizePersian(q))
+	if suggestions, ok := app.Suggestions.Get(key); ok {
+		c.JSON(http.StatusOK, suggestions)
+		return
+	}
+	suggestions, err := app.Books.Suggest(q, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	app.Suggestions.Set(key, suggestions)
+	c.JSON(http.StatusOK, suggestions)
+}
+
 func (app *App) FaveOrUnfave(c *gin.Context) {
 	uid := functions.GetUserId(c.GetHeader("Authorization"))
 	var js struct {
@@ -316,19 +357,23 @@ func (app *App) FaveOrUnfave(c *gin.Context) {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
-	res, err := app.DB.Query("SELECT * FROM user_fave WHERE book_id=$1 AND user_id=$2", js.Id, uid)
-	log.Println(js.Id)
+	faved, err := app.Users.IsFaved(uid, js.Id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	defer res.Close()
-	if !res.Next() {
-		app.DB.Exec("INSERT INTO user_fave(book_id, user_id) values($1, $2)", js.Id, uid)
+	if !faved {
+		if err := app.Users.AddFave(uid, js.Id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+			return
+		}
 		c.String(http.StatusOK, "Book added to faves")
 		return
 	}
-	app.DB.Exec("DELETE FROM user_fave WHERE book_id=$1 AND user_id=$2", js.Id, uid)
+	if err := app.Users.RemoveFave(uid, js.Id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
 	c.String(http.StatusOK, "Book deleted from faves")
 }
 
@@ -340,45 +385,40 @@ func (app *App) RateBook(c *gin.Context) {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
-	var rating int
-	var b bool = false
-	res, err := app.DB.Query("SELECT rating FROM user_rating WHERE user_id=$1 AND book_id=$2", uid, rate.Bid)
+	book, err := app.Books.Get(rate.Bid)
+	if errors.Is(err, store.ErrNotFound) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	if res.Next() {
-		res.Scan(&rating)
-		b = true
-	}
-	date_added := time.Now()
-	_, err = app.DB.Exec("INSERT INTO user_rating(user_id, book_id, rating, review, date_added) values($1, $2, $3, $4, $5)", uid, rate.Bid, rate.Rating, rate.Review, date_added)
-	if err != nil {
+	rated := true
+	rating, err := app.Ratings.Rating(uid, rate.Bid)
+	if errors.Is(err, store.ErrNotFound) {
+		rated = false
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	res.Close()
-	res, err = app.DB.Query("SELECT avg_rate, rate_count FROM book WHERE book_id=$1", rate.Bid)
-	if err != nil {
+	if err := app.Ratings.SaveRating(uid, rate, time.Now()); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	defer res.Close()
-	res.Next()
-	var avg float64
-	var count int
-	res.Scan(&avg, &count)
-	if b {
-		avg = avg * float64(count)
+	avg := book.AverageRate * float64(book.RateCount)
+	count := book.RateCount
+	if rated {
 		avg = avg - float64(rating) + float64(rate.Rating)
-		avg /= float64(count)
 	} else {
-		avg = avg * float64(count)
-		avg += +float64(rate.Rating)
+		avg += float64(rate.Rating)
 		count++
-		avg /= float64(count)
 	}
-	app.DB.Exec("UPDATE book SET avg_rate=$1, rate_count=$2 WHERE book_id=$3", avg, count, rate.Bid)
+	avg /= float64(count)
+	if err := app.Books.SetRating(rate.Bid, avg, count); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
 	c.String(http.StatusOK, "Rate added")
 }
 
@@ -390,149 +430,140 @@ func (app *App) CommentOnBook(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	_, err = app.DB.Exec("INSERT INTO comment(book_id, user_id, review, date_added) values($1, $2, $3, $4)", rate.Bid, uid, rate.Review, time.Now())
-	if err != nil {
+	if err := app.Ratings.AddComment(uid, rate, time.Now()); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 	c.String(http.StatusOK, "Comment added")
 }
 
 func (app *App) GetComments(c *gin.Context) {
 	book_id, _ := strconv.Atoi(c.Param("book_id"))
-	res, err := app.DB.Query("SELECT (firstname || ' ' || lastname) as name, review FROM comment INNER JOIN users ON comment.user_id=users.user_id WHERE comment.book_id=$1", book_id)
+	comments, err := app.Ratings.Comments(book_id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	defer res.Close()
-	comments := []models.UserComment{}
-	for res.Next() {
-		comment := models.UserComment{}
-		if err := res.Scan(&comment.Name, &comment.Comment); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
-			return
-		}
-		comments = append(comments, comment)
-	}
 	if len(comments) == 0 {
 		c.String(http.StatusNotFound, "No comments found")
+		return
 	}
 	c.JSON(http.StatusOK, comments)
 }
 
 func (app *App) GetRates(c *gin.Context) {
 	book_id, _ := strconv.Atoi(c.Param("book_id"))
-	res, err := app.DB.Query("SELECT (firstname || ' ' || lastname) as name, review, rating FROM user_rating INNER JOIN users ON user_rating.user_id=users.user_id WHERE user_rating.book_id=$1", book_id)
+	comments, err := app.Ratings.Ratings(book_id)
 	if err != nil {
-		c.AbortWithStatus(http.StatusBadRequest)
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	defer res.Close()
-	comments := []models.UserComment{}
-	for res.Next() {
-		comment := models.UserComment{}
-		if err := res.Scan(&comment.Name, &comment.Comment, &comment.Rate); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
-			return
-		}
-		comments = append(comments, comment)
-	}
 	if len(comments) == 0 {
 		c.String(http.StatusNotFound, "No comments found")
+		return
 	}
 	c.JSON(http.StatusOK, comments)
 }
 
 func (app *App) GetFavedBooks(c *gin.Context) {
 	uid := functions.GetUserId(c.GetHeader("Authorization"))
-	res, err := app.DB.Query("SELECT book_id FROM user_fave WHERE user_id=$1", uid)
-	if err != nil || !res.Next() {
+	bids, err := app.Users.Faves(uid)
+	if err != nil || len(bids) == 0 {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
-	bids := []int{}
-	var bid int
-	res.Scan(&bid)
-	bids = append(bids, bid)
-	for res.Next() {
-		var bid int
-		res.Scan(&bid)
-		bids = append(bids, bid)
-	}
-	placeholders := []string{}
-	res.Close()
-	for i := range bids {
-		placeholders = append(placeholders, fmt.Sprintf("$%d", i+1))
-	}
-	query := fmt.Sprintf("SELECT book_id, title, image_url, price, avg_rate, rate_count FROM book WHERE book_id IN (%s)", strings.Join(placeholders, ", "))
-	res, err = app.DB.Query(query, functions.ConvertToInterfaceSlice(bids)...)
+	books, err := app.Books.ByIds(bids)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	defer res.Close()
-	books := []models.LowBook{}
-	for res.Next() {
-		var book models.LowBook
-		res.Scan(&book.Id, &book.Title, &book.ImageUrl, &book.Price, &book.Rate, &book.Count)
-		books = append(books, book)
-	}
 	c.JSON(http.StatusOK, books)
 }
 
 // user section
 func (app *App) Login(c *gin.Context) {
-	user := models.UserLogin{}
-	err := c.BindJSON(&user)
+	login := models.UserLogin{}
+	err := c.BindJSON(&login)
 	if err != nil {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
-	user.Email = strings.ToLower(user.Email)
-	res, err := app.DB.Query("SELECT user_id, password FROM users WHERE email=$1", user.Email)
-	if err != nil || !res.Next() {
-		c.AbortWithStatus(http.StatusNotFound)
+	login.Email = strings.ToLower(login.Email)
+	until, err := app.lockedUntil("login:account:"+login.Email, "login:ip:"+c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	if !until.IsZero() {
+		tooManyAttempts(c, until)
+		return
+	}
+	// unknown emails and wrong passwords get the same answer in the same
+	// time, so nobody can find out who has an account
+	user, err := app.Users.GetByEmail(login.Email)
+	if errors.Is(err, store.ErrNotFound) {
+		functions.CompareHashAndPassword(noAccountHash, login.Password)
+		if err := app.loginFailed(c, login.Email, nil); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"message": wrongCredentials})
 		return
 	}
-	defer res.Close()
-	var id int
-	var pass string
-	err = res.Scan(&id, &pass)
-	log.Println(id, pass)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	err = functions.CompareHashAndPassword(pass, user.Password)
+	err = functions.CompareHashAndPassword(user.Password, login.Password)
 	if err != nil {
-		c.AbortWithStatus(http.StatusNotAcceptable)
+		app.auditAs(c, 0, "account.login_failed", "user", user.Id, nil, nil)
+		if err := app.loginFailed(c, login.Email, &user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"message": wrongCredentials})
+		return
+	}
+	if err := app.Attempts.Reset("login:account:" + login.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	if user.Blocked(time.Now()) {
+		c.JSON(http.StatusForbidden, gin.H{"message": blockedMessage(user)})
+		return
+	}
+	if user.MustResetPassword {
+		c.JSON(http.StatusForbidden, gin.H{"message": "password reset required, check your email"})
+		return
+	}
+	tf, err := app.TwoFactor.Get(user.Id)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	expirationTime := time.Now().Add(60 * time.Minute)
-	claims := &models.Claims{
-		Uid: id,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
-		},
+	if tf.Enabled {
+		challenge, expires, err := challengeToken(user.Id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, models.ChallengeOutput{TwoFactorRequired: true, ChallengeToken: challenge, Expires: expires})
+		return
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	jwtOutput, err := app.startSession(c, user.Id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError,
 			gin.H{"error": err.Error()})
 		return
 	}
-	jwtOutput := models.JWTOutput{
-		Token:   tokenString,
-		Expires: expirationTime,
-	}
+	app.auditAs(c, user.Id, "account.login", "user", user.Id, nil, nil)
 	c.JSON(http.StatusOK, jwtOutput)
 }
 
 func (app *App) Logout(c *gin.Context) {
 	token := c.GetHeader("Authorization")
-	err := functions.BlacklistToken(token, time.Hour*24)
-	if err != nil {
+	err := app.Sessions.Revoke(functions.GetUserId(token), functions.GetSessionId(token))
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
@@ -548,109 +579,337 @@ func (app *App) Signup(c *gin.Context) {
 		return
 	}
 	user.Email = strings.ToLower(user.Email)
-	res, err := app.DB.Query("SELECT * FROM users WHERE email=$1", user.Email)
-	if res.Next() || err != nil {
+	if _, err := app.Users.GetByEmail(user.Email); !errors.Is(err, store.ErrNotFound) {
 		c.JSON(http.StatusNotAcceptable, gin.H{"message": "email alerady exists"})
 		return
 	}
-	res.Close()
+	if err := functions.ValidatePassword(user.Password, user.Email); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
 	user.Password, err = functions.HashPassword(user.Password)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	res, _ = app.DB.Query("SELECT user_id FROM users ORDER BY user_id DESC LIMIT 1")
-	defer res.Close()
-	res.Next()
-	var id int
-	res.Scan(&id)
-	user.Id = id + 1
-	user.Role = false
-	user.Image = "tempo"
-	app.DB.Exec("INSERT INTO users(user_id, firstname, lastname, password, email, image, role) values ($1, $2, $3, $4, $5, $6, $7)", user.Id, user.Firstname, user.Lastname, user.Password, user.Email, user.Image, user.Role)
-	subject := "Bookstore sign up"
-	body := fmt.Sprintf(`
-		<h1>Welcome %s %s<h1>
-        <p>We're glad that you decided to use our service. Hope you can find what you seek in our web app</p>
-    `, user.Firstname, user.Lastname)
-	functions.SendEmail(user.Email, subject, body, app.Email)
+	user.Role = models.RoleCustomer
+	user.Image = ""
+	user.EmailVerified = false
+	if err := app.Users.Create(&user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	app.auditAs(c, user.Id, "account.signup", "user", user.Id, nil, nil)
+	// the account exists either way; the user can ask for another mail
+	if err := app.sendVerifyMail(user); err != nil {
+		log.Println("Couldn't send verification mail:", err)
+	}
 	c.String(http.StatusOK, "Signup successful")
 }
 
+func (app *App) sendVerifyMail(user models.User) error {
+	token := functions.SignEmailToken("verify-email", user.Id, user.Email, time.Now().Add(VERIFYTOKENTTL))
+	return functions.SendVerifyEmail(user.Email, user.Firstname+" "+user.Lastname, token, app.Email)
+}
+
+func (app *App) VerifyEmail(c *gin.Context) {
+	uid, email, err := functions.ParseEmailToken("verify-email", c.Param("token"))
+	if errors.Is(err, functions.ErrExpiredToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"Error": "Token expired, ask for a new verification email"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"Error": "Invalid token"})
+		return
+	}
+	user, err := app.Users.Get(uid)
+	if errors.Is(err, store.ErrNotFound) || err == nil && user.Email != email {
+		c.JSON(http.StatusBadRequest, gin.H{"Error": "Invalid token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	if !user.EmailVerified {
+		if err := app.Users.SetEmailVerified(uid); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+			return
+		}
+		app.auditAs(c, uid, "account.email_verified", "user", uid, nil, nil)
+	}
+	c.JSON(http.StatusOK, gin.H{"Message": "Email verified"})
+}
+
+func (app *App) ResendVerification(c *gin.Context) {
+	user, err := app.Users.Get(functions.GetUserId(c.GetHeader("Authorization")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	if user.EmailVerified {
+		c.JSON(http.StatusConflict, gin.H{"message": "email already verified"})
+		return
+	}
+	if err := app.sendVerifyMail(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"Message": "Verification email sent"})
+}
+
 func (app *App) GetUserProfile(c *gin.Context) {
 	id := functions.GetUserId(c.GetHeader("Authorization"))
-	res, err := app.DB.Query("SELECT firstname, lastname, image FROM users WHERE user_id=$1", id)
+	user, err := app.Users.Get(id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	defer res.Close()
-	res.Next()
-	var fname, lname, image string
-	res.Scan(&fname, &lname, &image)
-	if image == "tempo" {
-		image = ""
-	}
 	c.JSON(http.StatusOK, gin.H{
-		"firstname": fname,
-		"lastname":  lname,
-		"image":     image,
+		"firstname":     user.Firstname,
+		"lastname":      user.Lastname,
+		"image":         user.Image,
+		"avatar_public": user.AvatarPublic,
 	})
 }
 
-func (apap *App) GetProfPic(c *gin.Context) {
-	fname := c.Param("image")
-	fdir := os.Getenv("FILE_DIR")
-	c.File(fdir + "/" + fname)
+// GetProfPic only serves the caller's own picture, so names can't be
+// guessed to read other files.
+func (app *App) GetProfPic(c *gin.Context) {
+	user, err := app.Users.Get(functions.GetUserId(c.GetHeader("Authorization")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	if user.Image == "" || user.Image != c.Param("image") {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	app.serveAvatar(c, user.Image)
 }
 
-func (app *App) GetUserInfo(c *gin.Context) {
+// GetAvatar serves the picture of a user by id to show next to their
+// comments and ratings. Other users only get it once its owner made it
+// public.
+func (app *App) GetAvatar(c *gin.Context) {
+	uid, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	user, err := app.Users.Get(uid)
+	if errors.Is(err, store.ErrNotFound) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	caller := functions.GetUserId(c.GetHeader("Authorization"))
+	if user.Image == "" || user.Id != caller && !user.AvatarPublic {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	app.serveAvatar(c, user.Image)
+}
+
+// SetAvatarVisibility lets the user choose if others may see their picture.
+func (app *App) SetAvatarVisibility(c *gin.Context) {
 	uid := functions.GetUserId(c.GetHeader("Authorization"))
-	res, err := app.DB.Query("SELECT firstname, lastname, email, image, role FROM users WHERE user_id=$1", uid)
+	var request struct {
+		Public *bool `json:"public" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+	if err := app.Users.SetAvatarPublic(uid, *request.Public); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Image visibility updated", "avatar_public": *request.Public})
+}
+
+func (app *App) serveAvatar(c *gin.Context, image string) {
+	size := images.AvatarSizes[0]
+	if s, err := strconv.Atoi(c.Query("size")); err == nil && slices.Contains(images.AvatarSizes, s) {
+		size = s
+	}
+	url, err := app.Blobs.URL(images.AvatarKey(image, size), PROFILEURLTTL)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	defer res.Close()
-	res.Next()
-	var user models.User
-	if err = res.Scan(&user.Firstname, &user.Lastname, &user.Email, &user.Image, &user.Role); err != nil {
+	c.Redirect(http.StatusFound, url)
+}
+
+func (app *App) GetUserInfo(c *gin.Context) {
+	uid := functions.GetUserId(c.GetHeader("Authorization"))
+	user, err := app.Users.Get(uid)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, user)
+	c.JSON(http.StatusOK, models.User{
+		Firstname:     user.Firstname,
+		Lastname:      user.Lastname,
+		Email:         user.Email,
+		Image:         user.Image,
+		AvatarPublic:  user.AvatarPublic,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerified,
+	})
 }
 
 func (app *App) UploadImage(c *gin.Context) {
 	uid := functions.GetUserId(c.GetHeader("Authorization"))
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MAXAVATARSIZE+1<<20)
 	file, err := c.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) || err == nil && file.Size > MAXAVATARSIZE {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"Error": "profile images can't be larger than 5MB"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"Error": "Error occured during getting file"})
 		return
 	}
-	fileDir := os.Getenv("FILE_DIR")
-	res, err := app.DB.Query("SELECT image from users WHERE user_id=$1", uid)
+	user, err := app.Users.Get(uid)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	content, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
 	}
-	var img string
-	defer res.Close()
-	res.Scan(&img)
-	if img != "tempo" {
-		os.Remove(fmt.Sprintf("%s/%s", fileDir, img))
+	defer content.Close()
+	img, err := images.Decode(content)
+	if errors.Is(err, images.ErrNotImage) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"Error": err.Error()})
+		return
 	}
-	img = fmt.Sprintf("%d_%s", uid, file.Filename)
-	err = c.SaveUploadedFile(file, fmt.Sprintf("%s/%s", fileDir, img))
+	if errors.Is(err, images.ErrTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"Error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+	name, err := app.putAvatars(img)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	_, err = app.DB.Exec("UPDATE users SET image=$1 WHERE user_id=$2", img, uid)
+	if err := app.Users.SetImage(uid, name); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	if user.Image != "" && user.Image != name {
+		app.removeAvatar(user.Image)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Image added successfully", "image": name})
+}
+
+// DeleteImage goes back to the default picture, which clients show for an
+// empty image.
+func (app *App) DeleteImage(c *gin.Context) {
+	uid := functions.GetUserId(c.GetHeader("Authorization"))
+	user, err := app.Users.Get(uid)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	c.String(http.StatusOK, "Image added successfully")
+	if user.Image == "" {
+		c.JSON(http.StatusOK, gin.H{"message": "no image to delete"})
+		return
+	}
+	if err := app.Users.SetImage(uid, ""); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	app.removeAvatar(user.Image)
+	c.JSON(http.StatusOK, gin.H{"message": "Image deleted successfully"})
+}
+
+// removeAvatar deletes the files of a picture nobody points at anymore.
+// Pictures are named by their content, so two users uploading the same one
+// share the files.
+func (app *App) removeAvatar(image string) {
+	used, err := app.Users.ImageInUse(image)
+	if err != nil || used {
+		return
+	}
+	for _, key := range images.AvatarKeys(image) {
+		app.Blobs.Delete(key)
+	}
+}
+
+// putAvatars stores every size of a picture and returns its name.
+func (app *App) putAvatars(img image.Image) (string, error) {
+	name, avatars, err := images.Avatars(img)
+	if err != nil {
+		return "", err
+	}
+	for _, avatar := range avatars {
+		if err := app.Blobs.Put(avatar.Key, bytes.NewReader(avatar.Data), "image/jpeg"); err != nil {
+			return "", err
+		}
+	}
+	return name, nil
+}
+
+// MigrateAvatars moves pictures uploaded before they were resized out of
+// dir, where they were saved as is, into the blob store in every size. It
+// returns how many users it moved; pictures it can't read are logged and
+// left alone, so it can simply be run again.
+func (app *App) MigrateAvatars(dir string) (int, error) {
+	moved := 0
+	filter := models.UserFilter{Page: models.Page{Limit: store.MaxLimit}}
+	for {
+		page, err := app.Users.List(filter)
+		if err != nil {
+			return moved, err
+		}
+		for _, user := range page.Users {
+			if user.Image == "" || !images.LegacyAvatar(user.Image) {
+				continue
+			}
+			name, err := app.migrateAvatar(dir, user.Image)
+			if err != nil {
+				log.Printf("Couldn't move picture %s of user %d: %v", user.Image, user.Id, err)
+				continue
+			}
+			if err := app.Users.SetImage(user.Id, name); err != nil {
+				return moved, err
+			}
+			moved++
+		}
+		if page.NextCursor == "" {
+			return moved, nil
+		}
+		filter.Cursor = page.NextCursor
+	}
+}
+
+func (app *App) migrateAvatar(dir, image string) (string, error) {
+	// legacy names were made by the server, but check anyway
+	if filepath.Base(image) != image {
+		return "", store.ErrInvalidKey
+	}
+	file, err := os.Open(filepath.Join(dir, image))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	img, err := images.Decode(file)
+	if err != nil {
+		return "", err
+	}
+	return app.putAvatars(img)
 }
 
 func (app *App) ResetPasswordMail(c *gin.Context) {
@@ -661,58 +920,101 @@ func (app *App) ResetPasswordMail(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"Error": "Wrong JSON format"})
 		return
 	}
-	res, err := app.DB.Query("SELECT * FROM users WHERE email=$1", request.Email)
+	email := strings.ToLower(request.Email)
+	allowed, err := app.allowResetMail(c, email)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	defer res.Close()
-	if !res.Next() {
-		c.JSON(http.StatusNotFound, gin.H{"Error": "No user found with given Email"})
+	if !allowed {
+		c.JSON(http.StatusTooManyRequests, gin.H{"message": "too many attempts, try again later"})
 		return
 	}
-	token, err := functions.GenerateToken()
-	if err != nil {
+	// the answer is the same whether or not the email has an account
+	user, err := app.Users.GetByEmail(email)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	app.ResetToken[token] = request.Email
-	go func() {
-		time.Sleep(15 * time.Minute)
-		delete(app.ResetToken, token)
-	}()
-	if err = functions.SendResetPassEmail(request.Email, token, app.Email); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
-		return
+	if err == nil {
+		app.auditAs(c, 0, "account.reset_requested", "user", user.Id, nil, nil)
+		if err := app.sendResetMail(user); err != nil {
+			log.Println("Couldn't send reset mail:", err)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"Message": "If the email has an account, a reset link was sent to it"})
+}
+
+// sendResetMail mails user a link to choose a new password. Only the hash
+// of its token is stored.
+func (app *App) sendResetMail(user models.User) error {
+	token, err := functions.GenerateToken()
+	if err != nil {
+		return err
 	}
-	c.JSON(http.StatusOK, gin.H{"Message": "Reset Password Email sent"})
+	if err := app.Resets.Create(user.Id, functions.HashToken(token), time.Now().Add(RESETTOKENTTL)); err != nil {
+		return err
+	}
+	return functions.SendResetPassEmail(user.Email, token, app.Email)
 }
 
+// ResetPassword sets the new password, uses up the token and signs the user
+// out everywhere, in case the old password was known to someone else.
 func (app *App) ResetPassword(c *gin.Context) {
-	token := c.Param("token")
-	email, ok := app.ResetToken[token]
-	if !ok {
+	hash := functions.HashToken(c.Param("token"))
+	uid, err := app.Resets.Lookup(hash)
+	if errors.Is(err, store.ErrNotFound) {
 		c.JSON(http.StatusBadRequest, gin.H{"Error": "Invalid token"})
 		return
 	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
 	var pass struct {
 		Pass string `json:"password"`
 	}
-	err := c.ShouldBind(&pass)
+	err = c.ShouldBind(&pass)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"Error": "Bad JSON format"})
 		return
 	}
-	password, err := functions.HashPassword(pass.Pass)
+	user, err := app.Users.Get(uid)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	_, err = app.DB.Exec("UPDATE users SET password=$1 WHERE email=$2", password, email)
+	if err := functions.ValidatePassword(pass.Pass, user.Email); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+	password, err := functions.HashPassword(pass.Pass)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
+	// another request may have used the token since it was looked up
+	if _, err := app.Resets.Consume(hash); errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"Error": "Invalid token"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	if err := app.Users.SetPassword(user.Email, password); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	if err := app.Sessions.RevokeAll(uid); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	// whoever can read the mail may sign in again right away
+	if err := app.Attempts.Reset("login:account:" + user.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	app.auditAs(c, uid, "account.password_reset", "user", uid, nil, nil)
 	c.JSON(http.StatusOK, gin.H{"Message": "Password changed successfully"})
 }
 
@@ -741,210 +1043,133 @@ func (app *App) RecommendByRates(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"message": "no books found for user"})
 		return
 	}
-	placeholders := []string{}
-	for i := range bids {
-		placeholders = append(placeholders, fmt.Sprintf("$%d", i+1))
-	}
-	query := fmt.Sprintf("SELECT book_id, title, image_url, price, avg_rate, rate_count FROM book WHERE book_id IN (%s)", strings.Join(placeholders, ", "))
-	res2, err := app.DB.Query(query, functions.ConvertToInterfaceSlice(bids)...)
+	books, err := app.Books.ByIds(bids)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	defer res2.Close()
-	books := []models.LowBook{}
-	for res2.Next() {
-		var book models.LowBook
-		res2.Scan(&book.Id, &book.Title, &book.ImageUrl, &book.Price, &book.Rate, &book.Count)
-		books = append(books, book)
-	}
 	c.JSON(http.StatusOK, books)
 }
 
 func (app *App) RecommendByRecord(c *gin.Context) {
 	FP_GROWTH_ROUTE := os.Getenv("FP_GROWTH_ROUTE")
 	id := functions.GetUserId(c.GetHeader("Authorization"))
-	res, err := app.DB.Query("SELECT book_id FROM user_read WHERE userid = $1", id)
-	if err != nil || !res.Next() {
+	bids, err := app.Users.Reads(id)
+	if err != nil || len(bids) == 0 {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
-	var bids []int
-	var bid int
-	if err := res.Scan(&bid); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
-	}
-	bids = append(bids, bid)
-	for res.Next() {
-		var bid int
-		if err := res.Scan(&bid); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
-		}
-		bids = append(bids, bid)
-	}
-	res.Close()
 	all := []models.FPG{}
 	jsonfile, err := os.Open(FP_GROWTH_ROUTE)
 	if err != nil {
 		c.String(http.StatusBadRequest, "Noway")
+		return
 	}
+	defer jsonfile.Close()
 	byteread, err := ioutil.ReadAll(jsonfile)
 	if err != nil {
 		c.String(http.StatusBadRequest, "Noway2")
+		return
 	}
 	err = json.Unmarshal(byteread, &all)
 	if err != nil {
 		c.String(http.StatusBadRequest, "Noway3")
+		return
 	}
 	result := []int{}
 	resMap := make(map[int]struct{})
 	for i := range all {
 		if functions.CheckCompatibility(bids, all[i].Base) {
 			for _, number := range all[i].Res {
-				if _, exists := resMap[number]; !exists {
+				if _, exists := resMap[number]; !exists && !functions.Exists(number, bids) {
 					resMap[number] = struct{}{}
 					result = append(result, number)
 				}
 			}
 		}
 	}
-	for i := 0; i < len(result); i++ {
-		if functions.Exists(result[i], bids) {
-			result = append(result[:i], result[i+1:]...)
-		}
+	if len(result) == 0 {
+		c.String(http.StatusNotFound, "No books found")
+		return
 	}
-	if len(result) > 1 {
-		str := fmt.Sprintf("(%d", result[0])
-		for _, val := range result[1:] {
-			str = fmt.Sprintf("%s, %d", str, val)
-		}
-		str += ")"
-		books := []models.LowBook{}
-		res, err := app.DB.Query(fmt.Sprintf("SELECT title, book_id, price, image_url, rate_count, avg_rate FROM book WHERE book_id in %s", str))
-		if err != nil {
-			c.AbortWithStatus(http.StatusNotFound)
-			return
-		}
-		for res.Next() {
-			var book models.LowBook
-			res.Scan(&book.Title, &book.Id, &book.Price, &book.ImageUrl, &book.Count, &book.Rate)
-			books = append(books, book)
-		}
-		res.Close()
-		c.JSON(http.StatusOK, books)
+	books, err := app.Books.ByIds(result)
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
 		return
-	} else if len(result) == 1 {
-		res, err := app.DB.Query("SELECT title, book_id, price, image_url FROM book WHERE book_id=$1", result[0])
-		if err != nil {
-			c.String(http.StatusNotFound, "couldn't find books")
-			return
-		}
-		res.Next()
-		var book models.LowBook
-		res.Scan(&book.Title, &book.Id, &book.Price, &book.ImageUrl)
-		c.JSON(http.StatusOK, book)
-		res.Close()
+	}
+	if len(result) == 1 && len(books) == 1 {
+		c.JSON(http.StatusOK, books[0])
 		return
 	}
-	c.String(http.StatusNotFound, "No books found")
+	c.JSON(http.StatusOK, books)
 }
 
 // book changes
 func (app *App) AddBook(c *gin.Context) {
-	id := functions.GetUserId(c.GetHeader("Authorization"))
-	res, err := app.DB.Query("SELECT role from users WHERE user_id=$1", id)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
-		return
-	}
-	res.Next()
-	var b bool
-	res.Scan(&b)
-	if !b {
-		c.AbortWithStatus(http.StatusNotAcceptable)
-		return
-	}
-	res.Close()
 	var book models.Book
-	err = c.BindJSON(&book)
+	err := c.BindJSON(&book)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	res, err = app.DB.Query("SELECT book_id FROM book ORDER BY book_id DESC LIMIT 1")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+	err = app.Books.Create(&book)
+	if errors.Is(err, store.ErrDuplicateIsbn) {
+		c.JSON(http.StatusConflict, gin.H{"Error": err.Error()})
 		return
 	}
-	defer res.Close()
-	res.Next()
-	var bid int
-	res.Scan(&bid)
-	bid += 1
-	book.Id = bid
-	_, err = app.DB.Exec("INSERT INTO book(book_id, title, isbn, image_url, publication_date, isbn13, num_pages, publisher, book_format, description, price, quantity_sale, quantity_lib, avg_rate, rate_count) VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15)", book.Id, book.Title, book.Isbn, book.ImageUrl, book.PublicationDate, book.Isbn13, book.NumberOfPages, book.Publisher, book.Format, book.Description, book.Price, book.QuantityForSale, book.QuantityInLib, book.AverageRate, book.RateCount)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	app.DB.Exec("INSERT INTO newbook(book_id, time_added) VALUES($1, $2)", bid, time.Now())
-	for _, genre := range book.Genres {
-		app.DB.Exec("INSERT INTO book_genre(book_id, genre) VALUES($1, $2)", bid, genre)
-	}
-	for _, Author := range book.Authors {
-		res, err = app.DB.Query("SELECT author_id FROM authors WHERE name=$1", Author.Author)
-		if err == nil {
-			res.Next()
-			var id int
-			res.Scan(&id)
-			app.DB.Exec("INSERT INTO book_author(book_id, author_id, role) VALUES($1, $2, $3)", bid, id, Author.Role)
-		} else {
-			res, _ = app.DB.Query("SELECT author_id FROM authors ORDER BY author_id DESC LIMIT 1")
-			res.Next()
-			var aid int
-			res.Scan(&aid)
-			aid += 1
-			app.DB.Exec("INSERT INTO authors(author_id, name) VALUES($1, $2)", aid, Author.Author)
-			app.DB.Exec("INSERT INTO book_author(book_id, author_id) VALUES($1, $2)", bid, aid)
-		}
-	}
+	app.audit(c, "book.create", "book", book.Id, nil, book)
+	app.Suggestions.Clear()
 	c.String(http.StatusOK, "book added to DB")
 }
 
 func (app *App) EditBook(c *gin.Context) {
-	id := functions.GetUserId(c.GetHeader("Authorization"))
-	res, err := app.DB.Query("SELECT role from users WHERE user_id=$1", id)
+	var book models.Book
+	if err := c.BindJSON(&book); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	before, err := app.Books.Get(book.Id)
+	if errors.Is(err, store.ErrNotFound) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	defer res.Close()
-	res.Next()
-	var b bool
-	res.Scan(&b)
-	if !b {
-		c.AbortWithStatus(http.StatusNotAcceptable)
+	err = app.Books.Update(book)
+	if errors.Is(err, store.ErrNotFound) {
+		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
-	var book models.Book
-	if err := c.BindJSON(&book); err != nil {
+	if errors.Is(err, store.ErrDuplicateIsbn) {
+		c.JSON(http.StatusConflict, gin.H{"Error": err.Error()})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	app.DB.Exec("UPDATE book SET title=$1 and isbn=$2 and image_url=$3 and publication_date=$4 and isbn13=$5 and num_pages=$6 and publisher=$7 and book_format=&8 and description=$9 and price=$10 and quantity_sale=$11 and quantity_lib=$12", book.Title, book.Isbn, book.ImageUrl, book.PublicationDate, book.Isbn13, book.NumberOfPages, book.Publisher, book.Format, book.Description, book.Price, book.QuantityForSale, book.QuantityInLib)
+	if after, err := app.Books.Get(book.Id); err == nil {
+		app.audit(c, "book.update", "book", book.Id, before, after)
+	}
+	app.Suggestions.Clear()
 	c.String(http.StatusOK, "Book updated")
 }
 
 // borrow section
 func (app *App) GetLibStatus(c *gin.Context) {
 	bid, _ := strconv.Atoi(c.Param("bookid"))
-	res, err := app.DB.Query("SELECT * FROM borrow_book WHERE book_id = $1 AND returned = 'no'", bid)
+	borrowed, err := app.Borrows.IsBorrowed(bid)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer res.Close()
-	if !res.Next() {
+	if !borrowed {
 		c.JSON(http.StatusOK, gin.H{"message": "you can borrow"})
 		return
 	}
@@ -954,35 +1179,30 @@ func (app *App) GetLibStatus(c *gin.Context) {
 func (app *App) BorrowBook(c *gin.Context) {
 	bid, _ := strconv.Atoi(c.Param("bookid"))
 	uid := functions.GetUserId(c.GetHeader("Authorization"))
-	res, err := app.DB.Query("SELECT * FROM borrow_book WHERE user_id=$1 and returned='no'", uid)
+	active, err := app.Borrows.HasActive(uid)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	if res.Next() {
+	if active {
 		c.JSON(http.StatusNotAcceptable, gin.H{"message": "user still haven't returned last borrowed book"})
 		return
 	}
-	_, err = app.DB.Exec("INSERT INTO borrow_book(book_id, user_id, returned, borrow_time) values($1, $2, 'no', $3)", bid, uid, time.Now())
-	if err != nil {
+	if err := app.Borrows.Borrow(uid, bid, time.Now()); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	res.Close()
-	res, _ = app.DB.Query("SELECT title FROM book WHERE book_id = $1", bid)
-	res.Next()
-	var title, email, name string
-	res.Scan(&title)
-	res.Close()
+	book, _ := app.Books.Get(bid)
+	user, _ := app.Users.Get(uid)
+	title, email := book.Title, user.Email
+	name := user.Firstname + " " + user.Lastname
 	subject := "امانت کتاب"
 	body := fmt.Sprintf(`<p>کتاب %s با موفقیت امانت گرفته شد</p>
 	<p>برای دریافت کتاب به کتابخانه مراجعه کنید و با ارائه کارت خود کتاب را تحویل بگیرید.</p>`, title)
-	res, _ = app.DB.Query("SELECT email, (firstname || ' ' || lastname) as name FROM users WHERE user_id=$1", uid)
-	defer res.Close()
-	res.Next()
-	res.Scan(&email, &name)
 	functions.SendEmail(email, subject, body, app.Email)
-	app.DB.Exec("INSERT INTO user_read(book_id, userid) VALUES($1, $2)", bid, uid)
+	if err := app.Users.AddRead(uid, bid); err != nil {
+		log.Println("Couldn't save read record:", err)
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "book borrowed successfully"})
 	app.GetSignal[bid] = make(chan bool)
 	go func() {
@@ -1000,38 +1220,28 @@ func (app *App) BorrowBook(c *gin.Context) {
 
 func (app *App) ReturnBook(c *gin.Context) {
 	bid, _ := strconv.Atoi(c.Param("bookid"))
-	uid := functions.GetUserId(c.GetHeader("Authorization"))
-	res, err := app.DB.Query("SELECT role from users WHERE user_id=$1", uid)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+	err := app.Borrows.Return(bid)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	res.Next()
-	var b bool
-	res.Scan(&b)
-	if !b {
-		c.AbortWithStatus(http.StatusNotAcceptable)
-		return
+	if err == nil {
+		app.audit(c, "borrow.return", "book", bid, gin.H{"returned": false}, gin.H{"returned": true})
 	}
-	res.Close()
-	_, err = app.DB.Exec("UPDATE borrow_book SET returned='yes' WHERE book_id=$1 AND returned = 'no'", bid)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	if signal, ok := app.GetSignal[bid]; ok {
+		close(signal)
+		delete(app.GetSignal, bid)
 	}
-	app.GetSignal[bid] <- true
 	c.JSON(http.StatusOK, gin.H{"message": "book returned successfully"})
 }
 
 func (app *App) BorrowHistory(c *gin.Context) {
 	uid := functions.GetUserId(c.GetHeader("Authorization"))
-	res, err := app.DB.Query("SELECT book_id, title, image_url, avg_rate, rate_count, borrow_time, returned FROM borrow_book INNER JOIN book ON borrow_book.book_id = book.book_id WHERE user_id = $1", uid)
+	books, err := app.Borrows.History(uid)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer res.Close()
-	books := functions.GetBorrowedBooks(res)
 	if len(books) == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"message": "no books found for user"})
 		return
@@ -1040,27 +1250,11 @@ func (app *App) BorrowHistory(c *gin.Context) {
 }
 
 func (app *App) ShowActiveBorrows(c *gin.Context) {
-	uid := functions.GetUserId(c.GetHeader("Authorization"))
-	res, err := app.DB.Query("SELECT role from users WHERE user_id=$1", uid)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
-		return
-	}
-	res.Next()
-	var b bool
-	res.Scan(&b)
-	if !b {
-		c.AbortWithStatus(http.StatusNotAcceptable)
-		return
-	}
-	res.Close()
-	res, err = app.DB.Query("SELECT book.book_id, title, image_url, avg_rate, rate_count FROM borrow_book INNER JOIN book ON borrow_book.book_id = book.book_id WHERE returned='no'")
+	books, err := app.Borrows.Active()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer res.Close()
-	books := functions.GetBorrowedBooks(res)
 	if len(books) == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"message": "no active borrows"})
 		return
@@ -1072,91 +1266,75 @@ func (app *App) ShowActiveBorrows(c *gin.Context) {
 func (app *App) AddToCart(c *gin.Context) {
 	bid, _ := strconv.Atoi(c.Param("bookid"))
 	uid := functions.GetUserId(c.GetHeader("Authorization"))
-	res, err := app.DB.Query("SELECT quantity_sale FROM book WHERE book_id = $1", bid)
-	if err != nil {
+	count, err := app.Books.SaleQuantity(bid)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	res.Next()
-	var count int
-	res.Scan(&count)
 	if count == 0 {
 		c.JSON(http.StatusNotAcceptable, gin.H{"message": "can't add this item to your cart"})
 		return
 	}
-	res.Close()
-	res, err = app.DB.Query("SELECT invoice_id FROM invoice WHERE user_id=$1 AND status='open'", uid)
+	invoice_id, err := app.Invoices.Open(uid)
+	if errors.Is(err, store.ErrNotFound) {
+		invoice_id, err = app.Invoices.CreateOpen(uid)
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	var invoice_id int
-	if !res.Next() {
-		res.Close()
-		res, err = app.DB.Query("SELECT invoice_id FROM invoice ORDER BY invoice_id DESC LIMIT 1")
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		var invoice_id int
-		if !res.Next() {
-			invoice_id = 1
-		} else {
-			res.Scan(&invoice_id)
-			invoice_id++
-		}
-		res.Close()
-		app.DB.Exec("INSERT INTO invoice(invoice_id, user_id, status, purchase_date) VALUES($1, $2, 'open', $3)", invoice_id, uid, time.Now())
-	} else {
-		res.Scan(&invoice_id)
-		res.Close()
-	}
 	count--
-	app.DB.Exec("UPDATE book SET quantity_sale=$1 WHERE book_id=$2", count, bid)
-	app.DB.Exec("INSERT INTO invoice_book(invoice_id, book_id) VALUES($1, $2)", invoice_id, bid)
+	if err := app.Books.SetSaleQuantity(bid, count); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	app.audit(c, "book.stock", "book", bid, gin.H{"qs": count + 1}, gin.H{"qs": count})
+	if err := app.Invoices.AddBook(invoice_id, bid); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "book added to cart"})
 }
 
 func (app *App) DeleteFromCart(c *gin.Context) {
 	bid, _ := strconv.Atoi(c.Param("bookid"))
 	uid := functions.GetUserId(c.GetHeader("Authorization"))
-	res, err := app.DB.Query("SELECT invoice_id FROM invoice WHERE user_id=$1 AND status='open'", uid)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	invoice_id, err := app.Invoices.Open(uid)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "no active invoices"})
 		return
 	}
-	if !res.Next() {
-		c.JSON(http.StatusNotFound, gin.H{"message": "no active invoices"})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	var invoice_id int
-	res.Scan(&invoice_id)
-	res.Close()
-	res, err = app.DB.Query("SELECT quantity_sale FROM book WHERE book_id = $1", bid)
+	count, err := app.Books.SaleQuantity(bid)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer res.Close()
-	res.Next()
-	var count int
-	res.Scan(&count)
 	count++
-	app.DB.Exec("UPDATE book SET quantity_sale=$1 WHERE book_id=$2", count, bid)
-	app.DB.Exec("DELETE FROM invoice_book WHERE invoice_id=$1 AND book_id=$2", invoice_id, bid)
+	if err := app.Books.SetSaleQuantity(bid, count); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	app.audit(c, "book.stock", "book", bid, gin.H{"qs": count - 1}, gin.H{"qs": count})
+	if err := app.Invoices.RemoveBook(invoice_id, bid); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "book removed from cart"})
 }
 
 func (app *App) IsInCart(c *gin.Context) {
 	bid, _ := strconv.Atoi(c.Param("bookid"))
 	uid := functions.GetUserId(c.GetHeader("Authorization"))
-	res, err := app.DB.Query("SELECT * FROM invoice INNER JOIN invoice_book ON invoice.invoice_id=invoice_book.invoice_id WHERE invoice.user_id=$1 AND invoice_book.book_id=$2 AND invoice.status='open'", uid, bid)
+	in, err := app.Invoices.InOpen(uid, bid)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer res.Close()
-	if !res.Next() {
+	if !in {
 		c.JSON(http.StatusNotFound, gin.H{"message": "no such book on active invocie"})
 		return
 	}
@@ -1165,18 +1343,11 @@ func (app *App) IsInCart(c *gin.Context) {
 
 func (app *App) GetActiveInvoice(c *gin.Context) {
 	uid := functions.GetUserId(c.GetHeader("authorization"))
-	res, err := app.DB.Query("SELECT book.book_id, book.price, book.title, book.image_url FROM invoice INNER JOIN invoice_book ON invoice.invoice_id = invoice_book.invoice_id INNER JOIN book ON book.book_id = invoice_book.book_id WHERE invoice.status = 'open' AND invoice.user_id = $1", uid)
+	books, err := app.Invoices.OpenBooks(uid)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer res.Close()
-	books := []models.LowBook{}
-	for res.Next() {
-		var book models.LowBook
-		res.Scan(&book.Id, &book.Price, &book.Title, &book.ImageUrl)
-		books = append(books, book)
-	}
 	if len(books) == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"message": "no active invoices"})
 		return
@@ -1186,76 +1357,50 @@ func (app *App) GetActiveInvoice(c *gin.Context) {
 
 func (app *App) FinalizeInvoice(c *gin.Context) {
 	uid := functions.GetUserId(c.GetHeader("authorization"))
-	res, err := app.DB.Query("SELECT invoice_id FROM invoice WHERE user_id=$1 AND status='open'", uid)
+	iid, err := app.Invoices.Open(uid)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "no active invoices found for user"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	if !res.Next() {
-		c.JSON(http.StatusNotFound, gin.H{"message": "no active invoices found for user"})
+	if err := app.Invoices.Close(iid, time.Now()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	var iid int
-	res.Scan(&iid)
-	app.DB.Exec("UPDATE invoice SET status='close', purchase_date=$2 WHERE invoice_id=$1", iid, time.Now())
-	res.Close()
-	res, _ = app.DB.Query("SELECT book_id FROM invoice_book WHERE invoice_id=$1", iid)
-	defer res.Close()
-	for res.Next() {
-		var bid int
-		res.Scan(&bid)
-		app.DB.Exec("INSERT INTO user_read(userid, book_id) VALUES($1, $2)", uid, bid)
+	books, _ := app.Invoices.Books(iid)
+	for _, book := range books {
+		app.Users.AddRead(uid, book.Id)
 	}
 	link := fmt.Sprintf("https://bikaransystem.work.gd/invoice/%d", iid)
 	subject := "سفارش شما تکمیل شد"
 	body := fmt.Sprintf(`<p>سفارش شما به شماره %d تکمیل شد</p>
 	<p>برای دریافت سفارش کافی است در روز های آتی به کتابخانه مراجعه نمایید.</p>
 	<a href="%s">نمایش سفارش</a>`, iid, link)
-	res, _ = app.DB.Query("SELECT email FROM users WHERE user_id=$1", uid)
-	res.Next()
-	var email string
-	res.Scan(&email)
-	functions.SendEmail(email, subject, body, app.Email)
+	user, _ := app.Users.Get(uid)
+	functions.SendEmail(user.Email, subject, body, app.Email)
 	c.JSON(http.StatusOK, gin.H{"message": "invoice closed"})
 }
 
 func (app *App) ShowInvoice(c *gin.Context) {
 	iid, _ := strconv.Atoi(c.Param("invoice"))
-	res, err := app.DB.Query("SELECT book.book_id, book.price, book.title, book.image_url FROM invoice INNER JOIN invoice_book ON invoice.invoice_id=invoice_book.invoice_id INNER JOIN book ON book.book_id=invoice_book.book_id WHERE invoice.invoice_id=$1", iid)
+	books, err := app.Invoices.Books(iid)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer res.Close()
-	books := []models.LowBook{}
-	for res.Next() {
-		var book models.LowBook
-		res.Scan(&book.Id, &book.Price, &book.Title, &book.ImageUrl)
-		books = append(books, book)
-	}
 	c.JSON(http.StatusOK, books)
 }
 
 func (app *App) InvoiceHistory(c *gin.Context) {
 	uid := functions.GetUserId(c.GetHeader("authorization"))
-	res, err := app.DB.Query("SELECT invoice_id, purchase_date FROM invoice WHERE user_id=$1 AND status='close'", uid)
+	invoices, err := app.Invoices.History(uid)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer res.Close()
-	var invoices []struct {
-		InvoiceID    int       `json:"invoice_id"`
-		PurchaseDate time.Time `json:"purchase_date"`
-	}
-	for res.Next() {
-		var invoice struct {
-			InvoiceID    int       `json:"invoice_id"`
-			PurchaseDate time.Time `json:"purchase_date"`
-		}
-		res.Scan(&invoice.InvoiceID, &invoice.PurchaseDate)
-		invoices = append(invoices, invoice)
-	}
 	if len(invoices) == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"message": "no invoices found for user"})
 		return
@@ -1264,40 +1409,11 @@ func (app *App) InvoiceHistory(c *gin.Context) {
 }
 
 func (app *App) CustomerInvoiceHistory(c *gin.Context) {
-	uid := functions.GetUserId(c.GetHeader("authorization"))
-	res, err := app.DB.Query("SELECT role from users WHERE user_id=$1", uid)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
-		return
-	}
-	res.Next()
-	var b bool
-	res.Scan(&b)
-	if !b {
-		c.AbortWithStatus(http.StatusNotAcceptable)
-		return
-	}
-	res.Close()
-	res, err = app.DB.Query("SELECT invoice_id, purchase_date, (firstname || ' ' || lastname) as name FROM invoice INNER JOIN users on users.user_id=invoice.user_id WHERE status='close'")
+	invoices, err := app.Invoices.AllClosed()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer res.Close()
-	var invoices []struct {
-		InvoiceID    int       `json:"invoice_id"`
-		PurchaseDate time.Time `json:"purchase_date"`
-		Name         string    `json:"customer_name"`
-	}
-	for res.Next() {
-		var invoice struct {
-			InvoiceID    int       `json:"invoice_id"`
-			PurchaseDate time.Time `json:"purchase_date"`
-			Name         string    `json:"customer_name"`
-		}
-		res.Scan(&invoice.InvoiceID, &invoice.PurchaseDate, &invoice.Name)
-		invoices = append(invoices, invoice)
-	}
 	if len(invoices) == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"message": "no invoices found"})
 		return
@@ -1307,15 +1423,12 @@ func (app *App) CustomerInvoiceHistory(c *gin.Context) {
 
 func (app *App) IsAdmin(c *gin.Context) {
 	uid := functions.GetUserId(c.GetHeader("authorization"))
-	res, err := app.DB.Query("SELECT role from users WHERE user_id=$1", uid)
-	if err != nil {
+	user, err := app.Users.Get(uid)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
 		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
 		return
 	}
-	res.Next()
-	var b bool
-	res.Scan(&b)
-	if !b {
+	if user.Role != models.RoleAdmin {
 		c.JSON(http.StatusNotAcceptable, gin.H{"message": "not admin"})
 		return
 	}
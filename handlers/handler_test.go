@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meynay/BookStore/functions"
+	"github.com/meynay/BookStore/images"
+	"github.com/meynay/BookStore/keyring"
+	"github.com/meynay/BookStore/models"
+	"github.com/meynay/BookStore/store"
+)
+
+var errStore = errors.New("store down")
+
+// failingBooks and failingInvoices break a single method of the memory
+// stores, for the error paths.
+type failingBooks struct {
+	store.BookStore
+	failGet, failSaleQuantity, failSetRating bool
+}
+
+func (s failingBooks) Get(id int) (models.Book, error) {
+	if s.failGet {
+		return models.Book{}, errStore
+	}
+	return s.BookStore.Get(id)
+}
+
+func (s failingBooks) SaleQuantity(id int) (int, error) {
+	if s.failSaleQuantity {
+		return 0, errStore
+	}
+	return s.BookStore.SaleQuantity(id)
+}
+
+func (s failingBooks) SetRating(id int, avg float64, count int) error {
+	if s.failSetRating {
+		return errStore
+	}
+	return s.BookStore.SetRating(id, avg, count)
+}
+
+type failingInvoices struct {
+	store.InvoiceStore
+}
+
+func (failingInvoices) CreateOpen(uid int) (int, error) {
+	return 0, errStore
+}
+
+func testApp(t *testing.T) *App {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	if _, err := keyring.Rotate(dir, keyring.EdDSA, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := keyring.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	functions.Keys = keys
+	functions.EmailTokenSecret = []byte("test-email-secret")
+	return &App{Stores: store.NewMemory(), Attempts: store.NewMemoryAttempts()}
+}
+
+func addBook(t *testing.T, app *App, book models.Book) int {
+	t.Helper()
+	if err := app.Books.Create(&book); err != nil {
+		t.Fatal(err)
+	}
+	return book.Id
+}
+
+// serve runs handler for one request signed in as uid.
+func serve(t *testing.T, handler gin.HandlerFunc, route, method, path string, uid int, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	token, _, err := accessToken(uid, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := gin.New()
+	engine.Handle(method, route, handler)
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Authorization", token)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+func TestAddToCart(t *testing.T) {
+	app := testApp(t)
+	bid := addBook(t, app, models.Book{Title: "Shahnameh", QuantityForSale: 1})
+
+	w := serve(t, app.AddToCart, "/addtocart/:bookid", "POST", "/addtocart/1", 7, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("add: got %d %s", w.Code, w.Body)
+	}
+	if count, _ := app.Books.SaleQuantity(bid); count != 0 {
+		t.Errorf("stock after add = %d, want 0", count)
+	}
+	if in, _ := app.Invoices.InOpen(7, bid); !in {
+		t.Error("book not in the open invoice")
+	}
+
+	w = serve(t, app.AddToCart, "/addtocart/:bookid", "POST", "/addtocart/1", 8, "")
+	if w.Code != http.StatusNotAcceptable {
+		t.Errorf("out of stock: got %d, want %d", w.Code, http.StatusNotAcceptable)
+	}
+	if in, _ := app.Invoices.InOpen(8, bid); in {
+		t.Error("out of stock book was added")
+	}
+}
+
+func TestAddToCartErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		path  string
+		setup func(app *App)
+		want  int
+	}{
+		{"unknown book", "/addtocart/99", func(app *App) {}, http.StatusNotAcceptable},
+		{"bad id", "/addtocart/abc", func(app *App) {}, http.StatusNotAcceptable},
+		{"stock lookup fails", "/addtocart/1", func(app *App) {
+			app.Books = failingBooks{BookStore: app.Books, failSaleQuantity: true}
+		}, http.StatusInternalServerError},
+		{"invoice fails", "/addtocart/1", func(app *App) {
+			app.Invoices = failingInvoices{app.Invoices}
+		}, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := testApp(t)
+			bid := addBook(t, app, models.Book{Title: "Masnavi", QuantityForSale: 2})
+			books := app.Books
+			tt.setup(app)
+			w := serve(t, app.AddToCart, "/addtocart/:bookid", "POST", tt.path, 7, "")
+			if w.Code != tt.want {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if count, _ := books.SaleQuantity(bid); count != 2 {
+				t.Errorf("stock changed to %d on a failed add", count)
+			}
+		})
+	}
+}
+
+func TestRateBook(t *testing.T) {
+	app := testApp(t)
+	bid := addBook(t, app, models.Book{Title: "Golestan", AverageRate: 4, RateCount: 1})
+
+	w := serve(t, app.RateBook, "/ratebook", "POST", "/ratebook", 7, `{"book_id": 1, "rating": 2}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("rate: got %d %s", w.Code, w.Body)
+	}
+	book, _ := app.Books.Get(bid)
+	if book.AverageRate != 3 || book.RateCount != 2 {
+		t.Errorf("after first rating: avg %v count %d, want 3 and 2", book.AverageRate, book.RateCount)
+	}
+
+	// rating again replaces the user's rating instead of adding one
+	w = serve(t, app.RateBook, "/ratebook", "POST", "/ratebook", 7, `{"book_id": 1, "rating": 4}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("re-rate: got %d %s", w.Code, w.Body)
+	}
+	book, _ = app.Books.Get(bid)
+	if book.AverageRate != 4 || book.RateCount != 2 {
+		t.Errorf("after re-rating: avg %v count %d, want 4 and 2", book.AverageRate, book.RateCount)
+	}
+	if rating, _ := app.Ratings.Rating(7, bid); rating != 4 {
+		t.Errorf("stored rating = %d, want 4", rating)
+	}
+}
+
+func TestRateBookErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		setup func(app *App)
+		want  int
+	}{
+		{"bad json", `{"book_id": `, func(app *App) {}, http.StatusBadRequest},
+		{"unknown book", `{"book_id": 99, "rating": 3}`, func(app *App) {}, http.StatusNotFound},
+		{"book lookup fails", `{"book_id": 1, "rating": 3}`, func(app *App) {
+			app.Books = failingBooks{BookStore: app.Books, failGet: true}
+		}, http.StatusInternalServerError},
+		{"saving average fails", `{"book_id": 1, "rating": 3}`, func(app *App) {
+			app.Books = failingBooks{BookStore: app.Books, failSetRating: true}
+		}, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := testApp(t)
+			bid := addBook(t, app, models.Book{Title: "Bustan"})
+			books := app.Books
+			tt.setup(app)
+			w := serve(t, app.RateBook, "/ratebook", "POST", "/ratebook", 7, tt.body)
+			if w.Code != tt.want {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			book, _ := books.Get(bid)
+			if book.RateCount != 0 {
+				t.Errorf("rate count changed to %d on a failed rating", book.RateCount)
+			}
+		})
+	}
+}
+
+func TestSearchWithoutTerms(t *testing.T) {
+	app := testApp(t)
+	addBook(t, app, models.Book{Title: "Shahnameh"})
+	for _, q := range []string{"", "   ", "!!!", "«»؟"} {
+		w := serve(t, app.Search, "/search", "GET", "/search?q="+url.QueryEscape(q), 7, "")
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Search(%q) = %d, want %d", q, w.Code, http.StatusBadRequest)
+		}
+		w = serve(t, app.OPDSSearch, "/opds/search", "GET", "/opds/search?q="+url.QueryEscape(q), 7, "")
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("OPDSSearch(%q) = %d, want %d", q, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestMigrateAvatars(t *testing.T) {
+	app := testApp(t)
+	app.Blobs = store.NewLocalBlobs(t.TempDir(), FILESPATH, "secret")
+	old := t.TempDir()
+	var picture bytes.Buffer
+	png.Encode(&picture, image.NewRGBA(image.Rect(0, 0, 300, 200)))
+	os.WriteFile(filepath.Join(old, "1_me.png"), picture.Bytes(), 0600)
+	users := []models.User{
+		{Email: "a@example.com", Image: "1_me.png"},
+		{Email: "b@example.com", Image: "2_gone.png"},
+		{Email: "c@example.com"},
+	}
+	for i := range users {
+		app.Users.Create(&users[i])
+	}
+
+	moved, err := app.MigrateAvatars(old)
+	if err != nil || moved != 1 {
+		t.Fatalf("MigrateAvatars = %d, %v; want 1 moved", moved, err)
+	}
+	user, _ := app.Users.Get(users[0].Id)
+	if images.LegacyAvatar(user.Image) {
+		t.Fatalf("image still %q after migrating", user.Image)
+	}
+	for _, key := range images.AvatarKeys(user.Image) {
+		r, _, err := app.Blobs.Get(key)
+		if err != nil {
+			t.Errorf("blob %s: %v", key, err)
+			continue
+		}
+		r.Close()
+	}
+	if user, _ := app.Users.Get(users[1].Id); user.Image != "2_gone.png" {
+		t.Errorf("missing picture changed to %q", user.Image)
+	}
+	if moved, err := app.MigrateAvatars(old); err != nil || moved != 0 {
+		t.Errorf("second run = %d, %v; want nothing moved", moved, err)
+	}
+}
+
+func TestAvatarVisibility(t *testing.T) {
+	app := testApp(t)
+	app.Blobs = store.NewLocalBlobs(t.TempDir(), FILESPATH, "secret")
+	owner := addUser(t, app, models.User{Email: "owner@example.com", Image: "0123456789abcdef0123456789abcdef"})
+	other := addUser(t, app, models.User{Email: "other@example.com"})
+	avatar := func(uid, caller int) int {
+		t.Helper()
+		return serve(t, app.GetAvatar, "/users/:id/avatar", "GET", "/users/"+strconv.Itoa(uid)+"/avatar", caller, "").Code
+	}
+	setPublic := func(body string) int {
+		t.Helper()
+		return serve(t, app.SetAvatarVisibility, "/userimage/visibility", "PUT", "/userimage/visibility", owner, body).Code
+	}
+
+	if code := avatar(owner, owner); code != http.StatusFound {
+		t.Errorf("own private picture: got %d, want 302", code)
+	}
+	if code := avatar(owner, other); code != http.StatusNotFound {
+		t.Errorf("someone else's private picture: got %d, want 404", code)
+	}
+	if code := setPublic(`{}`); code != http.StatusBadRequest {
+		t.Errorf("no visibility: got %d, want 400", code)
+	}
+	if code := setPublic(`{"public": true}`); code != http.StatusOK {
+		t.Fatalf("make public: got %d", code)
+	}
+	if code := avatar(owner, other); code != http.StatusFound {
+		t.Errorf("public picture: got %d, want 302", code)
+	}
+	if code := setPublic(`{"public": false}`); code != http.StatusOK {
+		t.Fatalf("make private: got %d", code)
+	}
+	if code := avatar(owner, other); code != http.StatusNotFound {
+		t.Errorf("picture made private again: got %d, want 404", code)
+	}
+	if code := avatar(other, other); code != http.StatusNotFound {
+		t.Errorf("no picture: got %d, want 404", code)
+	}
+}
+
+func TestExportAuditEscapesFormulas(t *testing.T) {
+	app := testApp(t)
+	app.Audit.Record(&models.AuditEntry{Action: "user.update", TargetType: "user", TargetId: "7", Ip: "-1"})
+	app.Audit.Record(&models.AuditEntry{Action: "book.update", TargetType: "book", TargetId: "=1+1", Ip: "@SUM(A1)"})
+	w := serve(t, app.ExportAudit, "/admin/audit/export", "GET", "/admin/audit/export", 1, "")
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil || len(rows) != 3 {
+		t.Fatalf("export: %d rows, %v", len(rows), err)
+	}
+	for _, row := range rows[1:] {
+		for _, cell := range row {
+			if cell != "" && strings.ContainsRune("=+-@", rune(cell[0])) {
+				t.Errorf("cell %q would run as a formula", cell)
+			}
+		}
+	}
+	if rows[2][5] != "'=1+1" || rows[2][6] != "'@SUM(A1)" {
+		t.Errorf("escaped cells = %q, %q", rows[2][5], rows[2][6])
+	}
+}
+
+func TestSpreadsheetSafe(t *testing.T) {
+	for in, want := range map[string]string{
+		"":              "",
+		"user.update":   "user.update",
+		"-2+3":          "'-2+3",
+		"+98 912":       "'+98 912",
+		"\tcmd":         "'\tcmd",
+		"{\"a\":\"=\"}": "{\"a\":\"=\"}",
+	} {
+		if got := spreadsheetSafe(in); got != want {
+			t.Errorf("spreadsheetSafe(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meynay/BookStore/oai"
+	"github.com/meynay/BookStore/sru"
+)
+
+// requestBase is the scheme and host the client used to reach us.
+func requestBase(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// harvest section
+func (app *App) OAIPMH(c *gin.Context) {
+	if err := c.Request.ParseForm(); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	provider := oai.Provider{
+		Books:      app.Books,
+		Name:       "BookStore",
+		BaseURL:    requestBase(c) + c.FullPath(),
+		AdminEmail: app.Email.SenderEmail,
+	}
+	response, err := provider.Handle(c.Request.Form)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	writeXML(c, "text/xml; charset=utf-8", response)
+}
+
+func (app *App) SRU(c *gin.Context) {
+	response, err := sru.SearchRetrieve(app.Books, c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	writeXML(c, "text/xml; charset=utf-8", response)
+}
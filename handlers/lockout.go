@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meynay/BookStore/functions"
+	"github.com/meynay/BookStore/models"
+	"github.com/meynay/BookStore/store"
+)
+
+// Failed sign ins are counted per account and per IP for FAILUREWINDOW after
+// the last one. From BACKOFFAFTER failures on each try has to wait twice as
+// long as the one before, up to MAXBACKOFF. ACCOUNTLOCKOUT failures lock the
+// account for LOCKOUTDURATION and mail the owner a link to unlock it early;
+// IPLOCKOUT does the same to an address trying many accounts.
+const FAILUREWINDOW = time.Hour
+const BACKOFFAFTER = 3
+const MAXBACKOFF = 15 * time.Minute
+const ACCOUNTLOCKOUT = 10
+const IPLOCKOUT = 50
+const LOCKOUTDURATION = time.Hour
+
+// RESETMAILLIMIT and RESETIPLIMIT cap reset mails per address and per IP in
+// RESETWINDOW, so the endpoint can't be used to flood someone's inbox.
+const RESETMAILLIMIT = 3
+const RESETIPLIMIT = 20
+const RESETWINDOW = time.Hour
+
+// noAccountHash is compared against when the email is unknown, so the
+// answer takes as long as for a wrong password.
+const noAccountHash = "$2a$10$bpW9J7uo8edOOp7vAo8oQed8PbjSh2zrij1VzWXZfCjLJmYha1F.e"
+
+const wrongCredentials = "invalid email or password"
+
+func backoff(failures int) time.Duration {
+	if failures < BACKOFFAFTER {
+		return 0
+	}
+	delay := time.Duration(math.Pow(2, float64(failures-BACKOFFAFTER))) * time.Second
+	return min(delay, MAXBACKOFF)
+}
+
+// lockedUntil returns the latest lock on any of keys.
+func (app *App) lockedUntil(keys ...string) (time.Time, error) {
+	var latest time.Time
+	for _, key := range keys {
+		until, err := app.Attempts.LockedUntil(key)
+		if err != nil {
+			return latest, err
+		}
+		if until.After(latest) {
+			latest = until
+		}
+	}
+	return latest, nil
+}
+
+func tooManyAttempts(c *gin.Context, until time.Time) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(until).Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{"message": "too many attempts, try again later"})
+}
+
+// loginFailed counts a wrong password for email, which may not belong to
+// anyone; user is nil then.
+func (app *App) loginFailed(c *gin.Context, email string, user *models.User) error {
+	failures, err := app.Attempts.Fail("login:account:"+email, FAILUREWINDOW)
+	if err != nil {
+		return err
+	}
+	lock := backoff(failures)
+	if failures >= ACCOUNTLOCKOUT {
+		lock = LOCKOUTDURATION
+	}
+	if lock > 0 {
+		if err := app.Attempts.Lock("login:account:"+email, lock); err != nil {
+			return err
+		}
+	}
+	// mail only once per lockout, not for every try that follows
+	if failures == ACCOUNTLOCKOUT && user != nil {
+		app.auditAs(c, 0, "account.locked", "user", user.Id, nil, nil)
+		until := time.Now().Add(lock)
+		token := functions.SignEmailToken("unlock-account", user.Id, user.Email, until)
+		if err := functions.SendUnlockEmail(user.Email, token, until, app.Email); err != nil {
+			log.Println("Couldn't send unlock mail:", err)
+		}
+	}
+	failures, err = app.Attempts.Fail("login:ip:"+c.ClientIP(), FAILUREWINDOW)
+	if err != nil {
+		return err
+	}
+	if failures >= IPLOCKOUT {
+		return app.Attempts.Lock("login:ip:"+c.ClientIP(), LOCKOUTDURATION)
+	}
+	return nil
+}
+
+// allowResetMail counts a reset request and tells if it is within limits.
+func (app *App) allowResetMail(c *gin.Context, email string) (bool, error) {
+	perEmail, err := app.Attempts.Fail("reset:account:"+email, RESETWINDOW)
+	if err != nil {
+		return false, err
+	}
+	perIP, err := app.Attempts.Fail("reset:ip:"+c.ClientIP(), RESETWINDOW)
+	if err != nil {
+		return false, err
+	}
+	return perEmail <= RESETMAILLIMIT && perIP <= RESETIPLIMIT, nil
+}
+
+// UnlockAccount lifts a lockout through the link mailed when it started.
+func (app *App) UnlockAccount(c *gin.Context) {
+	uid, email, err := functions.ParseEmailToken("unlock-account", c.Param("token"))
+	if errors.Is(err, functions.ErrExpiredToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"Error": "Token expired, the account is unlocked already"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"Error": "Invalid token"})
+		return
+	}
+	user, err := app.Users.Get(uid)
+	if errors.Is(err, store.ErrNotFound) || err == nil && user.Email != email {
+		c.JSON(http.StatusBadRequest, gin.H{"Error": "Invalid token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	if err := app.Attempts.Reset("login:account:" + email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	app.auditAs(c, uid, "account.unlocked", "user", uid, nil, nil)
+	c.JSON(http.StatusOK, gin.H{"Message": "Account unlocked"})
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meynay/BookStore/functions"
+	"github.com/meynay/BookStore/models"
+	"github.com/meynay/BookStore/opds"
+	"github.com/meynay/BookStore/store"
+)
+
+const OPDSROOT = "/opds"
+
+func writeXML(c *gin.Context, contentType string, v interface{}) {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, contentType, append([]byte(xml.Header), body...))
+}
+
+// opds section
+func (app *App) OPDSRoot(c *gin.Context) {
+	feed := opds.NewFeed(OPDSROOT, "root", "BookStore", OPDSROOT, opds.NavigationType)
+	feed.Navigate("new", "New books", "Books added in the last 30 days", OPDSROOT+"/new", opds.AcquisitionType, 0)
+	feed.Navigate("books", "All books", "The whole catalog by title", OPDSROOT+"/books", opds.AcquisitionType, 0)
+	feed.Navigate("genres", "Genres", "Browse books by genre", OPDSROOT+"/genres", opds.NavigationType, 0)
+	writeXML(c, opds.NavigationType, feed)
+}
+
+func (app *App) OPDSGenres(c *gin.Context) {
+	genres, err := app.Books.Genres()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	feed := opds.NewFeed(OPDSROOT, "genres", "Genres", OPDSROOT+"/genres", opds.NavigationType)
+	for _, genre := range genres {
+		feed.Navigate("genre:"+url.PathEscape(genre.Value), genre.Value, strconv.Itoa(genre.Count)+" books", OPDSROOT+"/genres/"+url.PathEscape(genre.Value), opds.AcquisitionType, genre.Count)
+	}
+	writeXML(c, opds.NavigationType, feed)
+}
+
+// OPDSNew pages through the new books with a page number, since the list is
+// short and has no sort of its own.
+func (app *App) OPDSNew(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	books, err := app.Books.NewBooks(time.Duration(720) * time.Hour)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	sort.Slice(books, func(i, j int) bool { return books[i].Id > books[j].Id })
+	ids := []int{}
+	for i := (page - 1) * store.DefaultLimit; i < len(books) && i < page*store.DefaultLimit; i++ {
+		ids = append(ids, books[i].Id)
+	}
+	self := OPDSROOT + "/new?page=" + strconv.Itoa(page)
+	feed := opds.NewFeed(OPDSROOT, "new", "New books", self, opds.AcquisitionType)
+	feed.Link("first", OPDSROOT+"/new", opds.AcquisitionType)
+	if page > 1 {
+		feed.Link("previous", OPDSROOT+"/new?page="+strconv.Itoa(page-1), opds.AcquisitionType)
+	}
+	if page*store.DefaultLimit < len(books) {
+		feed.Link("next", OPDSROOT+"/new?page="+strconv.Itoa(page+1), opds.AcquisitionType)
+	}
+	details, err := app.Books.Details(ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for _, book := range details {
+		feed.AddBook(book)
+	}
+	writeXML(c, opds.AcquisitionType, feed)
+}
+
+func (app *App) OPDSBooks(c *gin.Context) {
+	app.opdsFilter(c, "books", "All books", OPDSROOT+"/books", models.Filter{Page: models.Page{Sort: "title"}})
+}
+
+func (app *App) OPDSGenre(c *gin.Context) {
+	genre := c.Param("genre")
+	app.opdsFilter(c, "genre:"+url.PathEscape(genre), genre, OPDSROOT+"/genres/"+url.PathEscape(genre), models.Filter{Genres: []string{genre}, Page: models.Page{Sort: "title"}})
+}
+
+func (app *App) OPDSSearch(c *gin.Context) {
+	q := c.Query("q")
+	if len(functions.SearchTerms(q)) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "empty search query"})
+		return
+	}
+	app.opdsFilter(c, "search:"+url.QueryEscape(q), "Search: "+q, OPDSROOT+"/search?q="+url.QueryEscape(q), models.Filter{Search: q})
+}
+
+func (app *App) OPDSOpenSearch(c *gin.Context) {
+	writeXML(c, opds.OpenSearchType, opds.NewOpenSearch(OPDSROOT))
+}
+
+// opdsFilter writes an acquisition feed of the filtered books, following
+// the page cursor the same way the JSON API does.
+func (app *App) opdsFilter(c *gin.Context, id, title, path string, filter models.Filter) {
+	filter.Cursor = c.Query("cursor")
+	result, err := app.Books.Filter(filter)
+	if errors.Is(err, store.ErrInvalidPage) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	withCursor := func(cursor string) string {
+		sep := "?"
+		if strings.Contains(path, "?") {
+			sep = "&"
+		}
+		return path + sep + "cursor=" + url.QueryEscape(cursor)
+	}
+	self := path
+	if filter.Cursor != "" {
+		self = withCursor(filter.Cursor)
+	}
+	feed := opds.NewFeed(OPDSROOT, id, title, self, opds.AcquisitionType)
+	feed.Link("first", path, opds.AcquisitionType)
+	if result.NextCursor != "" {
+		feed.Link("next", withCursor(result.NextCursor), opds.AcquisitionType)
+	}
+	ids := []int{}
+	for _, book := range result.Books {
+		ids = append(ids, book.Id)
+	}
+	books, err := app.Books.Details(ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for _, book := range books {
+		feed.AddBook(book)
+	}
+	writeXML(c, opds.AcquisitionType, feed)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meynay/BookStore/models"
+	"github.com/meynay/BookStore/opds"
+	"github.com/meynay/BookStore/store"
+)
+
+type testLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr"`
+}
+
+// testFeed reads back the parts of a feed e-readers follow.
+type testFeed struct {
+	Links   []testLink `xml:"link"`
+	Entries []struct {
+		Title string     `xml:"title"`
+		Links []testLink `xml:"link"`
+	} `xml:"entry"`
+}
+
+func (f testFeed) link(rel string) (testLink, bool) {
+	for _, link := range f.Links {
+		if link.Rel == rel {
+			return link, true
+		}
+	}
+	return testLink{}, false
+}
+
+func getFeed(t *testing.T, handler gin.HandlerFunc, route, path, kind string) testFeed {
+	t.Helper()
+	w := serve(t, handler, route, "GET", path, 0, "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != kind {
+		t.Fatalf("GET %s: got %d %s, %s", path, w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+	var feed testFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	return feed
+}
+
+func TestOPDSBooksPages(t *testing.T) {
+	app := testApp(t)
+	for i := 0; i <= store.DefaultLimit; i++ {
+		addBook(t, app, models.Book{Title: fmt.Sprintf("book %02d", i), Genres: []string{"رمان"}})
+	}
+
+	feed := getFeed(t, app.OPDSBooks, "/opds/books", "/opds/books", opds.AcquisitionType)
+	if len(feed.Entries) != store.DefaultLimit || feed.Entries[0].Title != "book 00" {
+		t.Fatalf("first page has %d entries", len(feed.Entries))
+	}
+	if start, ok := feed.link("start"); !ok || start.Href != OPDSROOT {
+		t.Errorf("start link = %+v", start)
+	}
+	next, ok := feed.link("next")
+	if !ok {
+		t.Fatal("first page has no next link")
+	}
+	feed = getFeed(t, app.OPDSBooks, "/opds/books", next.Href, opds.AcquisitionType)
+	if len(feed.Entries) != 1 || feed.Entries[0].Title != fmt.Sprintf("book %02d", store.DefaultLimit) {
+		t.Errorf("second page = %+v", feed.Entries)
+	}
+	if _, ok := feed.link("next"); ok {
+		t.Error("last page has a next link")
+	}
+	if self, _ := feed.link("self"); self.Href != next.Href {
+		t.Errorf("self link %q, want %q", self.Href, next.Href)
+	}
+
+	w := serve(t, app.OPDSBooks, "/opds/books", "GET", "/opds/books?cursor=bogus", 0, "")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bad cursor: got %d, want 400", w.Code)
+	}
+}
+
+func TestOPDSAcquisitionLinks(t *testing.T) {
+	app := testApp(t)
+	addBook(t, app, models.Book{Title: "for sale", Genres: []string{"شعر"}, QuantityForSale: 2, Price: 90000})
+	addBook(t, app, models.Book{Title: "in the library", Genres: []string{"شعر"}, QuantityInLib: 1})
+	addBook(t, app, models.Book{Title: "gone", Genres: []string{"رمان"}})
+
+	genres := getFeed(t, app.OPDSGenres, "/opds/genres", "/opds/genres", opds.NavigationType)
+	if len(genres.Entries) != 2 {
+		t.Fatalf("%d genres, want 2", len(genres.Entries))
+	}
+	var poetry string
+	for _, entry := range genres.Entries {
+		if entry.Title == "شعر" {
+			poetry = entry.Links[0].Href
+		}
+	}
+	feed := getFeed(t, app.OPDSGenre, "/opds/genres/:genre", poetry, opds.AcquisitionType)
+	want := map[string]string{
+		"for sale":       "http://opds-spec.org/acquisition/buy /addtocart/1",
+		"in the library": "http://opds-spec.org/acquisition/borrow /borrowbook/2",
+	}
+	if len(feed.Entries) != len(want) {
+		t.Fatalf("genre feed has %d entries, want %d", len(feed.Entries), len(want))
+	}
+	for _, entry := range feed.Entries {
+		var acquisition []string
+		for _, link := range entry.Links {
+			if link.Rel != "alternate" {
+				acquisition = append(acquisition, link.Rel+" "+link.Href)
+			}
+		}
+		if len(acquisition) != 1 || acquisition[0] != want[entry.Title] {
+			t.Errorf("%s: acquisition links %v, want %s", entry.Title, acquisition, want[entry.Title])
+		}
+	}
+}
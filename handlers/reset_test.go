@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/meynay/BookStore/functions"
+)
+
+func TestResetPassword(t *testing.T) {
+	app := testApp(t)
+	session := signIn(t, app, "reader@example.com", "Secret#123")
+	user, _ := app.Users.GetByEmail("reader@example.com")
+	for _, token := range []string{"mailed", "mailed-again"} {
+		if err := app.Resets.Create(user.Id, functions.HashToken(token), time.Now().Add(RESETTOKENTTL)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reset := func(token, password string) int {
+		t.Helper()
+		return serve(t, app.ResetPassword, "/resetpassword/:token", "POST", "/resetpassword/"+token, 0, `{"password": "`+password+`"}`).Code
+	}
+	login := func(password string) int {
+		t.Helper()
+		return serve(t, app.Login, "/login", "POST", "/login", 0, `{"email": "reader@example.com", "password": "`+password+`"}`).Code
+	}
+
+	// a rejected password leaves the token for another try
+	if code := reset("mailed", "short1"); code != http.StatusBadRequest {
+		t.Fatalf("weak password: got %d, want 400", code)
+	}
+	if code := reset("mailed", "Kelidar1989"); code != http.StatusOK {
+		t.Fatalf("reset: got %d", code)
+	}
+	if w := serveToken(authenticated(app), "/userinfo", "GET", "/userinfo", session.Token, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("session from before the reset: got %d, want 401", w.Code)
+	}
+	if code, _ := refresh(t, app, session.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("refresh token from before the reset: got %d, want 401", code)
+	}
+
+	// the token, and every other one mailed to the user, is used up
+	for _, token := range []string{"mailed", "mailed-again"} {
+		if code := reset(token, "Jaleh#2024x"); code != http.StatusBadRequest {
+			t.Errorf("%q after the reset: got %d, want 400", token, code)
+		}
+	}
+	if code := login("Secret#123"); code != http.StatusUnauthorized {
+		t.Errorf("old password: got %d, want 401", code)
+	}
+	if code := login("Kelidar1989"); code != http.StatusOK {
+		t.Errorf("new password: got %d, want 200", code)
+	}
+}
+
+func TestResetPasswordExpired(t *testing.T) {
+	app := testApp(t)
+	signIn(t, app, "reader@example.com", "Secret#123")
+	if err := app.Resets.Create(1, functions.HashToken("old"), time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	w := serve(t, app.ResetPassword, "/resetpassword/:token", "POST", "/resetpassword/old", 0, `{"password": "Kelidar1989"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expired token: got %d, want 400", w.Code)
+	}
+	if sessions, _ := app.Sessions.List(1); len(sessions) != 1 {
+		t.Errorf("%d sessions left, want the failed reset to keep them", len(sessions))
+	}
+}
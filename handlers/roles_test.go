@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meynay/BookStore/models"
+)
+
+func addUser(t *testing.T, app *App, user models.User) int {
+	t.Helper()
+	if err := app.Users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	return user.Id
+}
+
+// guarded runs handler behind RequirePermission the way the route groups in
+// main do.
+func guarded(app *App, permission string, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		app.RequirePermission(permission)(c)
+		if !c.IsAborted() {
+			handler(c)
+		}
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	routes := []struct {
+		name, permission, route, method, path string
+		handler                               func(app *App) gin.HandlerFunc
+	}{
+		{"return book", models.PermManageBorrows, "/returnbook/:bookid", "POST", "/returnbook/1", func(app *App) gin.HandlerFunc { return app.ReturnBook }},
+		{"customer invoices", models.PermViewInvoices, "/customerinvoices", "GET", "/customerinvoices", func(app *App) gin.HandlerFunc { return app.CustomerInvoiceHistory }},
+		{"edit author", models.PermManageCatalog, "/authors/:id", "PUT", "/authors/1", func(app *App) gin.HandlerFunc { return app.EditAuthor }},
+		{"manage users", models.PermManageUsers, "/roles", "GET", "/roles", func(app *App) gin.HandlerFunc { return app.GetRoles }},
+	}
+	tests := []struct {
+		role    string
+		allowed map[string]bool
+	}{
+		{models.RoleCustomer, map[string]bool{}},
+		{models.RoleLibrarian, map[string]bool{"return book": true, "edit author": true}},
+		{models.RoleStoreManager, map[string]bool{"customer invoices": true, "edit author": true}},
+		{models.RoleAdmin, map[string]bool{"return book": true, "customer invoices": true, "edit author": true, "manage users": true}},
+	}
+	for _, tt := range tests {
+		for _, route := range routes {
+			t.Run(tt.role+"/"+route.name, func(t *testing.T) {
+				app := testApp(t)
+				uid := addUser(t, app, models.User{Email: "staff@example.com", Role: tt.role})
+				bid := addBook(t, app, models.Book{Title: "Kelidar", QuantityInLib: 1})
+				if err := app.Borrows.Borrow(uid, bid, time.Now()); err != nil {
+					t.Fatal(err)
+				}
+				w := serve(t, guarded(app, route.permission, route.handler(app)), route.route, route.method, route.path, uid, `{"name": "Dowlatabadi"}`)
+				if allowed := w.Code != http.StatusForbidden; allowed != tt.allowed[route.name] {
+					t.Fatalf("got %d %s, allowed %v", w.Code, w.Body, tt.allowed[route.name])
+				}
+				borrowed, _ := app.Borrows.IsBorrowed(bid)
+				if route.name == "return book" && borrowed == tt.allowed[route.name] {
+					t.Errorf("book still borrowed %v after a %d", borrowed, w.Code)
+				}
+			})
+		}
+	}
+
+	// a user missing from the store has no role and no permissions
+	app := testApp(t)
+	if w := serve(t, guarded(app, models.PermManageCatalog, app.GetRoles), "/roles", "GET", "/roles", 42, ""); w.Code != http.StatusForbidden {
+		t.Errorf("unknown user: got %d, want 403", w.Code)
+	}
+}
+
+func TestSetUserRole(t *testing.T) {
+	app := testApp(t)
+	admin := addUser(t, app, models.User{Email: "admin@example.com", Role: models.RoleAdmin})
+	uid := addUser(t, app, models.User{Email: "reader@example.com", Role: models.RoleCustomer})
+	setRole := func(id string, body string) int {
+		t.Helper()
+		return serve(t, app.SetUserRole, "/users/:id/role", "PUT", "/users/"+id+"/role", admin, body).Code
+	}
+
+	if code := setRole("2", `{"role": "librarian"}`); code != http.StatusOK {
+		t.Fatalf("promote: got %d", code)
+	}
+	if user, _ := app.Users.Get(uid); user.Role != models.RoleLibrarian {
+		t.Errorf("role = %q, want librarian", user.Role)
+	}
+	if code := setRole("2", `{"role": "owner"}`); code != http.StatusBadRequest {
+		t.Errorf("unknown role: got %d, want 400", code)
+	}
+	if code := setRole("99", `{"role": "admin"}`); code != http.StatusNotFound {
+		t.Errorf("unknown user: got %d, want 404", code)
+	}
+	if code := setRole("1", `{"role": "customer"}`); code != http.StatusConflict {
+		t.Errorf("self demotion: got %d, want 409", code)
+	}
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meynay/BookStore/models"
+	"github.com/meynay/BookStore/store"
+)
+
+// series section
+func (app *App) GetSeries(c *gin.Context) {
+	sid, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	series, err := app.Series.Get(sid)
+	if errors.Is(err, store.ErrNotFound) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, series)
+}
+
+func (app *App) AddSeries(c *gin.Context) {
+	var series models.Series
+	if err := c.BindJSON(&series); err != nil {
+		return
+	}
+	if series.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "series name is required"})
+		return
+	}
+	if err := app.Series.Create(&series); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	app.audit(c, "series.create", "series", series.Id, nil, series)
+	c.JSON(http.StatusCreated, gin.H{"series_id": series.Id})
+}
+
+func (app *App) SetSeriesBooks(c *gin.Context) {
+	sid, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	var request struct {
+		Books []models.SeriesVolume `json:"books" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err = app.Series.SetVolumes(sid, request.Books)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "series or book not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	app.audit(c, "series.books", "series", sid, nil, gin.H{"books": request.Books})
+	c.JSON(http.StatusOK, gin.H{"message": "series updated"})
+}
+
+func (app *App) RemoveFromSeries(c *gin.Context) {
+	sid, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	bid, err := strconv.Atoi(c.Param("bookid"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = app.Series.Remove(sid, bid)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "book is not in this series"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	app.audit(c, "series.remove_book", "series", sid, gin.H{"book_id": bid}, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "book removed from series"})
+}
+
+// editions section
+func (app *App) GroupEditions(c *gin.Context) {
+	var request struct {
+		Books []int `json:"books" binding:"required,min=2"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	wid, err := app.Books.GroupEditions(request.Books)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "book not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	app.audit(c, "editions.group", "work", wid, nil, gin.H{"books": request.Books})
+	c.JSON(http.StatusOK, gin.H{"work_id": wid})
+}
+
+func (app *App) UngroupEdition(c *gin.Context) {
+	bid, err := strconv.Atoi(c.Param("bookid"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = app.Books.Ungroup(bid)
+	if errors.Is(err, store.ErrNotFound) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	app.audit(c, "editions.ungroup", "book", bid, nil, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "edition ungrouped"})
+}
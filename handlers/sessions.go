@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/meynay/BookStore/functions"
+	"github.com/meynay/BookStore/models"
+	"github.com/meynay/BookStore/store"
+)
+
+const ACCESSTOKENTTL = 15 * time.Minute
+const REFRESHTOKENTTL = 30 * 24 * time.Hour
+const MAXUSERAGENT = 512
+
+func userAgent(c *gin.Context) string {
+	agent := c.Request.UserAgent()
+	if len(agent) > MAXUSERAGENT {
+		agent = agent[:MAXUSERAGENT]
+	}
+	return agent
+}
+
+func accessToken(uid, sid int) (string, time.Time, error) {
+	expirationTime := time.Now().Add(ACCESSTOKENTTL)
+	claims := &models.Claims{
+		Uid:  uid,
+		Sid:  sid,
+		Type: models.TokenAccess,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: expirationTime.Unix(),
+		},
+	}
+	tokenString, err := functions.SignToken(claims)
+	return tokenString, expirationTime, err
+}
+
+// startSession signs the user in on the requesting device.
+func (app *App) startSession(c *gin.Context, uid int) (models.JWTOutput, error) {
+	refresh, err := functions.GenerateToken()
+	if err != nil {
+		return models.JWTOutput{}, err
+	}
+	session := models.Session{
+		Uid:       uid,
+		UserAgent: userAgent(c),
+		Ip:        c.ClientIP(),
+		ExpiresAt: time.Now().Add(REFRESHTOKENTTL),
+	}
+	if err := app.Sessions.Create(&session, functions.HashToken(refresh)); err != nil {
+		return models.JWTOutput{}, err
+	}
+	token, expires, err := accessToken(uid, session.Id)
+	if err != nil {
+		return models.JWTOutput{}, err
+	}
+	return models.JWTOutput{
+		Token:          token,
+		Expires:        expires,
+		RefreshToken:   refresh,
+		RefreshExpires: session.ExpiresAt,
+	}, nil
+}
+
+// sessions section
+
+// Refresh swaps a refresh token for a new access token and refresh token.
+// Each refresh token works once; if an old one shows up again it was
+// copied, and the session is ended for whoever holds it.
+func (app *App) Refresh(c *gin.Context) {
+	var request struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hash := functions.HashToken(request.RefreshToken)
+	// blocked users keep their refresh token unused, it works again once
+	// they're let back in
+	owner, err := app.Sessions.ByRefresh(hash)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired refresh token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := app.Users.Get(owner.Uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if user.Blocked(time.Now()) {
+		c.JSON(http.StatusForbidden, gin.H{"message": blockedMessage(user)})
+		return
+	}
+	if user.MustResetPassword {
+		c.JSON(http.StatusForbidden, gin.H{"message": "password reset required, check your email"})
+		return
+	}
+	next, err := functions.GenerateToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	expires := time.Now().Add(REFRESHTOKENTTL)
+	session, err := app.Sessions.Rotate(hash, functions.HashToken(next), c.ClientIP(), userAgent(c), expires)
+	if errors.Is(err, store.ErrTokenReused) {
+		app.auditAs(c, session.Uid, "session.reuse_detected", "session", session.Id, nil, nil)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token already used, session revoked"})
+		return
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired refresh token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	token, tokenExpires, err := accessToken(user.Id, session.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, models.JWTOutput{
+		Token:          token,
+		Expires:        tokenExpires,
+		RefreshToken:   next,
+		RefreshExpires: expires,
+	})
+}
+
+func (app *App) ListSessions(c *gin.Context) {
+	token := c.GetHeader("Authorization")
+	sessions, err := app.Sessions.List(functions.GetUserId(token))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	current := functions.GetSessionId(token)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].Id == current
+	}
+	c.JSON(http.StatusOK, sessions)
+}
+
+func (app *App) RevokeSession(c *gin.Context) {
+	sid, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = app.Sessions.Revoke(functions.GetUserId(c.GetHeader("Authorization")), sid)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "session not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	app.audit(c, "session.revoke", "session", sid, nil, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+}
+
+// RevokeSessions signs the user out everywhere, this device included.
+func (app *App) RevokeSessions(c *gin.Context) {
+	uid := functions.GetUserId(c.GetHeader("Authorization"))
+	if err := app.Sessions.RevokeAll(uid); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	app.audit(c, "session.revoke_all", "user", uid, nil, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "all sessions revoked"})
+}
+
+// JWKS publishes the public keys of access tokens, so other services can
+// verify them. Clients should fetch it again when they see an unknown kid.
+func (app *App) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, functions.Keys.JWKS())
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meynay/BookStore/functions"
+	"github.com/meynay/BookStore/models"
+	"github.com/meynay/BookStore/store"
+)
+
+// serveToken is serve for a request carrying the given access token.
+func serveToken(handler gin.HandlerFunc, route, method, path, token, body string) *httptest.ResponseRecorder {
+	engine := gin.New()
+	engine.Handle(method, route, handler)
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Authorization", token)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+// signIn adds a user with the password and signs them in through Login.
+func signIn(t *testing.T, app *App, email, password string) models.JWTOutput {
+	t.Helper()
+	hash, err := functions.HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addUser(t, app, models.User{Email: email, Password: hash, Role: models.RoleCustomer, Status: models.UserActive})
+	w := serve(t, app.Login, "/login", "POST", "/login", 0, `{"email": "`+email+`", "password": "`+password+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: got %d %s", w.Code, w.Body)
+	}
+	var out models.JWTOutput
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func refresh(t *testing.T, app *App, token string) (int, models.JWTOutput) {
+	t.Helper()
+	w := serve(t, app.Refresh, "/refresh", "POST", "/refresh", 0, `{"refresh_token": "`+token+`"}`)
+	var out models.JWTOutput
+	json.Unmarshal(w.Body.Bytes(), &out)
+	return w.Code, out
+}
+
+func TestRefreshReuse(t *testing.T) {
+	app := testApp(t)
+	first := signIn(t, app, "reader@example.com", "Secret#123")
+
+	code, second := refresh(t, app, first.RefreshToken)
+	if code != http.StatusOK || second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("rotate: got %d, refresh token %q", code, second.RefreshToken)
+	}
+	if w := serveToken(authenticated(app), "/userinfo", "GET", "/userinfo", second.Token, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("new access token: got %d", w.Code)
+	}
+
+	// the first token showing up again means it was copied
+	if code, _ := refresh(t, app, first.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("reused token: got %d, want 401", code)
+	}
+	if _, err := app.Sessions.Active(1); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("session after reuse: %v, want it revoked", err)
+	}
+	if code, _ := refresh(t, app, second.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("token rotated before the reuse: got %d, want 401", code)
+	}
+	if w := serveToken(authenticated(app), "/userinfo", "GET", "/userinfo", second.Token, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("access token of the revoked session: got %d, want 401", w.Code)
+	}
+	if activity, _ := app.Audit.List(models.AuditFilter{Action: "session.reuse_detected"}); len(activity.Entries) != 1 {
+		t.Errorf("reuse audited %d times, want once", len(activity.Entries))
+	}
+}
+
+func TestRefreshExpired(t *testing.T) {
+	app := testApp(t)
+	uid := addUser(t, app, models.User{Email: "reader@example.com", Role: models.RoleCustomer})
+	session := models.Session{Uid: uid, ExpiresAt: time.Now().Add(-time.Minute)}
+	if err := app.Sessions.Create(&session, functions.HashToken("expired")); err != nil {
+		t.Fatal(err)
+	}
+	_, err := app.Sessions.Rotate(functions.HashToken("expired"), functions.HashToken("next"), "", "", time.Now().Add(time.Hour))
+	if !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Rotate of an expired session: %v, want ErrNotFound", err)
+	}
+	if code, _ := refresh(t, app, "expired"); code != http.StatusUnauthorized {
+		t.Errorf("expired token: got %d, want 401", code)
+	}
+	if code, _ := refresh(t, app, "unknown"); code != http.StatusUnauthorized {
+		t.Errorf("unknown token: got %d, want 401", code)
+	}
+}
+
+func TestRefreshBlocked(t *testing.T) {
+	app := testApp(t)
+	first := signIn(t, app, "reader@example.com", "Secret#123")
+	app.Users.SetStatus(1, models.UserSuspended, "spam", nil)
+	if code, _ := refresh(t, app, first.RefreshToken); code != http.StatusForbidden {
+		t.Fatalf("suspended: got %d, want 403", code)
+	}
+	if err := app.Users.ForcePasswordReset(1); err != nil {
+		t.Fatal(err)
+	}
+	app.Users.SetStatus(1, models.UserActive, "", nil)
+	if code, _ := refresh(t, app, first.RefreshToken); code != http.StatusForbidden {
+		t.Fatalf("password reset required: got %d, want 403", code)
+	}
+
+	// the refused refreshes didn't use the token up, so it isn't taken
+	// for a copy once the user may sign in again
+	app.Users.SetPassword("reader@example.com", "new hash")
+	if code, _ := refresh(t, app, first.RefreshToken); code != http.StatusOK {
+		t.Errorf("after the block: got %d, want 200", code)
+	}
+}
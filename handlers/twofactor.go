@@ -0,0 +1,401 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/meynay/BookStore/functions"
+	"github.com/meynay/BookStore/models"
+	"github.com/meynay/BookStore/store"
+	"github.com/meynay/BookStore/totp"
+)
+
+const TWOFACTORISSUER = "BookStore"
+const CHALLENGETTL = 5 * time.Minute
+const RECOVERYCODES = 10
+
+// MAXTWOFACTORFAILURES wrong codes within TWOFACTORWINDOW, on the account or
+// on one sign in challenge, stop further tries for TWOFACTORWINDOW, so six
+// digits can't be guessed.
+const MAXTWOFACTORFAILURES = 5
+const TWOFACTORWINDOW = 15 * time.Minute
+
+// twoFactorRequired tells if the policy makes users with role enroll before
+// they can use their permissions.
+func (app *App) twoFactorRequired(role string) bool {
+	return slices.Contains(app.TwoFactorRoles, role)
+}
+
+// recoveryCodes makes a new set of codes to show the user once, along with
+// the hashes to store.
+func recoveryCodes() ([]string, []string, error) {
+	codes := make([]string, RECOVERYCODES)
+	hashes := make([]string, RECOVERYCODES)
+	for i := range codes {
+		raw := make([]byte, 10)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(raw))
+		codes[i] = code[:5] + "-" + code[5:10]
+		hashes[i] = recoveryCodeHash(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+func recoveryCodeHash(code string) string {
+	code = strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+	return functions.HashToken(code)
+}
+
+func challengeToken(uid int) (string, time.Time, error) {
+	expirationTime := time.Now().Add(CHALLENGETTL)
+	claims := &models.ChallengeClaims{
+		Uid:  uid,
+		Type: models.TokenChallenge,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: expirationTime.Unix(),
+		},
+	}
+	tokenString, err := functions.SignToken(claims)
+	return tokenString, expirationTime, err
+}
+
+func parseChallenge(token string) (int, bool) {
+	claims := &models.ChallengeClaims{}
+	tkn, err := functions.ParseToken(token, claims)
+	if err != nil || !tkn.Valid || claims.Type != models.TokenChallenge || claims.Uid == 0 {
+		return 0, false
+	}
+	return claims.Uid, true
+}
+
+func challengeUsedKey(challenge string) string {
+	return "2fa:used:" + functions.HashToken(challenge)
+}
+
+// useChallenge uses up a sign in challenge, telling if this was its first
+// use. The attempt counter is atomic in Redis as well, so of two requests
+// racing with one challenge only one gets through.
+func (app *App) useChallenge(challenge string) (bool, error) {
+	uses, err := app.Attempts.Fail(challengeUsedKey(challenge), CHALLENGETTL)
+	return uses == 1, err
+}
+
+// checkCode accepts a code from the authenticator app, each at most once,
+// or an unused recovery code.
+func (app *App) checkCode(tf models.TwoFactor, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		return app.TwoFactor.UseRecoveryCode(tf.Uid, recoveryCodeHash(recoveryCode))
+	}
+	step, ok := totp.Validate(tf.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return app.TwoFactor.UseStep(tf.Uid, step)
+}
+
+// twoFactorKeys are the attempt counters a code counts against: the
+// account's, and the challenge's when signing in.
+func twoFactorKeys(uid int, challenge string) []string {
+	keys := []string{"2fa:account:" + strconv.Itoa(uid)}
+	if challenge != "" {
+		keys = append(keys, "2fa:challenge:"+functions.HashToken(challenge))
+	}
+	return keys
+}
+
+// codesLocked answers for the caller when too many wrong codes were tried.
+func (app *App) codesLocked(c *gin.Context, keys []string) bool {
+	until, err := app.lockedUntil(keys...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return true
+	}
+	if until.After(time.Now()) {
+		tooManyAttempts(c, until)
+		return true
+	}
+	return false
+}
+
+// codeFailed counts a wrong code and answers for the caller.
+func (app *App) codeFailed(c *gin.Context, keys []string) {
+	for _, key := range keys {
+		failures, err := app.Attempts.Fail(key, TWOFACTORWINDOW)
+		if err == nil && failures >= MAXTWOFACTORFAILURES {
+			err = app.Attempts.Lock(key, TWOFACTORWINDOW)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "wrong code"})
+}
+
+// enabledTwoFactor loads the signed in user's second factor, answering for
+// the caller when there is none.
+func (app *App) enabledTwoFactor(c *gin.Context, uid int) (models.TwoFactor, bool) {
+	tf, err := app.TwoFactor.Get(uid)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return tf, false
+	}
+	if !tf.Enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is not enabled"})
+		return tf, false
+	}
+	return tf, true
+}
+
+// verifyCode checks the code of a sign in challenge, or of a request that
+// changes the second factor, where challenge is empty.
+func (app *App) verifyCode(c *gin.Context, tf models.TwoFactor, challenge, code, recoveryCode string) bool {
+	keys := twoFactorKeys(tf.Uid, challenge)
+	if app.codesLocked(c, keys) {
+		return false
+	}
+	ok, err := app.checkCode(tf, code, recoveryCode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if !ok {
+		app.codeFailed(c, keys)
+		return false
+	}
+	if err := app.Attempts.Reset(keys[0]); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// two factor section
+func (app *App) GetTwoFactor(c *gin.Context) {
+	user, err := app.Users.Get(functions.GetUserId(c.GetHeader("Authorization")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	tf, err := app.TwoFactor.Get(user.Id)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":        tf.Enabled,
+		"recovery_codes": tf.RecoveryCodes,
+		"required":       app.twoFactorRequired(user.Role),
+	})
+}
+
+// EnrollTwoFactor starts setting up an authenticator app. Nothing changes
+// for signing in until the user confirms with a code from the app.
+func (app *App) EnrollTwoFactor(c *gin.Context) {
+	user, err := app.Users.Get(functions.GetUserId(c.GetHeader("Authorization")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	tf, err := app.TwoFactor.Get(user.Id)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if tf.Enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := app.TwoFactor.Begin(user.Id, secret); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"secret": secret,
+		"uri":    totp.URI(TWOFACTORISSUER, user.Email, secret),
+	})
+}
+
+// ConfirmTwoFactor turns the enrolled app on and hands out recovery codes,
+// which are never shown again.
+func (app *App) ConfirmTwoFactor(c *gin.Context) {
+	var request struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	uid := functions.GetUserId(c.GetHeader("Authorization"))
+	tf, err := app.TwoFactor.Get(uid)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusConflict, gin.H{"error": "start enrollment first"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if tf.Enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+		return
+	}
+	keys := twoFactorKeys(uid, "")
+	if app.codesLocked(c, keys) {
+		return
+	}
+	step, ok := totp.Validate(tf.Secret, request.Code, time.Now())
+	if !ok {
+		app.codeFailed(c, keys)
+		return
+	}
+	if err := app.Attempts.Reset(keys[0]); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	codes, hashes, err := recoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := app.TwoFactor.Enable(uid, step, hashes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	app.audit(c, "account.2fa_enabled", "user", uid, nil, nil)
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (app *App) RegenerateRecoveryCodes(c *gin.Context) {
+	var request struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	uid := functions.GetUserId(c.GetHeader("Authorization"))
+	tf, ok := app.enabledTwoFactor(c, uid)
+	if !ok || !app.verifyCode(c, tf, "", request.Code, "") {
+		return
+	}
+	codes, hashes, err := recoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := app.TwoFactor.SetRecoveryCodes(uid, hashes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	app.audit(c, "account.2fa_recovery_codes", "user", uid, nil, nil)
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (app *App) DisableTwoFactor(c *gin.Context) {
+	var request struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := app.Users.Get(functions.GetUserId(c.GetHeader("Authorization")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if app.twoFactorRequired(user.Role) {
+		c.JSON(http.StatusConflict, gin.H{"error": "your role requires two-factor authentication"})
+		return
+	}
+	tf, ok := app.enabledTwoFactor(c, user.Id)
+	if !ok || !app.verifyCode(c, tf, "", request.Code, request.RecoveryCode) {
+		return
+	}
+	if err := app.TwoFactor.Disable(user.Id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	app.audit(c, "account.2fa_disabled", "user", user.Id, nil, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
+
+// LoginTwoFactor finishes a sign in that Login answered with a challenge.
+func (app *App) LoginTwoFactor(c *gin.Context) {
+	var request struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	uid, ok := parseChallenge(request.ChallengeToken)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired challenge, sign in again"})
+		return
+	}
+	user, err := app.Users.Get(uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if user.Blocked(time.Now()) {
+		c.JSON(http.StatusForbidden, gin.H{"message": blockedMessage(user)})
+		return
+	}
+	tf, ok := app.enabledTwoFactor(c, uid)
+	if !ok {
+		return
+	}
+	// used up before the code is checked, so a replayed challenge can't
+	// burn a recovery code or an authenticator step
+	first, err := app.useChallenge(request.ChallengeToken)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !first {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired challenge, sign in again"})
+		return
+	}
+	if !app.verifyCode(c, tf, request.ChallengeToken, request.Code, request.RecoveryCode) {
+		// the challenge stays good for another try at the code
+		if err := app.Attempts.Reset(challengeUsedKey(request.ChallengeToken)); err != nil {
+			log.Println("Couldn't release two-factor challenge:", err)
+		}
+		if c.Writer.Status() == http.StatusUnauthorized {
+			app.auditAs(c, 0, "account.login_2fa_failed", "user", uid, nil, nil)
+		}
+		return
+	}
+	if request.RecoveryCode != "" {
+		app.auditAs(c, uid, "account.recovery_code_used", "user", uid, nil, nil)
+	}
+	jwtOutput, err := app.startSession(c, uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	app.auditAs(c, uid, "account.login", "user", uid, nil, nil)
+	c.JSON(http.StatusOK, jwtOutput)
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meynay/BookStore/models"
+	"github.com/meynay/BookStore/totp"
+)
+
+func enrolledUser(t *testing.T, app *App, enable bool) (int, string) {
+	t.Helper()
+	user := models.User{Email: "staff@example.com", Role: models.RoleCustomer}
+	if err := app.Users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := totp.GenerateSecret()
+	app.TwoFactor.Begin(user.Id, secret)
+	if enable {
+		app.TwoFactor.Enable(user.Id, 0, nil)
+	}
+	return user.Id, secret
+}
+
+func currentCode(t *testing.T, secret string) string {
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// wrongCode is never the right one, since codes have six digits.
+const wrongCode = "0000000"
+
+func TestConfirmTwoFactorLocksAfterWrongCodes(t *testing.T) {
+	app := testApp(t)
+	uid, secret := enrolledUser(t, app, false)
+	confirm := func(code string) int {
+		body := fmt.Sprintf(`{"code": %q}`, code)
+		return serve(t, app.ConfirmTwoFactor, "/2fa/confirm", "POST", "/2fa/confirm", uid, body).Code
+	}
+	for i := 0; i < MAXTWOFACTORFAILURES; i++ {
+		if got := confirm(wrongCode); got != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d: got %d, want 401", i+1, got)
+		}
+	}
+	if got := confirm(currentCode(t, secret)); got != http.StatusTooManyRequests {
+		t.Fatalf("right code while locked: got %d, want 429", got)
+	}
+	if tf, _ := app.TwoFactor.Get(uid); tf.Enabled {
+		t.Error("enabled while locked")
+	}
+}
+
+func TestLoginTwoFactorLocksChallenge(t *testing.T) {
+	app := testApp(t)
+	uid, secret := enrolledUser(t, app, true)
+	challenge, _, err := challengeToken(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := func(code string) int {
+		body := fmt.Sprintf(`{"challenge_token": %q, "code": %q}`, challenge, code)
+		return serve(t, app.LoginTwoFactor, "/login/2fa", "POST", "/login/2fa", 0, body).Code
+	}
+	for i := 0; i < MAXTWOFACTORFAILURES; i++ {
+		if got := login(wrongCode); got != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d: got %d, want 401", i+1, got)
+		}
+	}
+	if got := login(currentCode(t, secret)); got != http.StatusTooManyRequests {
+		t.Fatalf("right code on a locked challenge: got %d, want 429", got)
+	}
+
+	// a new challenge doesn't help, the account is locked too
+	app.Attempts.Reset(twoFactorKeys(uid, challenge)[1])
+	if got := login(currentCode(t, secret)); got != http.StatusTooManyRequests {
+		t.Fatalf("right code on a locked account: got %d, want 429", got)
+	}
+
+	app.Attempts.Reset(twoFactorKeys(uid, "")[0])
+	if got := login(currentCode(t, secret)); got != http.StatusOK {
+		t.Fatalf("right code after the lock: got %d, want 200", got)
+	}
+}
+
+func TestChallengeIsNotAnAccessToken(t *testing.T) {
+	app := testApp(t)
+	uid, _ := enrolledUser(t, app, true)
+	challenge, _, _ := challengeToken(uid)
+	access, _, _ := accessToken(uid, 1)
+	if _, ok := parseChallenge(access); ok {
+		t.Error("an access token passed as a challenge")
+	}
+	if got, ok := parseChallenge(challenge); !ok || got != uid {
+		t.Errorf("parseChallenge = %d, %v; want %d", got, ok, uid)
+	}
+}
+
+func TestAuthMiddlewareRejectsChallenge(t *testing.T) {
+	app := testApp(t)
+	uid, _ := enrolledUser(t, app, true)
+	challenge, _, _ := challengeToken(uid)
+	engine := gin.New()
+	engine.Use(app.AuthMiddleware())
+	engine.GET("/userinfo", app.GetUserInfo)
+	req := httptest.NewRequest("GET", "/userinfo", nil)
+	req.Header.Set("Authorization", challenge)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("challenge as access token: got %d, want 401", w.Code)
+	}
+}
+
+func TestLoginTwoFactorChallengeSingleUse(t *testing.T) {
+	app := testApp(t)
+	uid, secret := enrolledUser(t, app, true)
+	app.TwoFactor.SetRecoveryCodes(uid, []string{recoveryCodeHash("abcde-fghij")})
+	challenge, _, err := challengeToken(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := func(field, code string) int {
+		body := fmt.Sprintf(`{"challenge_token": %q, %q: %q}`, challenge, field, code)
+		return serve(t, app.LoginTwoFactor, "/login/2fa", "POST", "/login/2fa", 0, body).Code
+	}
+
+	// a wrong code doesn't use up the challenge
+	if got := login("code", wrongCode); got != http.StatusUnauthorized {
+		t.Fatalf("wrong code: got %d, want 401", got)
+	}
+	if got := login("code", currentCode(t, secret)); got != http.StatusOK {
+		t.Fatalf("right code: got %d, want 200", got)
+	}
+	if got := login("recovery_code", "abcde-fghij"); got != http.StatusUnauthorized {
+		t.Errorf("challenge used a second time: got %d, want 401", got)
+	}
+	if ok, _ := app.TwoFactor.UseRecoveryCode(uid, recoveryCodeHash("abcde-fghij")); !ok {
+		t.Error("the replayed challenge used up the recovery code")
+	}
+}
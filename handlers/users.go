@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meynay/BookStore/functions"
+	"github.com/meynay/BookStore/models"
+	"github.com/meynay/BookStore/store"
+)
+
+func blockedMessage(user models.User) string {
+	if user.Status == models.UserBanned {
+		return "account banned"
+	}
+	if user.SuspendedUntil != nil {
+		return "account suspended until " + user.SuspendedUntil.Format(time.RFC3339)
+	}
+	return "account suspended"
+}
+
+// userParam reads the :id of the user an admin acts on. Admins can't act on
+// themselves so they can't lock everybody out of user management.
+func userParam(c *gin.Context) (int, bool) {
+	uid, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return 0, false
+	}
+	if uid == functions.GetUserId(c.GetHeader("Authorization")) {
+		c.JSON(http.StatusConflict, gin.H{"error": "admins can't change their own account"})
+		return 0, false
+	}
+	return uid, true
+}
+
+// users section
+func (app *App) ListUsers(c *gin.Context) {
+	var filter models.UserFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if filter.Role != "" && !models.ValidRole(filter.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown role"})
+		return
+	}
+	users, err := app.Users.List(filter)
+	if errors.Is(err, store.ErrInvalidPage) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range users.Users {
+		users.Users[i].Password = ""
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+func (app *App) GetUserActivity(c *gin.Context) {
+	uid, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	var activity models.UserActivity
+	activity.User, err = app.Users.Get(uid)
+	if errors.Is(err, store.ErrNotFound) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	activity.User.Password = ""
+	if activity.Borrows, err = app.Borrows.History(uid); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if activity.Invoices, err = app.Invoices.History(uid); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if activity.Ratings, err = app.Ratings.ByUser(uid); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, activity)
+}
+
+func (app *App) SuspendUser(c *gin.Context) {
+	uid, ok := userParam(c)
+	if !ok {
+		return
+	}
+	var request struct {
+		Until  *time.Time `json:"until"`
+		Reason string     `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Until != nil && !request.Until.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "suspension must end in the future"})
+		return
+	}
+	app.setUserStatus(c, uid, models.UserSuspended, request.Reason, request.Until)
+}
+
+func (app *App) BanUser(c *gin.Context) {
+	uid, ok := userParam(c)
+	if !ok {
+		return
+	}
+	var request struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	app.setUserStatus(c, uid, models.UserBanned, request.Reason, nil)
+}
+
+func (app *App) ReinstateUser(c *gin.Context) {
+	uid, ok := userParam(c)
+	if !ok {
+		return
+	}
+	app.setUserStatus(c, uid, models.UserActive, "", nil)
+}
+
+func (app *App) setUserStatus(c *gin.Context, uid int, status, reason string, until *time.Time) {
+	user, err := app.Users.Get(uid)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "user not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := app.Users.SetStatus(uid, status, reason, until); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	app.audit(c, "user."+status, "user", uid,
+		gin.H{"status": user.Status, "status_reason": user.StatusReason, "suspended_until": user.SuspendedUntil},
+		gin.H{"status": status, "status_reason": reason, "suspended_until": until})
+	c.JSON(http.StatusOK, gin.H{"message": "user is " + status})
+}
+
+// ForcePasswordReset signs the user out everywhere, stops them from signing
+// in with their password and mails them a reset link.
+func (app *App) ForcePasswordReset(c *gin.Context) {
+	uid, ok := userParam(c)
+	if !ok {
+		return
+	}
+	user, err := app.Users.Get(uid)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "user not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := app.Users.ForcePasswordReset(uid); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := app.Sessions.RevokeAll(uid); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	app.audit(c, "user.force_reset", "user", uid, nil, nil)
+	if err := app.sendResetMail(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "password reset email sent"})
+}
+
+func (app *App) GetRoles(c *gin.Context) {
+	c.JSON(http.StatusOK, models.RolePermissions)
+}
+
+func (app *App) SetUserRole(c *gin.Context) {
+	uid, ok := userParam(c)
+	if !ok {
+		return
+	}
+	var request struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !models.ValidRole(request.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be customer, librarian, store_manager or admin"})
+		return
+	}
+	user, err := app.Users.Get(uid)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "user not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := app.Users.SetRole(uid, request.Role); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	app.audit(c, "user.role", "user", uid, gin.H{"role": user.Role}, gin.H{"role": request.Role})
+	c.JSON(http.StatusOK, gin.H{"message": "role updated"})
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meynay/BookStore/functions"
+	"github.com/meynay/BookStore/models"
+)
+
+// authenticated runs a request through AuthMiddleware alone.
+func authenticated(app *App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		app.AuthMiddleware()(c)
+		if !c.IsAborted() {
+			c.Status(http.StatusNoContent)
+		}
+	}
+}
+
+func TestBlockedUsers(t *testing.T) {
+	tests := []struct {
+		name    string
+		block   func(t *testing.T, app *App, uid int)
+		blocked bool
+	}{
+		{"active", func(t *testing.T, app *App, uid int) {}, false},
+		{"suspended", func(t *testing.T, app *App, uid int) {
+			until := time.Now().Add(time.Hour).Format(time.RFC3339)
+			if w := serve(t, app.SuspendUser, "/admin/users/:id/suspend", "POST", "/admin/users/1/suspend", 2, `{"until": "`+until+`", "reason": "spam"}`); w.Code != http.StatusOK {
+				t.Fatalf("suspend: got %d %s", w.Code, w.Body)
+			}
+		}, true},
+		{"suspended until lifted", func(t *testing.T, app *App, uid int) {
+			if w := serve(t, app.SuspendUser, "/admin/users/:id/suspend", "POST", "/admin/users/1/suspend", 2, `{}`); w.Code != http.StatusOK {
+				t.Fatalf("suspend: got %d %s", w.Code, w.Body)
+			}
+		}, true},
+		{"suspension over", func(t *testing.T, app *App, uid int) {
+			ended := time.Now().Add(-time.Minute)
+			app.Users.SetStatus(uid, models.UserSuspended, "spam", &ended)
+		}, false},
+		{"banned", func(t *testing.T, app *App, uid int) {
+			if w := serve(t, app.BanUser, "/admin/users/:id/ban", "POST", "/admin/users/1/ban", 2, `{"reason": "fraud"}`); w.Code != http.StatusOK {
+				t.Fatalf("ban: got %d %s", w.Code, w.Body)
+			}
+		}, true},
+		{"reinstated", func(t *testing.T, app *App, uid int) {
+			app.Users.SetStatus(uid, models.UserBanned, "fraud", nil)
+			serve(t, app.ReinstateUser, "/admin/users/:id/reinstate", "POST", "/admin/users/1/reinstate", 2, "")
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := testApp(t)
+			hash, err := functions.HashPassword("Secret#123")
+			if err != nil {
+				t.Fatal(err)
+			}
+			uid := addUser(t, app, models.User{Email: "reader@example.com", Password: hash, Role: models.RoleCustomer, Status: models.UserActive})
+			// signed in before the block, serve uses this first session
+			if err := app.Sessions.Create(&models.Session{Uid: uid, ExpiresAt: time.Now().Add(time.Hour)}, "refresh"); err != nil {
+				t.Fatal(err)
+			}
+			tt.block(t, app, uid)
+
+			want := http.StatusOK
+			if tt.blocked {
+				want = http.StatusForbidden
+			}
+			w := serve(t, app.Login, "/login", "POST", "/login", 0, `{"email": "Reader@example.com", "password": "Secret#123"}`)
+			if w.Code != want {
+				t.Errorf("login: got %d %s, want %d", w.Code, w.Body, want)
+			}
+			// tokens handed out before the block stop working too
+			if !tt.blocked {
+				want = http.StatusNoContent
+			}
+			if w := serve(t, authenticated(app), "/userinfo", "GET", "/userinfo", uid, ""); w.Code != want {
+				t.Errorf("existing token: got %d %s, want %d", w.Code, w.Body, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meynay/BookStore/functions"
+	"github.com/meynay/BookStore/models"
+)
+
+// verified runs handler behind RequireVerifiedEmail like the borrow and
+// checkout routes in main.
+func verified(app *App, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		app.RequireVerifiedEmail()(c)
+		if !c.IsAborted() {
+			handler(c)
+		}
+	}
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	app := testApp(t)
+	uid := addUser(t, app, models.User{Email: "reader@example.com", Role: models.RoleCustomer})
+	bid := addBook(t, app, models.Book{Title: "Kelidar", QuantityInLib: 1, QuantityForSale: 1})
+
+	for _, route := range []struct {
+		route, path string
+		handler     gin.HandlerFunc
+	}{
+		{"/borrowbook/:bookid", "/borrowbook/1", app.BorrowBook},
+		{"/finalizeinvoice", "/finalizeinvoice", app.FinalizeInvoice},
+	} {
+		if w := serve(t, verified(app, route.handler), route.route, "POST", route.path, uid, ""); w.Code != http.StatusForbidden {
+			t.Errorf("%s unverified: got %d %s, want 403", route.path, w.Code, w.Body)
+		}
+	}
+	if borrowed, _ := app.Borrows.IsBorrowed(bid); borrowed {
+		t.Error("unverified user borrowed the book")
+	}
+
+	if err := app.Users.SetEmailVerified(uid); err != nil {
+		t.Fatal(err)
+	}
+	passed := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+	if w := serve(t, verified(app, passed), "/finalizeinvoice", "POST", "/finalizeinvoice", uid, ""); w.Code != http.StatusNoContent {
+		t.Errorf("verified: got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	app := testApp(t)
+	uid := addUser(t, app, models.User{Email: "reader@example.com", Role: models.RoleCustomer})
+	verify := func(token string) int {
+		t.Helper()
+		return serve(t, app.VerifyEmail, "/verifyemail/:token", "GET", "/verifyemail/"+token, 0, "").Code
+	}
+	isVerified := func() bool {
+		user, _ := app.Users.Get(uid)
+		return user.EmailVerified
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"other address", functions.SignEmailToken("verify-email", uid, "old@example.com", time.Now().Add(time.Hour))},
+		{"expired", functions.SignEmailToken("verify-email", uid, "reader@example.com", time.Now().Add(-time.Minute))},
+		{"unknown user", functions.SignEmailToken("verify-email", 99, "reader@example.com", time.Now().Add(time.Hour))},
+		{"unlock link", functions.SignEmailToken("unlock-account", uid, "reader@example.com", time.Now().Add(time.Hour))},
+		{"forged", functions.SignEmailToken("verify-email", uid, "reader@example.com", time.Now().Add(time.Hour)) + "x"},
+		{"garbage", "not-a-token"},
+	}
+	for _, tt := range tests {
+		if code := verify(tt.token); code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", tt.name, code)
+		}
+	}
+	if isVerified() {
+		t.Fatal("verified by a rejected token")
+	}
+
+	token := functions.SignEmailToken("verify-email", uid, "reader@example.com", time.Now().Add(VERIFYTOKENTTL))
+	if code := verify(token); code != http.StatusOK || !isVerified() {
+		t.Errorf("valid token: got %d, verified %v", code, isVerified())
+	}
+	// following the link twice is harmless
+	if code := verify(token); code != http.StatusOK {
+		t.Errorf("second use: got %d, want 200", code)
+	}
+}
@@ -0,0 +1,87 @@
+package images
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"regexp"
+
+	"golang.org/x/image/draw"
+)
+
+const AVATARDIR = "avatars"
+
+// AvatarSizes are the square sizes a profile picture is stored in, the
+// largest one first.
+var AvatarSizes = []int{256, 64}
+
+var avatarName = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+type AvatarFile struct {
+	Key  string
+	Size int
+	Data []byte
+}
+
+// Avatars crops the picture to a square and renders every size of it. The
+// name is a hash of the largest size, so it says nothing about the
+// uploaded file and the same picture always gets the same name.
+func Avatars(img image.Image) (string, []AvatarFile, error) {
+	img = Crop(img)
+	rendered := make([][]byte, len(AvatarSizes))
+	for i, size := range AvatarSizes {
+		var buf bytes.Buffer
+		if err := EncodeJPEG(&buf, Resize(img, size, size)); err != nil {
+			return "", nil, err
+		}
+		rendered[i] = buf.Bytes()
+	}
+	sum := sha256.Sum256(rendered[0])
+	name := hex.EncodeToString(sum[:16])
+	files := make([]AvatarFile, len(AvatarSizes))
+	for i, size := range AvatarSizes {
+		files[i] = AvatarFile{Key: AvatarKey(name, size), Size: size, Data: rendered[i]}
+	}
+	return name, files, nil
+}
+
+// LegacyAvatar tells pictures uploaded before they were resized, which are
+// named after the uploaded file, from the hashed ones.
+func LegacyAvatar(name string) bool {
+	return !avatarName.MatchString(name)
+}
+
+// AvatarKey is the blob key of one size of a profile picture. Pictures
+// uploaded before they were resized live under their own name and come in
+// one size only.
+func AvatarKey(name string, size int) string {
+	if LegacyAvatar(name) {
+		return name
+	}
+	return fmt.Sprintf("%s/%s-%d.jpg", AVATARDIR, name, size)
+}
+
+// AvatarKeys lists every blob of a profile picture so they can be removed.
+func AvatarKeys(name string) []string {
+	if LegacyAvatar(name) {
+		return []string{name}
+	}
+	keys := []string{}
+	for _, size := range AvatarSizes {
+		keys = append(keys, AvatarKey(name, size))
+	}
+	return keys
+}
+
+// Crop cuts the largest centered square out of the image.
+func Crop(img image.Image) image.Image {
+	b := img.Bounds()
+	size := min(b.Dx(), b.Dy())
+	x := b.Min.X + (b.Dx()-size)/2
+	y := b.Min.Y + (b.Dy()-size)/2
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(dst, dst.Bounds(), img, image.Pt(x, y), draw.Src)
+	return dst
+}
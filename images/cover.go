@@ -0,0 +1,127 @@
+package images
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+
+	"github.com/meynay/BookStore/models"
+)
+
+const COVERPATH = "/covers/"
+
+// Cover variants and the widths they are scaled down to. The full variant
+// is what book.image_url points at; lists of books show the card variant.
+const (
+	Thumbnail = "thumbnail"
+	Card      = "card"
+	Full      = "full"
+)
+
+var CoverWidths = map[string]int{
+	Thumbnail: 160,
+	Card:      320,
+	Full:      1200,
+}
+
+var CoverFormats = map[string]func(io.Writer, image.Image) error{
+	"jpg":  EncodeJPEG,
+	"webp": EncodeWebP,
+}
+
+var CoverTypes = map[string]string{
+	"jpg":  "image/jpeg",
+	"webp": "image/webp",
+}
+
+// CoverFile is one encoded variant of a cover, ready to be saved under Name.
+type CoverFile struct {
+	Name        string
+	Variant     string
+	Format      string
+	ContentType string
+	Width       int
+	Height      int
+	Data        []byte
+}
+
+// Covers renders every variant of the cover in every format. The file names
+// hold a hash of the pixels so a new upload never hits a stale cached copy.
+func Covers(bid int, img image.Image) ([]CoverFile, error) {
+	var full bytes.Buffer
+	if err := EncodeJPEG(&full, Fit(img, CoverWidths[Full])); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(full.Bytes())
+	prefix := fmt.Sprintf("%d-%s", bid, hex.EncodeToString(sum[:6]))
+	files := []CoverFile{}
+	for _, variant := range []string{Thumbnail, Card, Full} {
+		scaled := Fit(img, CoverWidths[variant])
+		for _, format := range []string{"jpg", "webp"} {
+			var buf bytes.Buffer
+			if err := CoverFormats[format](&buf, scaled); err != nil {
+				return nil, err
+			}
+			files = append(files, CoverFile{
+				Name:        fmt.Sprintf("%s-%s.%s", prefix, variant, format),
+				Variant:     variant,
+				Format:      format,
+				ContentType: CoverTypes[format],
+				Width:       scaled.Bounds().Dx(),
+				Height:      scaled.Bounds().Dy(),
+				Data:        buf.Bytes(),
+			})
+		}
+	}
+	return files, nil
+}
+
+// CoverURL rewrites the url of an uploaded cover to another variant and
+// format. Urls of covers that weren't uploaded here are returned as is since
+// there are no variants of them.
+func CoverURL(url, variant, format string) string {
+	if !strings.HasPrefix(url, COVERPATH) || !strings.HasSuffix(url, "-"+Full+".jpg") {
+		return url
+	}
+	return strings.TrimSuffix(url, Full+".jpg") + variant + "." + format
+}
+
+// CoverSet lists the urls of every variant of an uploaded cover, or nil for
+// covers linked from elsewhere.
+func CoverSet(url string) map[string]models.Cover {
+	if CoverURL(url, Card, "jpg") == url {
+		return nil
+	}
+	set := make(map[string]models.Cover)
+	for variant := range CoverWidths {
+		set[variant] = models.Cover{
+			Jpeg: CoverURL(url, variant, "jpg"),
+			Webp: CoverURL(url, variant, "webp"),
+		}
+	}
+	return set
+}
+
+// CoverNames lists the file names of every variant of an uploaded cover so
+// they can be removed when it is replaced.
+func CoverNames(url string) []string {
+	if CoverURL(url, Card, "jpg") == url {
+		return nil
+	}
+	names := []string{}
+	for variant := range CoverWidths {
+		for format := range CoverFormats {
+			names = append(names, strings.TrimPrefix(CoverURL(url, variant, format), COVERPATH))
+		}
+	}
+	return names
+}
+
+// CardURL is the url lists of books show.
+func CardURL(url string) string {
+	return CoverURL(url, Card, "jpg")
+}
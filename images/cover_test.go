@@ -0,0 +1,68 @@
+package images
+
+import (
+	"fmt"
+	"image"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCoverURL(t *testing.T) {
+	for _, tt := range []struct {
+		url, variant, format, want string
+	}{
+		{"/covers/7-0a1b2c3d4e5f-full.jpg", Card, "jpg", "/covers/7-0a1b2c3d4e5f-card.jpg"},
+		{"/covers/7-0a1b2c3d4e5f-full.jpg", Thumbnail, "webp", "/covers/7-0a1b2c3d4e5f-thumbnail.webp"},
+		{"/covers/7-0a1b2c3d4e5f-full.jpg", Full, "jpg", "/covers/7-0a1b2c3d4e5f-full.jpg"},
+		{"https://example.com/covers/7-full.jpg", Card, "jpg", "https://example.com/covers/7-full.jpg"},
+		{"/covers/7.png", Card, "webp", "/covers/7.png"},
+		{"", Card, "jpg", ""},
+	} {
+		if got := CoverURL(tt.url, tt.variant, tt.format); got != tt.want {
+			t.Errorf("CoverURL(%q, %s, %s) = %q, want %q", tt.url, tt.variant, tt.format, got, tt.want)
+		}
+	}
+	if set := CoverSet("https://example.com/7.jpg"); set != nil {
+		t.Errorf("external cover has variants %v", set)
+	}
+	if names := CoverNames("https://example.com/7.jpg"); names != nil {
+		t.Errorf("external cover has files %v", names)
+	}
+}
+
+func TestCovers(t *testing.T) {
+	files, err := Covers(7, image.NewRGBA(image.Rect(0, 0, 1600, 2400)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, f := range files {
+		if want := CoverWidths[f.Variant]; f.Width != want || f.Height != want*3/2 {
+			t.Errorf("%s is %dx%d, want width %d", f.Name, f.Width, f.Height, want)
+		}
+		if len(f.Data) == 0 {
+			t.Errorf("%s is empty", f.Name)
+		}
+		names = append(names, f.Name)
+	}
+	var full string
+	for _, name := range names {
+		if strings.HasSuffix(name, "-full.jpg") {
+			full = COVERPATH + name
+		}
+	}
+	if !strings.HasPrefix(full, fmt.Sprintf("%s7-", COVERPATH)) {
+		t.Fatalf("no full jpeg among %v", names)
+	}
+	// every file written is one the urls of the full variant lead to
+	got := CoverNames(full)
+	sort.Strings(got)
+	sort.Strings(names)
+	if strings.Join(got, " ") != strings.Join(names, " ") {
+		t.Errorf("CoverNames = %v, want %v", got, names)
+	}
+	if card := CoverSet(full)[Card]; card.Jpeg != CardURL(full) || !strings.HasSuffix(card.Webp, "-card.webp") {
+		t.Errorf("card cover = %+v", card)
+	}
+}
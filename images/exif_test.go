@@ -0,0 +1,117 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// exifSegment is an APP1 segment whose IFD holds a camera make and then the
+// orientation, written in the "II" or "MM" TIFF byte order.
+func exifSegment(byteOrder string, value int) []byte {
+	var order binary.AppendByteOrder = binary.LittleEndian
+	if byteOrder == "MM" {
+		order = binary.BigEndian
+	}
+	tiff := []byte(byteOrder)
+	tiff = order.AppendUint16(tiff, 42)
+	tiff = order.AppendUint32(tiff, 8)
+	tiff = order.AppendUint16(tiff, 2)
+	for _, entry := range [][2]uint16{{0x010F, 0}, {0x0112, uint16(value)}} {
+		tiff = order.AppendUint16(tiff, entry[0])
+		tiff = order.AppendUint16(tiff, 3)
+		tiff = order.AppendUint32(tiff, 1)
+		tiff = order.AppendUint16(tiff, entry[1])
+		tiff = append(tiff, 0, 0)
+	}
+	tiff = order.AppendUint32(tiff, 0)
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// withSegments puts the segments right after the start of image marker.
+func withSegments(data []byte, segments ...[]byte) []byte {
+	out := append([]byte{}, data[:2]...)
+	for _, s := range segments {
+		out = append(out, s...)
+	}
+	return append(out, data[2:]...)
+}
+
+func TestOrientation(t *testing.T) {
+	app0 := []byte{0xFF, 0xE0, 0x00, 0x07, 'J', 'F', 'I', 'F', 0}
+	sos := []byte{0xFF, 0xDA, 0x00, 0x02}
+	jpegStart := []byte{0xFF, 0xD8}
+	for value := 1; value <= 8; value++ {
+		for _, order := range []string{"II", "MM"} {
+			data := withSegments(append(jpegStart, sos...), app0, exifSegment(order, value))
+			if got := orientation(data); got != value {
+				t.Errorf("%s orientation %d: got %d", order, value, got)
+			}
+		}
+	}
+
+	exif := exifSegment("MM", 6)
+	for name, data := range map[string][]byte{
+		"no exif":        withSegments(append(jpegStart, sos...), app0),
+		"out of range":   withSegments(append(jpegStart, sos...), exifSegment("MM", 9)),
+		"after the scan": append(append(append([]byte{}, jpegStart...), sos...), exif...),
+		"truncated":      append(append([]byte{}, jpegStart...), exif[:len(exif)-10]...),
+		"not a jpeg":     append([]byte{0x89, 'P', 'N', 'G'}, exif...),
+	} {
+		if got := orientation(data); got != 1 {
+			t.Errorf("%s: got %d, want 1", name, got)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// a 2x3 image stored sideways, two marked pixels on its top row
+	src := image.NewGray(image.Rect(0, 0, 2, 3))
+	src.SetGray(0, 0, color.Gray{Y: 100})
+	src.SetGray(1, 0, color.Gray{Y: 200})
+	for _, tt := range []struct {
+		orientation   int
+		width, height int
+		first, second image.Point
+	}{
+		{1, 2, 3, image.Pt(0, 0), image.Pt(1, 0)},
+		{2, 2, 3, image.Pt(1, 0), image.Pt(0, 0)},
+		{3, 2, 3, image.Pt(1, 2), image.Pt(0, 2)},
+		{4, 2, 3, image.Pt(0, 2), image.Pt(1, 2)},
+		{5, 3, 2, image.Pt(0, 0), image.Pt(0, 1)},
+		{6, 3, 2, image.Pt(2, 0), image.Pt(2, 1)},
+		{7, 3, 2, image.Pt(2, 1), image.Pt(2, 0)},
+		{8, 3, 2, image.Pt(0, 1), image.Pt(0, 0)},
+	} {
+		img := orient(src, tt.orientation)
+		if b := img.Bounds(); b.Dx() != tt.width || b.Dy() != tt.height {
+			t.Errorf("orientation %d: %dx%d, want %dx%d", tt.orientation, b.Dx(), b.Dy(), tt.width, tt.height)
+			continue
+		}
+		first := color.GrayModel.Convert(img.At(tt.first.X, tt.first.Y)).(color.Gray).Y
+		second := color.GrayModel.Convert(img.At(tt.second.X, tt.second.Y)).(color.Gray).Y
+		if first != 100 || second != 200 {
+			t.Errorf("orientation %d: got %d at %v and %d at %v", tt.orientation, first, tt.first, second, tt.second)
+		}
+	}
+}
+
+func TestDecodeTurnsUpright(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 40, 30)), nil); err != nil {
+		t.Fatal(err)
+	}
+	img, err := Decode(bytes.NewReader(withSegments(buf.Bytes(), exifSegment("II", 6))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 30 || b.Dy() != 40 {
+		t.Errorf("decoded %dx%d, want 30x40", b.Dx(), b.Dy())
+	}
+}
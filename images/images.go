@@ -0,0 +1,107 @@
+package images
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// MaxPixels keeps a small file that decodes to a huge bitmap from eating
+// the server's memory.
+const MaxPixels = 40_000_000
+
+const JPEGQuality = 85
+
+var (
+	ErrNotImage = errors.New("file is not a jpeg, png, gif or webp image")
+	ErrTooLarge = errors.New("image dimensions are too large")
+)
+
+// Types maps the sniffed content types we accept to the decoder format name.
+var Types = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+}
+
+// Decode sniffs the content type from the first bytes instead of trusting
+// the file name or the client's header, checks the dimensions before
+// decoding the pixels, and turns the image upright by its EXIF orientation.
+// Nothing but the pixels survive, so re-encoding the result strips all
+// metadata.
+func Decode(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(512)
+	format, ok := Types[http.DetectContentType(head)]
+	if !ok {
+		return nil, ErrNotImage
+	}
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	config, decoded, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || decoded != format {
+		return nil, ErrNotImage
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, ErrNotImage
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotImage, err)
+	}
+	if format == "jpeg" {
+		img = orient(img, orientation(data))
+	}
+	return img, nil
+}
+
+// Fit scales the image down to the given width keeping its aspect ratio.
+// Images already narrower than width are only copied, never upscaled.
+func Fit(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	if b.Dx() <= width {
+		width = b.Dx()
+	}
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	return Resize(img, width, height)
+}
+
+// Resize scales the image to exactly width x height.
+func Resize(img image.Image, width, height int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// EncodeJPEG flattens transparent images on white since JPEG has no alpha.
+func EncodeJPEG(w io.Writer, img image.Image) error {
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return jpeg.Encode(w, dst, &jpeg.Options{Quality: JPEGQuality})
+}
+
+func EncodeWebP(w io.Writer, img image.Image) error {
+	return nativewebp.Encode(w, img, nil)
+}
@@ -0,0 +1,277 @@
+// Package keyring keeps the keys access tokens are signed with. Keys live
+// in a directory shared by every instance of the server: a keyring.json
+// listing them, and a PKCS #8 PEM file with each private key. Only the
+// current key signs; keys it replaced keep verifying until they're pruned,
+// so rotating doesn't sign anybody out.
+package keyring
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	EdDSA = "EdDSA"
+	RS256 = "RS256"
+)
+
+// ReloadInterval is how often the directory is read again, so keys
+// rotated or pruned by another instance are picked up.
+const ReloadInterval = time.Minute
+
+const manifestName = "keyring.json"
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+type Key struct {
+	Id        string     `json:"kid"`
+	Algorithm string     `json:"alg"`
+	Created   time.Time  `json:"created"`
+	Retired   *time.Time `json:"retired,omitempty"`
+	private   crypto.Signer
+}
+
+func (k *Key) Public() crypto.PublicKey {
+	return k.private.Public()
+}
+
+func (k *Key) method() jwt.SigningMethod {
+	if k.Algorithm == EdDSA {
+		return SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+type manifest struct {
+	Current string `json:"current"`
+	Keys    []*Key `json:"keys"`
+}
+
+type Keyring struct {
+	dir      string
+	mu       sync.RWMutex
+	current  *Key
+	keys     map[string]*Key
+	reloaded time.Time
+}
+
+// Load reads the keyring in dir. It fails with an error matching
+// os.ErrNotExist when no key was ever made there.
+func Load(dir string) (*Keyring, error) {
+	ring := &Keyring{dir: dir}
+	return ring, ring.Reload()
+}
+
+func readManifest(dir string) (manifest, error) {
+	var m manifest
+	raw, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		return m, err
+	}
+	return m, json.Unmarshal(raw, &m)
+}
+
+func (r *Keyring) Reload() error {
+	m, err := readManifest(r.dir)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]*Key)
+	for _, key := range m.Keys {
+		raw, err := os.ReadFile(filepath.Join(r.dir, key.Id+".pem"))
+		if err != nil {
+			return err
+		}
+		block, _ := pem.Decode(raw)
+		if block == nil {
+			return fmt.Errorf("key %s: no PEM data", key.Id)
+		}
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("key %s: %w", key.Id, err)
+		}
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return fmt.Errorf("key %s: unsupported key type", key.Id)
+		}
+		key.private = signer
+		keys[key.Id] = key
+	}
+	current, ok := keys[m.Current]
+	if !ok {
+		return fmt.Errorf("current key %q missing from keyring", m.Current)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current, r.keys, r.reloaded = current, keys, time.Now()
+	return nil
+}
+
+// refresh reloads the keyring when it is older than ReloadInterval, or
+// when force is set and it is older than a second, which lets an unknown
+// kid trigger a reload without letting bad tokens hammer the disk.
+func (r *Keyring) refresh(force bool) {
+	r.mu.RLock()
+	age := time.Since(r.reloaded)
+	r.mu.RUnlock()
+	if age > ReloadInterval || force && age > time.Second {
+		// a failed reload keeps the keys we have
+		r.Reload()
+	}
+}
+
+// Sign makes a token for claims with the current key, naming it in the
+// kid header.
+func (r *Keyring) Sign(claims jwt.Claims) (string, error) {
+	r.refresh(false)
+	r.mu.RLock()
+	key := r.current
+	r.mu.RUnlock()
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.Id
+	return token.SignedString(key.private)
+}
+
+// Keyfunc is for jwt.Parse. It picks the public key by kid and refuses
+// tokens whose alg doesn't match that key.
+func (r *Keyring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	r.refresh(false)
+	key := r.key(kid)
+	if key == nil {
+		r.refresh(true)
+		key = r.key(kid)
+	}
+	if key == nil {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("key %s is for %s, not %s", key.Id, key.Algorithm, token.Method.Alg())
+	}
+	return key.Public(), nil
+}
+
+func (r *Keyring) key(kid string) *Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.keys[kid]
+}
+
+// Keys lists the keys oldest first.
+func (r *Keyring) Keys() []Key {
+	r.refresh(false)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	keys := make([]Key, 0, len(r.keys))
+	for _, key := range r.keys {
+		keys = append(keys, *key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Created.Before(keys[j].Created)
+	})
+	return keys
+}
+
+func (r *Keyring) Current() Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return *r.current
+}
+
+func generate(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case EdDSA:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	case RS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}
+	return nil, fmt.Errorf("unsupported algorithm %q, use %s or %s", algorithm, EdDSA, RS256)
+}
+
+// writeFile replaces name in one step, so a reader never sees it half
+// written.
+func writeFile(name string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".keyring-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// Rotate makes a new current key in dir and retires the one before it.
+// Keys retired longer than retention ago are deleted; retention has to
+// outlast the tokens they signed.
+func Rotate(dir, algorithm string, retention time.Duration) (Key, error) {
+	private, err := generate(algorithm)
+	if err != nil {
+		return Key{}, err
+	}
+	m, err := readManifest(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Key{}, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return Key{}, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Key{}, err
+	}
+	now := time.Now().UTC()
+	key := &Key{Id: hex.EncodeToString(id), Algorithm: algorithm, Created: now}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return Key{}, err
+	}
+	if err := writeFile(filepath.Join(dir, key.Id+".pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})); err != nil {
+		return Key{}, err
+	}
+	keys := []*Key{}
+	var pruned []string
+	for _, old := range m.Keys {
+		if old.Retired == nil {
+			old.Retired = &now
+		}
+		if now.Sub(*old.Retired) > retention {
+			pruned = append(pruned, old.Id)
+			continue
+		}
+		keys = append(keys, old)
+	}
+	m.Current, m.Keys = key.Id, append(keys, key)
+	raw, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return Key{}, err
+	}
+	if err := writeFile(filepath.Join(dir, manifestName), raw); err != nil {
+		return Key{}, err
+	}
+	for _, id := range pruned {
+		os.Remove(filepath.Join(dir, id+".pem"))
+	}
+	return *key, nil
+}
@@ -0,0 +1,190 @@
+package keyring
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// The Ed25519 example of RFC 8037 appendix A.4.
+func TestEdDSAKnownSignature(t *testing.T) {
+	seed, _ := base64.RawURLEncoding.DecodeString("nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A")
+	private := ed25519.NewKeyFromSeed(seed)
+	signingString := "eyJhbGciOiJFZERTQSJ9.RXhhbXBsZSBvZiBFZDI1NTE5IHNpZ25pbmc"
+	want := "hgyY0il_MGCjP0JzlnLWG1PPOt7-09PGcvMg3AIbQR6dWbhijcNR4ki4iylGjg5BhVsPt9g7sVvpAr_MuM0KAg"
+	got, err := SigningMethodEdDSA.Sign(signingString, private)
+	if err != nil || got != want {
+		t.Fatalf("Sign = %s, %v; want %s", got, err, want)
+	}
+	public := private.Public()
+	if err := SigningMethodEdDSA.Verify(signingString, want, public); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if err := SigningMethodEdDSA.Verify(signingString+"x", want, public); !errors.Is(err, jwt.ErrSignatureInvalid) {
+		t.Errorf("Verify of changed content: got %v, want ErrSignatureInvalid", err)
+	}
+	if err := SigningMethodEdDSA.Verify(signingString, want, []byte("secret")); !errors.Is(err, jwt.ErrInvalidKeyType) {
+		t.Errorf("Verify with an hmac key: got %v, want ErrInvalidKeyType", err)
+	}
+}
+
+func newRing(t *testing.T, algorithm string) (string, *Keyring) {
+	t.Helper()
+	dir := t.TempDir()
+	if _, err := Rotate(dir, algorithm, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	ring, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, ring
+}
+
+// parse verifies token with ring. Errors of Keyfunc come back as they are,
+// not wrapped in the jwt.ValidationError that doesn't unwrap.
+func parse(ring *Keyring, token string) (*jwt.StandardClaims, error) {
+	claims := &jwt.StandardClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, ring.Keyfunc)
+	var invalid *jwt.ValidationError
+	if errors.As(err, &invalid) && invalid.Inner != nil {
+		return claims, invalid.Inner
+	}
+	return claims, err
+}
+
+func TestSignAndVerify(t *testing.T) {
+	for _, algorithm := range []string{EdDSA, RS256} {
+		t.Run(algorithm, func(t *testing.T) {
+			_, ring := newRing(t, algorithm)
+			token, err := ring.Sign(&jwt.StandardClaims{Subject: "7"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := parse(ring, token)
+			if err != nil || claims.Subject != "7" {
+				t.Fatalf("parse = %+v, %v", claims, err)
+			}
+			_, other := newRing(t, algorithm)
+			if _, err := parse(other, token); err == nil {
+				t.Error("another keyring verified the token")
+			}
+		})
+	}
+}
+
+func TestKeyfuncRejects(t *testing.T) {
+	_, ring := newRing(t, RS256)
+	key := ring.Current()
+	claims := &jwt.StandardClaims{Subject: "7"}
+
+	// the public key used as an hmac secret, the classic alg confusion
+	public, _ := x509.MarshalPKIXPublicKey(key.Public())
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = key.Id
+	token, _ := forged.SignedString(public)
+	if _, err := parse(ring, token); err == nil || err.Error() != "key "+key.Id+" is for RS256, not HS256" {
+		t.Errorf("HS256 token for an RS256 key: got %v", err)
+	}
+
+	_, edRing := newRing(t, EdDSA)
+	edToken, _ := edRing.Sign(claims)
+	if _, err := parse(edRing, edToken); err != nil {
+		t.Fatal(err)
+	}
+	renamed := jwt.NewWithClaims(SigningMethodEdDSA, claims)
+	renamed.Header["kid"] = key.Id
+	token, _ = renamed.SignedString(edRing.Current().private)
+	if _, err := parse(ring, token); err == nil || err.Error() != "key "+key.Id+" is for RS256, not EdDSA" {
+		t.Errorf("EdDSA token for an RS256 key: got %v", err)
+	}
+
+	unknown := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	unknown.Header["kid"] = "0000000000000000"
+	token, _ = unknown.SignedString(key.private)
+	if _, err := parse(ring, token); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("unknown kid: got %v, want ErrUnknownKey", err)
+	}
+	delete(unknown.Header, "kid")
+	token, _ = unknown.SignedString(key.private)
+	if _, err := parse(ring, token); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("no kid: got %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	dir, ring := newRing(t, RS256)
+	first := ring.Current()
+	old, _ := ring.Sign(&jwt.StandardClaims{Subject: "7"})
+
+	second, err := Rotate(dir, EdDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if ring.Current().Id != second.Id {
+		t.Fatalf("current = %s, want %s", ring.Current().Id, second.Id)
+	}
+	if _, err := parse(ring, old); err != nil {
+		t.Errorf("token of the replaced key: %v", err)
+	}
+	keys := ring.Keys()
+	if len(keys) != 2 || keys[0].Id != first.Id || keys[0].Retired == nil || keys[1].Retired != nil {
+		t.Errorf("keys after rotating = %+v", keys)
+	}
+
+	if _, err := Rotate(dir, EdDSA, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parse(ring, old); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("token of a pruned key: got %v, want ErrUnknownKey", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, first.Id+".pem")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("pruned key file still there: %v", err)
+	}
+	if got := len(ring.Keys()); got != 2 {
+		t.Errorf("%d keys after pruning, want 2", got)
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	if _, err := Load(t.TempDir()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Load of an empty dir: got %v, want os.ErrNotExist", err)
+	}
+	if _, err := Rotate(t.TempDir(), "HS256", time.Hour); err == nil {
+		t.Error("Rotate made an HS256 key")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	dir, ring := newRing(t, RS256)
+	Rotate(dir, EdDSA, time.Hour)
+	ring.Reload()
+	set := ring.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("%d keys, want 2", len(set.Keys))
+	}
+	rsaKey, edKey := set.Keys[0], set.Keys[1]
+	if rsaKey.Kty != "RSA" || rsaKey.Alg != RS256 || rsaKey.E != "AQAB" || rsaKey.N == "" || rsaKey.X != "" {
+		t.Errorf("RSA key = %+v", rsaKey)
+	}
+	x, _ := base64.RawURLEncoding.DecodeString(edKey.X)
+	if edKey.Kty != "OKP" || edKey.Crv != "Ed25519" || edKey.Alg != EdDSA || len(x) != ed25519.PublicKeySize {
+		t.Errorf("Ed25519 key = %+v", edKey)
+	}
+	if edKey.Kid != ring.Current().Id || edKey.Use != "sig" {
+		t.Errorf("Ed25519 key kid %s use %s", edKey.Kid, edKey.Use)
+	}
+}
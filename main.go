@@ -2,17 +2,29 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/meynay/BookStore/cache"
+	"github.com/meynay/BookStore/catalog"
+	"github.com/meynay/BookStore/functions"
 	"github.com/meynay/BookStore/handlers"
+	"github.com/meynay/BookStore/keyring"
+	"github.com/meynay/BookStore/migrations"
 	"github.com/meynay/BookStore/models"
+	"github.com/meynay/BookStore/store"
 )
 
 func getDB() *sql.DB {
@@ -29,13 +41,332 @@ func getDB() *sql.DB {
 	return db
 }
 
+func migrate(db *sql.DB, args []string) {
+	if len(args) == 0 {
+		fmt.Println("usage: migrate up|down [steps]|status")
+		os.Exit(2)
+	}
+	var err error
+	switch args[0] {
+	case "up":
+		err = migrations.Up(db)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil {
+				break
+			}
+		}
+		err = migrations.Down(db, steps)
+	case "status":
+		var statuses []migrations.Status
+		statuses, err = migrations.List(db)
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d %-30s %s\n", s.Version, s.Name, applied)
+		}
+	default:
+		err = fmt.Errorf("unknown migrate command %q", args[0])
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func importBooks(db *sql.DB, args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "validate and report without saving")
+	format := flags.String("format", "", "csv or onix, guessed from the file name if empty")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Println("usage: import [-dry-run] [-format csv|onix] file")
+		os.Exit(2)
+	}
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer file.Close()
+	records, err := catalog.Read(*format, file.Name(), file)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	report, err := catalog.Import(store.NewPostgres(db).Books, records, *dryRun)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	out.Encode(report)
+}
+
+// KEYRETENTION is how long a replaced signing key keeps verifying. It must
+// outlast every token the key signed.
+const KEYRETENTION = 24 * time.Hour
+
+// keysDir is where the signing keys live, JWT_KEYS_DIR or "keys".
+func keysDir() string {
+	if dir := os.Getenv("JWT_KEYS_DIR"); dir != "" {
+		return dir
+	}
+	return "keys"
+}
+
+func keys(args []string) {
+	if len(args) == 0 {
+		fmt.Println("usage: keys rotate [-alg RS256|EdDSA] [-retain duration]|list")
+		os.Exit(2)
+	}
+	var err error
+	switch args[0] {
+	case "rotate":
+		flags := flag.NewFlagSet("keys rotate", flag.ExitOnError)
+		alg := flags.String("alg", keyring.RS256, "RS256 or EdDSA")
+		retain := flags.Duration("retain", KEYRETENTION, "how long replaced keys keep verifying")
+		flags.Parse(args[1:])
+		var key keyring.Key
+		key, err = keyring.Rotate(keysDir(), *alg, *retain)
+		if err == nil {
+			fmt.Printf("new signing key %s (%s); restart is not needed\n", key.Id, key.Algorithm)
+		}
+	case "list":
+		var ring *keyring.Keyring
+		ring, err = keyring.Load(keysDir())
+		if err != nil {
+			break
+		}
+		current := ring.Current()
+		for _, key := range ring.Keys() {
+			state := "verifying"
+			if key.Id == current.Id {
+				state = "signing"
+			}
+			fmt.Printf("%s %-6s %s %s\n", key.Id, key.Algorithm, key.Created.Format(time.RFC3339), state)
+		}
+	default:
+		err = fmt.Errorf("unknown keys command %q", args[0])
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// getKeys loads the signing keys, making the first one on a fresh install.
+func getKeys() *keyring.Keyring {
+	ring, err := keyring.Load(keysDir())
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Println("No signing keys in", keysDir()+", making one")
+		if _, err = keyring.Rotate(keysDir(), keyring.RS256, KEYRETENTION); err == nil {
+			ring, err = keyring.Load(keysDir())
+		}
+	}
+	if err != nil {
+		panic(err)
+	}
+	return ring
+}
+
+func apiClients(db *sql.DB, args []string) {
+	if len(args) == 0 {
+		fmt.Println("usage: apiclients issue -name name -scopes catalog,account,admin [-rate n]|list|revoke id")
+		os.Exit(2)
+	}
+	clients := store.NewPostgres(db).Clients
+	var err error
+	switch args[0] {
+	case "issue":
+		flags := flag.NewFlagSet("apiclients issue", flag.ExitOnError)
+		name := flags.String("name", "", "who the key is for, like \"web app\"")
+		scopes := flags.String("scopes", "", "comma separated scopes: "+strings.Join(models.Scopes, ", "))
+		rate := flags.Int("rate", handlers.CLIENTRATELIMIT, "requests per minute")
+		flags.Parse(args[1:])
+		client := models.ApiClient{Name: *name, Scopes: strings.Split(*scopes, ","), RateLimit: *rate}
+		if client.Name == "" || *scopes == "" || client.RateLimit < 1 {
+			fmt.Println("apiclients issue needs a -name, -scopes and a positive -rate")
+			os.Exit(2)
+		}
+		for _, scope := range client.Scopes {
+			if !models.ValidScope(scope) {
+				fmt.Printf("unknown scope %q\n", scope)
+				os.Exit(2)
+			}
+		}
+		var key string
+		key, client.Prefix, client.Hash, err = functions.GenerateApiKey()
+		if err == nil {
+			err = clients.Create(&client)
+		}
+		if err == nil {
+			fmt.Printf("api client %d (%s): %s\nthe key isn't stored, keep it now\n", client.Id, client.Name, key)
+		}
+	case "list":
+		var list []models.ApiClient
+		list, err = clients.List()
+		for _, client := range list {
+			state := "active"
+			if client.RevokedAt != nil {
+				state = "revoked"
+			}
+			fmt.Printf("%d %s %-20s %-22s %5d/min %s\n", client.Id, client.Prefix, client.Name, strings.Join(client.Scopes, ","), client.RateLimit, state)
+		}
+	case "revoke":
+		var id int
+		if len(args) > 1 {
+			id, err = strconv.Atoi(args[1])
+		}
+		if err == nil {
+			err = clients.Revoke(id)
+		}
+		if err == nil {
+			fmt.Printf("revoked, running servers may accept the key for up to %s\n", handlers.CLIENTCACHETTL)
+		}
+	default:
+		err = fmt.Errorf("unknown apiclients command %q", args[0])
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// avatars moves profile pictures saved before the blob store into it.
+func avatars(db *sql.DB, args []string) {
+	flags := flag.NewFlagSet("avatars", flag.ExitOnError)
+	dir := flags.String("dir", os.Getenv("FILE_DIR"), "where the old pictures were saved")
+	flags.Parse(args)
+	if flags.NArg() != 1 || flags.Arg(0) != "migrate" {
+		fmt.Println("usage: avatars [-dir dir] migrate")
+		os.Exit(2)
+	}
+	app := handlers.App{Stores: store.NewPostgres(db), Blobs: getBlobs()}
+	moved, err := app.MigrateAvatars(*dir)
+	fmt.Println("moved", moved, "pictures")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// getBlobs picks the blob store from BLOB_STORE, the local FILE_DIR unless
+// it is "s3". Local urls are signed with BLOB_SECRET.
+func getBlobs() store.BlobStore {
+	if os.Getenv("BLOB_STORE") == "s3" {
+		return store.NewS3Blobs(store.S3Config{
+			Endpoint:       os.Getenv("S3_ENDPOINT"),
+			PublicEndpoint: os.Getenv("S3_PUBLIC_ENDPOINT"),
+			Region:         os.Getenv("S3_REGION"),
+			Bucket:         os.Getenv("S3_BUCKET"),
+			AccessKey:      os.Getenv("S3_ACCESS_KEY"),
+			SecretKey:      os.Getenv("S3_SECRET_KEY"),
+		})
+	}
+	secret := os.Getenv("BLOB_SECRET")
+	// with no secret anyone could sign urls for any file
+	if secret == "" {
+		fmt.Println("BLOB_SECRET must be set to sign file urls")
+		os.Exit(1)
+	}
+	return store.NewLocalBlobs(os.Getenv("FILE_DIR"), handlers.FILESPATH, secret)
+}
+
+// getEmailTokenSecret reads EMAIL_TOKEN_SECRET, the secret email links are
+// signed with.
+func getEmailTokenSecret() []byte {
+	secret := os.Getenv("EMAIL_TOKEN_SECRET")
+	// with no secret anyone could verify any address or unlock any account
+	if secret == "" {
+		fmt.Println("EMAIL_TOKEN_SECRET must be set to sign email links")
+		os.Exit(1)
+	}
+	return []byte(secret)
+}
+
+// getAttempts counts failed sign ins in Redis so every instance sees them,
+// or only in memory when no Redis is configured.
+func getAttempts() store.AttemptStore {
+	host := os.Getenv("REDIS_HOST")
+	if host == "" {
+		return store.NewMemoryAttempts()
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr: host + os.Getenv("REDIS_PORT"),
+	})
+	return store.FallbackAttempts(store.NewRedisAttempts(client), store.NewMemoryAttempts())
+}
+
+// getTwoFactorRoles reads the roles that must use two-factor authentication,
+// by default every role with more than customer rights.
+func getTwoFactorRoles() []string {
+	value, ok := os.LookupEnv("REQUIRE_2FA_ROLES")
+	if !ok {
+		return []string{models.RoleLibrarian, models.RoleStoreManager, models.RoleAdmin}
+	}
+	roles := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })
+	for _, role := range roles {
+		if !models.ValidRole(role) {
+			fmt.Printf("REQUIRE_2FA_ROLES: unknown role %q\n", role)
+			os.Exit(1)
+		}
+	}
+	return roles
+}
+
+// getTrustedProxies reads TRUSTED_PROXIES, the addresses or CIDR ranges of
+// the proxies whose X-Forwarded-For is believed. By default none is, so
+// clients can't pick the ip their sign in failures are counted against.
+func getTrustedProxies() []string {
+	return strings.FieldsFunc(os.Getenv("TRUSTED_PROXIES"), func(r rune) bool { return r == ',' || r == ' ' })
+}
+
 func main() {
 	err := godotenv.Load()
 	if err != nil {
 		fmt.Println("Error loading .env file")
 	}
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		keys(os.Args[2:])
+		return
+	}
+	db := getDB()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(db, os.Args[2:])
+		return
+	}
+	if err := migrations.Up(db); err != nil {
+		panic(err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		importBooks(db, os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "avatars" {
+		avatars(db, os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "apiclients" {
+		apiClients(db, os.Args[2:])
+		return
+	}
+	if os.Getenv("API_KEY") != "" {
+		fmt.Println("API_KEY is no longer read, issue each client its own key with: apiclients issue")
+	}
+	functions.Keys = getKeys()
+	functions.EmailTokenSecret = getEmailTokenSecret()
 	app := handlers.App{
-		DB: getDB(),
+		Stores:      store.NewPostgres(db),
+		Blobs:       getBlobs(),
+		Attempts:    getAttempts(),
+		Suggestions: cache.New[string, []models.Suggestion](1000, 5*time.Minute),
+		ClientCache: cache.New[string, models.ApiClient](1000, handlers.CLIENTCACHETTL),
 		Email: models.EmailConfig{
 			SMTPHost:    "smtp.gmail.com",
 			SMTPPort:    587,
@@ -43,7 +374,8 @@ func main() {
 			Password:    os.Getenv("SMTP_PASSWORD"),
 			SenderEmail: os.Getenv("SMTP_USERNAME"),
 		},
-		ResetToken: make(map[string]string),
+		TwoFactorRoles: getTwoFactorRoles(),
+		GetSignal:      make(map[int]chan bool),
 		RateLimit: models.RateLimiter{
 			Visitors: make(map[string][]bool),
 		},
@@ -61,7 +393,11 @@ func main() {
 		}
 	}()
 	engine := gin.Default()
-	engine.Use(gzip.Gzip(gzip.BestCompression, gzip.WithExcludedExtensions([]string{".png", ".jpeg"})))
+	if err := engine.SetTrustedProxies(getTrustedProxies()); err != nil {
+		fmt.Println("TRUSTED_PROXIES:", err)
+		os.Exit(1)
+	}
+	engine.Use(gzip.Gzip(gzip.BestCompression, gzip.WithExcludedExtensions([]string{".png", ".jpeg", ".jpg", ".webp"})))
 	engine.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"}, // Change to your domain
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
@@ -71,33 +407,88 @@ func main() {
 		MaxAge:           12 * time.Hour,
 	}))
 	engine.Use(app.DDOSPrevent())
+	// these apis take no api key, since e-readers, harvesters and img tags
+	// can't send one. Each ip is rate limited on them instead.
+	public := engine.Group("/", app.PublicRateLimit())
+	{
+		//opds catalog apis, open to e-reader apps that can't send an api key
+		public.GET("/opds", app.OPDSRoot)
+		public.GET("/opds/new", app.OPDSNew)
+		public.GET("/opds/books", app.OPDSBooks)
+		public.GET("/opds/genres", app.OPDSGenres)
+		public.GET("/opds/genres/:genre", app.OPDSGenre)
+		public.GET("/opds/search", app.OPDSSearch)
+		public.GET("/opds/search.xml", app.OPDSOpenSearch)
+		//metadata harvesting apis for partner libraries
+		public.GET("/oai", app.OAIPMH)
+		public.POST("/oai", app.OAIPMH)
+		public.GET("/sru", app.SRU)
+		//cover and uploaded images, loaded by img tags and e-readers without an api key
+		public.GET("/covers/:file", app.GetCover)
+		public.GET("/files/*key", app.ServeFile)
+		public.GET("/.well-known/jwks.json", app.JWKS)
+	}
 	engine.Use(app.ApiKeyCheck())
 	{
-		//user sign in/up apis
-		engine.POST("/login", app.Login)
-		engine.POST("/signup", app.Signup)
+		accounts := engine.Group("/", app.RequireScope(models.ScopeAccount))
+		{
+			//user sign in/up apis
+			accounts.POST("/login", app.Login)
+			accounts.POST("/signup", app.Signup)
+			accounts.POST("/login/2fa", app.LoginTwoFactor)
+			accounts.POST("/refresh", app.Refresh)
+
+			//reset password apis
+			accounts.POST("/tryresetpassword", app.ResetPasswordMail)
+			accounts.POST("/resetpassword/:token", app.ResetPassword)
 
-		//reset password apis
-		engine.POST("/tryresetpassword", app.ResetPasswordMail)
-		engine.POST("/resetpassword/:token", app.ResetPassword)
+			//email verification api
+			accounts.GET("/verifyemail/:token", app.VerifyEmail)
 
-		//get books apis
-		engine.GET("/getbooks", app.GetBooks)
-		engine.GET("/newbooks", app.GetNewBooks)
-		engine.POST("/filterbooks", app.FilterBooks)
+			//account lockout api
+			accounts.GET("/unlockaccount/:token", app.UnlockAccount)
+		}
+
+		catalog := engine.Group("/", app.RequireScope(models.ScopeCatalog))
+		{
+			//get books apis
+			catalog.GET("/getbooks", app.GetBooks)
+			catalog.GET("/newbooks", app.GetNewBooks)
+			catalog.POST("/filterbooks", app.FilterBooks)
+			catalog.GET("/search", app.Search)
+			catalog.GET("/suggest", app.Suggest)
+
+			//single book apis
+			catalog.GET("/getbook/:id", app.GetBook)
+			catalog.GET("/rates/:book_id", app.GetRates)
+			catalog.GET("/comments/:book_id", app.GetComments)
 
-		//single book apis
-		engine.GET("/getbook/:id", app.GetBook)
-		engine.GET("/rates/:book_id", app.GetRates)
-		engine.GET("/comments/:book_id", app.GetComments)
+			//author apis
+			catalog.GET("/authors", app.GetAuthors)
+			catalog.GET("/authors/:id", app.GetAuthor)
 
-		engine.Use(app.AuthMiddleware())
+			//series apis
+			catalog.GET("/series/:id", app.GetSeries)
+		}
+
+		engine.Use(app.RequireScope(models.ScopeAccount), app.AuthMiddleware())
 		{
 			//user profile apis
 			engine.GET("/userinfo", app.GetUserInfo)
 			engine.GET("/userprofile", app.GetUserProfile)
 			engine.GET("/image/:image", app.GetProfPic)
 			engine.POST("/userimageupload", app.UploadImage)
+			engine.DELETE("/userimage", app.DeleteImage)
+			engine.PUT("/userimage/visibility", app.SetAvatarVisibility)
+			engine.GET("/users/:id/avatar", app.GetAvatar)
+			engine.POST("/resendverification", app.ResendVerification)
+
+			//two-factor authentication apis
+			engine.GET("/2fa", app.GetTwoFactor)
+			engine.POST("/2fa/enroll", app.EnrollTwoFactor)
+			engine.POST("/2fa/confirm", app.ConfirmTwoFactor)
+			engine.POST("/2fa/recoverycodes", app.RegenerateRecoveryCodes)
+			engine.DELETE("/2fa", app.DisableTwoFactor)
 
 			//recommenders apis
 			engine.GET("/recommendbooksbyrecord", app.RecommendByRecord)
@@ -112,7 +503,7 @@ func main() {
 
 			//borrow book apis
 			engine.GET("/libstatus/:bookid", app.GetLibStatus)
-			engine.POST("/borrowbook/:bookid", app.BorrowBook)
+			engine.POST("/borrowbook/:bookid", app.RequireVerifiedEmail(), app.BorrowBook)
 			engine.GET("/borrowhistory", app.BorrowHistory)
 
 			//buy books
@@ -120,21 +511,68 @@ func main() {
 			engine.DELETE("/deletefromcart/:bookid", app.DeleteFromCart)
 			engine.GET("/incart/:bookid", app.IsInCart)
 			engine.GET("/activeinvoice", app.GetActiveInvoice)
-			engine.POST("/finalizeinvoice", app.FinalizeInvoice)
+			engine.POST("/finalizeinvoice", app.RequireVerifiedEmail(), app.FinalizeInvoice)
 			engine.GET("/showinvoice/:invoice", app.ShowInvoice)
 			engine.GET("/invoicehistory", app.InvoiceHistory)
 
-			//logout api
+			//logout and session apis
 			engine.POST("/logout", app.Logout)
+			engine.GET("/sessions", app.ListSessions)
+			engine.DELETE("/sessions", app.RevokeSessions)
+			engine.DELETE("/sessions/:id", app.RevokeSession)
 
 			//administrative apis
 			engine.GET("/isadmin", app.IsAdmin)
-			engine.GET("/borrowedbooks", app.ShowActiveBorrows)
-			engine.POST("/returnbook/:bookid", app.ReturnBook)
-			engine.GET("/customerinvoices", app.CustomerInvoiceHistory)
+			staff := app.RequireScope(models.ScopeAdmin)
+			borrows := engine.Group("/", staff, app.RequirePermission(models.PermManageBorrows))
+			{
+				borrows.GET("/borrowedbooks", app.ShowActiveBorrows)
+				borrows.POST("/returnbook/:bookid", app.ReturnBook)
+			}
+			invoices := engine.Group("/", staff, app.RequirePermission(models.PermViewInvoices))
+			{
+				invoices.GET("/customerinvoices", app.CustomerInvoiceHistory)
+			}
 			//book changes apis
-			engine.POST("/addbook", app.AddBook)
-			engine.PUT("/editbook", app.EditBook)
+			books := engine.Group("/", staff, app.RequirePermission(models.PermManageCatalog))
+			{
+				books.POST("/addbook", app.AddBook)
+				books.PUT("/editbook", app.EditBook)
+				books.POST("/books/:id/cover", app.UploadCover)
+				books.PUT("/authors/:id", app.EditAuthor)
+				books.POST("/authors/:id/merge", app.MergeAuthors)
+				books.POST("/series", app.AddSeries)
+				books.PUT("/series/:id/books", app.SetSeriesBooks)
+				books.DELETE("/series/:id/books/:bookid", app.RemoveFromSeries)
+				books.POST("/editions", app.GroupEditions)
+				books.POST("/import", app.ImportBooks)
+				books.GET("/export", app.ExportBooks)
+				books.DELETE("/editions/:bookid", app.UngroupEdition)
+			}
+			//user management apis
+			admin := engine.Group("/admin", staff, app.RequirePermission(models.PermManageUsers))
+			{
+				admin.GET("/users", app.ListUsers)
+				admin.GET("/users/:id", app.GetUserActivity)
+				admin.POST("/users/:id/suspend", app.SuspendUser)
+				admin.POST("/users/:id/ban", app.BanUser)
+				admin.POST("/users/:id/reinstate", app.ReinstateUser)
+				admin.POST("/users/:id/resetpassword", app.ForcePasswordReset)
+				admin.PUT("/users/:id/role", app.SetUserRole)
+				admin.GET("/roles", app.GetRoles)
+			}
+			audit := engine.Group("/admin/audit", staff, app.RequirePermission(models.PermViewAudit))
+			{
+				audit.GET("", app.ListAudit)
+				audit.GET("/export", app.ExportAudit)
+			}
+			//api client apis
+			clients := engine.Group("/admin/apiclients", staff, app.RequirePermission(models.PermManageClients))
+			{
+				clients.GET("", app.ListApiClients)
+				clients.POST("", app.IssueApiClient)
+				clients.DELETE("/:id", app.RevokeApiClient)
+			}
 		}
 	}
 	port := os.Getenv("PORT")
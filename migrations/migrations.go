@@ -0,0 +1,235 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// Load reads the embedded migrations, ordered by version. Files are named
+// <version>_<name>.up.sql and <version>_<name>.down.sql.
+func Load() ([]Migration, error) {
+	entries, err := files.ReadDir("sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		fname := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(fname, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(fname, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+		base := strings.TrimSuffix(fname, "."+direction+".sql")
+		parts := strings.SplitN(base, "_", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad migration file name %q", fname)
+		}
+		version, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("bad migration version in %q: %w", fname, err)
+		}
+		body, err := files.ReadFile("sql/" + fname)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+	migrations := []Migration{}
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up script", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func ensureTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`)
+	return err
+}
+
+func applied(db *sql.DB) (map[int]time.Time, error) {
+	if err := ensureTable(db); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT version, applied_at FROM schema_version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	versions := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		versions[version] = at
+	}
+	return versions, rows.Err()
+}
+
+func run(db *sql.DB, script string, record func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(script); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// lockKey names the advisory lock migrations hold, any number will do as
+// long as nothing else takes it.
+const lockKey = 0x426f6f6b53746f72
+
+// lock keeps other instances starting at the same time from running the
+// same migrations. The lock belongs to one connection of the pool, which is
+// held until release.
+func lock(db *sql.DB) (func(), error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return func() {
+		conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", lockKey)
+		conn.Close()
+	}, nil
+}
+
+// Up applies every migration that is not recorded in schema_version yet.
+func Up(db *sql.DB) error {
+	migrations, err := Load()
+	if err != nil {
+		return err
+	}
+	release, err := lock(db)
+	if err != nil {
+		return err
+	}
+	defer release()
+	done, err := applied(db)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if _, ok := done[m.Version]; ok {
+			continue
+		}
+		err := run(db, m.Up, func(tx *sql.Tx) error {
+			_, err := tx.Exec("INSERT INTO schema_version(version, name) VALUES($1, $2)", m.Version, m.Name)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// Down rolls back the latest steps applied migrations.
+func Down(db *sql.DB, steps int) error {
+	migrations, err := Load()
+	if err != nil {
+		return err
+	}
+	release, err := lock(db)
+	if err != nil {
+		return err
+	}
+	defer release()
+	done, err := applied(db)
+	if err != nil {
+		return err
+	}
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		if _, ok := done[m.Version]; !ok {
+			continue
+		}
+		if m.Down == "" {
+			return fmt.Errorf("migration %d (%s) has no down script", m.Version, m.Name)
+		}
+		err := run(db, m.Down, func(tx *sql.Tx) error {
+			_, err := tx.Exec("DELETE FROM schema_version WHERE version=$1", m.Version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("rollback of %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		steps--
+	}
+	return nil
+}
+
+func List(db *sql.DB) ([]Status, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	done, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+	statuses := []Status{}
+	for _, m := range migrations {
+		status := Status{Version: m.Version, Name: m.Name}
+		if at, ok := done[m.Version]; ok {
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
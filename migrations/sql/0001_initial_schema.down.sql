@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS invoice_book;
+DROP TABLE IF EXISTS invoice;
+DROP TABLE IF EXISTS user_read;
+DROP TABLE IF EXISTS borrow_book;
+DROP TABLE IF EXISTS comment;
+DROP TABLE IF EXISTS user_rating;
+DROP TABLE IF EXISTS user_fave;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS newbook;
+DROP TABLE IF EXISTS book_author;
+DROP TABLE IF EXISTS authors;
+DROP TABLE IF EXISTS book_genre;
+DROP TABLE IF EXISTS book;
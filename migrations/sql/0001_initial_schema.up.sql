@@ -0,0 +1,103 @@
+CREATE TABLE IF NOT EXISTS book (
+    book_id          INTEGER PRIMARY KEY,
+    title            TEXT NOT NULL,
+    isbn             TEXT NOT NULL DEFAULT '',
+    image_url        TEXT NOT NULL DEFAULT '',
+    publication_date TIMESTAMP NOT NULL,
+    isbn13           TEXT NOT NULL DEFAULT '',
+    num_pages        INTEGER NOT NULL DEFAULT 0,
+    publisher        TEXT NOT NULL DEFAULT '',
+    book_format      TEXT NOT NULL DEFAULT '',
+    description      TEXT NOT NULL DEFAULT '',
+    price            INTEGER NOT NULL DEFAULT 0,
+    quantity_sale    INTEGER NOT NULL DEFAULT 0,
+    quantity_lib     INTEGER NOT NULL DEFAULT 0,
+    avg_rate         DOUBLE PRECISION NOT NULL DEFAULT 0,
+    rate_count       INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS book_genre (
+    book_id INTEGER NOT NULL REFERENCES book(book_id) ON DELETE CASCADE,
+    genre   TEXT NOT NULL,
+    PRIMARY KEY (book_id, genre)
+);
+
+CREATE TABLE IF NOT EXISTS authors (
+    author_id INTEGER PRIMARY KEY,
+    name      TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS book_author (
+    book_id   INTEGER NOT NULL REFERENCES book(book_id) ON DELETE CASCADE,
+    author_id INTEGER NOT NULL REFERENCES authors(author_id),
+    role      TEXT
+);
+
+CREATE TABLE IF NOT EXISTS newbook (
+    book_id    INTEGER PRIMARY KEY REFERENCES book(book_id) ON DELETE CASCADE,
+    time_added TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS users (
+    user_id   INTEGER PRIMARY KEY,
+    firstname TEXT NOT NULL DEFAULT '',
+    lastname  TEXT NOT NULL DEFAULT '',
+    password  TEXT NOT NULL,
+    email     TEXT NOT NULL UNIQUE,
+    image     TEXT NOT NULL DEFAULT 'tempo',
+    role      BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS user_fave (
+    book_id INTEGER NOT NULL REFERENCES book(book_id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    PRIMARY KEY (book_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_rating (
+    user_id    INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    book_id    INTEGER NOT NULL REFERENCES book(book_id) ON DELETE CASCADE,
+    rating     INTEGER NOT NULL,
+    review     TEXT NOT NULL DEFAULT '',
+    date_added TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, book_id)
+);
+
+CREATE TABLE IF NOT EXISTS comment (
+    comment_id SERIAL PRIMARY KEY,
+    book_id    INTEGER NOT NULL REFERENCES book(book_id) ON DELETE CASCADE,
+    user_id    INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    review     TEXT NOT NULL,
+    date_added TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS borrow_book (
+    borrow_id   SERIAL PRIMARY KEY,
+    book_id     INTEGER NOT NULL REFERENCES book(book_id),
+    user_id     INTEGER NOT NULL REFERENCES users(user_id),
+    returned    VARCHAR(3) NOT NULL DEFAULT 'no',
+    borrow_time TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS user_read (
+    userid  INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    book_id INTEGER NOT NULL REFERENCES book(book_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS invoice (
+    invoice_id    INTEGER PRIMARY KEY,
+    user_id       INTEGER NOT NULL REFERENCES users(user_id),
+    status        VARCHAR(5) NOT NULL DEFAULT 'open',
+    purchase_date TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS invoice_book (
+    invoice_id INTEGER NOT NULL REFERENCES invoice(invoice_id) ON DELETE CASCADE,
+    book_id    INTEGER NOT NULL REFERENCES book(book_id)
+);
+
+CREATE INDEX IF NOT EXISTS book_author_book_idx ON book_author(book_id);
+CREATE INDEX IF NOT EXISTS borrow_book_open_idx ON borrow_book(book_id) WHERE returned = 'no';
+CREATE INDEX IF NOT EXISTS user_read_user_idx ON user_read(userid);
+CREATE INDEX IF NOT EXISTS invoice_user_status_idx ON invoice(user_id, status);
+CREATE INDEX IF NOT EXISTS invoice_book_invoice_idx ON invoice_book(invoice_id);
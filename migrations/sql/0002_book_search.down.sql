@@ -0,0 +1,10 @@
+DROP TRIGGER IF EXISTS author_search_update ON authors;
+DROP TRIGGER IF EXISTS book_author_search_update ON book_author;
+DROP TRIGGER IF EXISTS book_search_update ON book;
+DROP FUNCTION IF EXISTS author_search_trigger();
+DROP FUNCTION IF EXISTS book_author_search_trigger();
+DROP FUNCTION IF EXISTS book_search_trigger();
+DROP INDEX IF EXISTS book_search_idx;
+DROP FUNCTION IF EXISTS book_search_vector(book);
+ALTER TABLE book DROP COLUMN IF EXISTS search_vector;
+DROP FUNCTION IF EXISTS normalize_fa(TEXT);
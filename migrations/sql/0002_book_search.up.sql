@@ -0,0 +1,67 @@
+-- Folds Arabic code points onto their Persian forms, Arabic-Indic and
+-- Persian digits onto ASCII, and drops diacritics, tatweel and zero-width
+-- joiners so that spelling variants index to the same lexemes. Must stay
+-- in sync with functions.NormalizePersian.
+CREATE OR REPLACE FUNCTION normalize_fa(input TEXT) RETURNS TEXT AS $$
+    SELECT lower(regexp_replace(
+        translate(COALESCE(input, ''),
+            'يىكةۀأإٱؤ٠١٢٣٤٥٦٧٨٩۰۱۲۳۴۵۶۷۸۹',
+            'ییکههاااو01234567890123456789'),
+        '[\u064B-\u065F\u0670\u0640\u200C\u200D]', '', 'g'))
+$$ LANGUAGE sql IMMUTABLE;
+
+ALTER TABLE book ADD COLUMN IF NOT EXISTS search_vector TSVECTOR;
+
+CREATE OR REPLACE FUNCTION book_search_vector(b book) RETURNS TSVECTOR AS $$
+    SELECT setweight(to_tsvector('simple', normalize_fa(b.title)), 'A') ||
+           setweight(to_tsvector('simple', replace(b.isbn, '-', '') || ' ' || replace(b.isbn13, '-', '')), 'A') ||
+           setweight(to_tsvector('simple', normalize_fa((
+               SELECT string_agg(authors.name, ' ')
+               FROM book_author INNER JOIN authors ON authors.author_id = book_author.author_id
+               WHERE book_author.book_id = b.book_id))), 'B') ||
+           setweight(to_tsvector('simple', normalize_fa(b.publisher)), 'C') ||
+           setweight(to_tsvector('simple', normalize_fa(b.description)), 'D')
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION book_search_trigger() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.search_vector := book_search_vector(NEW);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER book_search_update
+    BEFORE INSERT OR UPDATE OF title, isbn, isbn13, publisher, description ON book
+    FOR EACH ROW EXECUTE FUNCTION book_search_trigger();
+
+CREATE OR REPLACE FUNCTION book_author_search_trigger() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        UPDATE book SET search_vector = book_search_vector(book) WHERE book_id = OLD.book_id;
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        UPDATE book SET search_vector = book_search_vector(book) WHERE book_id = NEW.book_id;
+    END IF;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER book_author_search_update
+    AFTER INSERT OR UPDATE OR DELETE ON book_author
+    FOR EACH ROW EXECUTE FUNCTION book_author_search_trigger();
+
+CREATE OR REPLACE FUNCTION author_search_trigger() RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE book SET search_vector = book_search_vector(book)
+    WHERE book_id IN (SELECT book_id FROM book_author WHERE author_id = NEW.author_id);
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER author_search_update
+    AFTER UPDATE OF name ON authors
+    FOR EACH ROW EXECUTE FUNCTION author_search_trigger();
+
+UPDATE book SET search_vector = book_search_vector(book);
+
+CREATE INDEX IF NOT EXISTS book_search_idx ON book USING GIN(search_vector);
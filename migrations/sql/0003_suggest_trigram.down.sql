@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS book_genre_trgm_idx;
+DROP INDEX IF EXISTS authors_name_trgm_idx;
+DROP INDEX IF EXISTS book_publisher_trgm_idx;
+DROP INDEX IF EXISTS book_title_trgm_idx;
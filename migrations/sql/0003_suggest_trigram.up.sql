@@ -0,0 +1,6 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS book_title_trgm_idx ON book USING GIN (normalize_fa(title) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS book_publisher_trgm_idx ON book USING GIN (normalize_fa(publisher) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS authors_name_trgm_idx ON authors USING GIN (normalize_fa(name) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS book_genre_trgm_idx ON book_genre USING GIN (normalize_fa(genre) gin_trgm_ops);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS book_price_idx;
+DROP INDEX IF EXISTS book_language_idx;
+DROP INDEX IF EXISTS book_format_idx;
+ALTER TABLE book DROP COLUMN IF EXISTS language;
@@ -0,0 +1,5 @@
+ALTER TABLE book ADD COLUMN IF NOT EXISTS language TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS book_format_idx ON book(book_format);
+CREATE INDEX IF NOT EXISTS book_language_idx ON book(language);
+CREATE INDEX IF NOT EXISTS book_price_idx ON book(price);
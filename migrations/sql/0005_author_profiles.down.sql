@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS book_author_author_idx;
+DROP INDEX IF EXISTS authors_normalized_name_idx;
+ALTER TABLE authors DROP COLUMN IF EXISTS photo_url;
+ALTER TABLE authors DROP COLUMN IF EXISTS bio;
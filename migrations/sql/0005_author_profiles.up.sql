@@ -0,0 +1,5 @@
+ALTER TABLE authors ADD COLUMN IF NOT EXISTS bio TEXT NOT NULL DEFAULT '';
+ALTER TABLE authors ADD COLUMN IF NOT EXISTS photo_url TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS authors_normalized_name_idx ON authors (normalize_fa(btrim(name)));
+CREATE INDEX IF NOT EXISTS book_author_author_idx ON book_author(author_id);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS book_series_idx;
+DROP INDEX IF EXISTS book_work_idx;
+ALTER TABLE book DROP COLUMN IF EXISTS series_volume;
+ALTER TABLE book DROP COLUMN IF EXISTS series_id;
+ALTER TABLE book DROP COLUMN IF EXISTS work_id;
+DROP TABLE IF EXISTS series;
+DROP TABLE IF EXISTS work;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS work (
+    work_id SERIAL PRIMARY KEY,
+    title   TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS series (
+    series_id   SERIAL PRIMARY KEY,
+    name        TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+ALTER TABLE book ADD COLUMN IF NOT EXISTS work_id INTEGER REFERENCES work(work_id) ON DELETE SET NULL;
+ALTER TABLE book ADD COLUMN IF NOT EXISTS series_id INTEGER REFERENCES series(series_id) ON DELETE SET NULL;
+ALTER TABLE book ADD COLUMN IF NOT EXISTS series_volume INTEGER;
+
+CREATE INDEX IF NOT EXISTS book_work_idx ON book(work_id) WHERE work_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS book_series_idx ON book(series_id, series_volume) WHERE series_id IS NOT NULL;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS book_isbn13_idx;
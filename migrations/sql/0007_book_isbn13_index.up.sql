@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS book_isbn13_idx ON book (replace(isbn13, '-', ''));
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS book_updated_idx;
+DROP TRIGGER IF EXISTS author_touch ON authors;
+DROP FUNCTION IF EXISTS author_touch_trigger();
+DROP TRIGGER IF EXISTS book_author_touch ON book_author;
+DROP TRIGGER IF EXISTS book_genre_touch ON book_genre;
+DROP FUNCTION IF EXISTS book_relation_touch_trigger();
+DROP TRIGGER IF EXISTS book_touch ON book;
+DROP FUNCTION IF EXISTS book_touch_trigger();
+ALTER TABLE book DROP COLUMN IF EXISTS updated_at;
@@ -0,0 +1,48 @@
+-- Datestamp for metadata harvesting. It moves when the catalog data of a
+-- book changes, but not for stock or rating updates.
+ALTER TABLE book ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP NOT NULL DEFAULT NOW();
+
+CREATE OR REPLACE FUNCTION book_touch_trigger() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.updated_at := NOW();
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER book_touch
+    BEFORE UPDATE OF title, isbn, image_url, publication_date, isbn13, num_pages, publisher, book_format, language, description, price, series_id, series_volume ON book
+    FOR EACH ROW EXECUTE FUNCTION book_touch_trigger();
+
+CREATE OR REPLACE FUNCTION book_relation_touch_trigger() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        UPDATE book SET updated_at = NOW() WHERE book_id = OLD.book_id;
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        UPDATE book SET updated_at = NOW() WHERE book_id = NEW.book_id;
+    END IF;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER book_genre_touch
+    AFTER INSERT OR UPDATE OR DELETE ON book_genre
+    FOR EACH ROW EXECUTE FUNCTION book_relation_touch_trigger();
+
+CREATE TRIGGER book_author_touch
+    AFTER INSERT OR UPDATE OR DELETE ON book_author
+    FOR EACH ROW EXECUTE FUNCTION book_relation_touch_trigger();
+
+CREATE OR REPLACE FUNCTION author_touch_trigger() RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE book SET updated_at = NOW()
+    WHERE book_id IN (SELECT book_id FROM book_author WHERE author_id = NEW.author_id);
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER author_touch
+    AFTER UPDATE OF name ON authors
+    FOR EACH ROW EXECUTE FUNCTION author_touch_trigger();
+
+CREATE INDEX IF NOT EXISTS book_updated_idx ON book(updated_at, book_id);
@@ -0,0 +1,2 @@
+UPDATE users SET image = 'tempo' WHERE image = '';
+ALTER TABLE users ALTER COLUMN image SET DEFAULT 'tempo';
@@ -0,0 +1,4 @@
+-- Users without a profile picture have an empty image instead of the
+-- "tempo" placeholder.
+ALTER TABLE users ALTER COLUMN image SET DEFAULT '';
+UPDATE users SET image = '' WHERE image = 'tempo';
@@ -0,0 +1,4 @@
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+ALTER TABLE users ALTER COLUMN role DROP DEFAULT;
+ALTER TABLE users ALTER COLUMN role TYPE BOOLEAN USING role = 'admin';
+ALTER TABLE users ALTER COLUMN role SET DEFAULT FALSE;
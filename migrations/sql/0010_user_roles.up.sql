@@ -0,0 +1,6 @@
+-- The admin flag becomes a role: librarian, store_manager or admin for
+-- staff and customer for everyone else.
+ALTER TABLE users ALTER COLUMN role DROP DEFAULT;
+ALTER TABLE users ALTER COLUMN role TYPE TEXT USING CASE WHEN role THEN 'admin' ELSE 'customer' END;
+ALTER TABLE users ALTER COLUMN role SET DEFAULT 'customer';
+ALTER TABLE users ADD CONSTRAINT users_role_check CHECK (role IN ('customer', 'librarian', 'store_manager', 'admin'));
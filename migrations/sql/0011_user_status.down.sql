@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS users_status_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS must_reset_password;
+ALTER TABLE users DROP COLUMN IF EXISTS suspended_until;
+ALTER TABLE users DROP COLUMN IF EXISTS status_reason;
+ALTER TABLE users DROP COLUMN IF EXISTS status;
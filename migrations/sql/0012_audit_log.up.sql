@@ -0,0 +1,31 @@
+-- Who changed what, from where. Rows are only ever added; the triggers
+-- stop anyone from rewriting history through the application's account.
+CREATE TABLE IF NOT EXISTS audit_log (
+    audit_id    BIGSERIAL PRIMARY KEY,
+    actor_id    INTEGER NOT NULL DEFAULT 0,
+    action      TEXT NOT NULL,
+    target_type TEXT NOT NULL DEFAULT '',
+    target_id   TEXT NOT NULL DEFAULT '',
+    diff        JSONB NOT NULL DEFAULT '{}',
+    ip          TEXT NOT NULL DEFAULT '',
+    created_at  TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log(actor_id, audit_id);
+CREATE INDEX IF NOT EXISTS audit_log_action_idx ON audit_log(action, audit_id);
+CREATE INDEX IF NOT EXISTS audit_log_target_idx ON audit_log(target_type, target_id, audit_id);
+CREATE INDEX IF NOT EXISTS audit_log_created_idx ON audit_log(created_at);
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_no_change
+    BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+CREATE TRIGGER audit_log_no_truncate
+    BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
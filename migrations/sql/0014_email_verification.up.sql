@@ -0,0 +1,4 @@
+-- New accounts have to prove they own their address before borrowing or
+-- buying. Accounts made before this are taken as verified.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE users ALTER COLUMN email_verified SET DEFAULT FALSE;
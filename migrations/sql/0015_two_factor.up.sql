@@ -0,0 +1,16 @@
+-- Authenticator app secrets and the one-off codes for when the phone is
+-- lost. Only hashes of the recovery codes are kept.
+CREATE TABLE IF NOT EXISTS two_factor (
+    user_id    INTEGER PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+    secret     TEXT NOT NULL,
+    enabled    BOOLEAN NOT NULL DEFAULT FALSE,
+    last_step  BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    user_id   INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at   TIMESTAMP,
+    PRIMARY KEY (user_id, code_hash)
+);
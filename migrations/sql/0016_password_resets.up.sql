@@ -0,0 +1,11 @@
+-- Reset links outlive restarts and work on every instance. Only hashes of
+-- the tokens are kept, and each can be used once.
+CREATE TABLE IF NOT EXISTS password_resets (
+    token_hash TEXT PRIMARY KEY,
+    user_id    INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    used_at    TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS password_resets_user_idx ON password_resets(user_id) WHERE used_at IS NULL;
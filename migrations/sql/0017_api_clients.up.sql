@@ -0,0 +1,14 @@
+-- Every app or partner calling the api gets its own key, so each can be
+-- told apart, limited and revoked on its own. Only hashes of the keys are
+-- kept; the prefix helps people recognise one.
+CREATE TABLE IF NOT EXISTS api_clients (
+    client_id    SERIAL PRIMARY KEY,
+    name         TEXT NOT NULL,
+    key_prefix   TEXT NOT NULL,
+    key_hash     TEXT NOT NULL UNIQUE,
+    scopes       TEXT[] NOT NULL DEFAULT '{}',
+    rate_limit   INTEGER NOT NULL,
+    created_at   TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMP,
+    revoked_at   TIMESTAMP
+);
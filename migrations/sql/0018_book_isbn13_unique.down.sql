@@ -0,0 +1,3 @@
+-- The merged duplicates aren't brought back.
+DROP INDEX IF EXISTS book_isbn13_key;
+CREATE INDEX IF NOT EXISTS book_isbn13_idx ON book (replace(isbn13, '-', ''));
@@ -0,0 +1,40 @@
+-- Books sharing an ISBN13 are the same edition entered twice. The one with
+-- the lowest book_id is kept: whatever pointed at the others moves to it and
+-- their stock is added to its own before they are deleted.
+CREATE TEMP TABLE book_duplicate ON COMMIT DROP AS
+SELECT book_id, MIN(book_id) OVER (PARTITION BY replace(isbn13, '-', '')) AS keep_id
+FROM book WHERE isbn13 <> '';
+DELETE FROM book_duplicate WHERE book_id = keep_id;
+
+UPDATE borrow_book SET book_id = d.keep_id FROM book_duplicate d WHERE borrow_book.book_id = d.book_id;
+UPDATE invoice_book SET book_id = d.keep_id FROM book_duplicate d WHERE invoice_book.book_id = d.book_id;
+UPDATE comment SET book_id = d.keep_id FROM book_duplicate d WHERE comment.book_id = d.book_id;
+UPDATE user_read SET book_id = d.keep_id FROM book_duplicate d WHERE user_read.book_id = d.book_id;
+
+INSERT INTO user_fave(book_id, user_id)
+SELECT d.keep_id, f.user_id FROM user_fave f INNER JOIN book_duplicate d ON d.book_id = f.book_id
+ON CONFLICT DO NOTHING;
+INSERT INTO user_rating(user_id, book_id, rating, review, date_added)
+SELECT r.user_id, d.keep_id, r.rating, r.review, r.date_added FROM user_rating r INNER JOIN book_duplicate d ON d.book_id = r.book_id
+ON CONFLICT DO NOTHING;
+INSERT INTO book_genre(book_id, genre)
+SELECT d.keep_id, g.genre FROM book_genre g INNER JOIN book_duplicate d ON d.book_id = g.book_id
+ON CONFLICT DO NOTHING;
+INSERT INTO book_author(book_id, author_id, role)
+SELECT DISTINCT d.keep_id, a.author_id, a.role FROM book_author a INNER JOIN book_duplicate d ON d.book_id = a.book_id
+WHERE NOT EXISTS (SELECT 1 FROM book_author kept WHERE kept.book_id = d.keep_id AND kept.author_id = a.author_id AND kept.role IS NOT DISTINCT FROM a.role);
+
+UPDATE book SET quantity_sale = book.quantity_sale + merged.sale, quantity_lib = book.quantity_lib + merged.lib
+FROM (SELECT d.keep_id, SUM(b.quantity_sale) AS sale, SUM(b.quantity_lib) AS lib
+      FROM book b INNER JOIN book_duplicate d ON d.book_id = b.book_id GROUP BY d.keep_id) merged
+WHERE book.book_id = merged.keep_id;
+UPDATE book SET avg_rate = rated.average, rate_count = rated.count
+FROM (SELECT book_id, AVG(rating) AS average, COUNT(*) AS count FROM user_rating
+      WHERE book_id IN (SELECT keep_id FROM book_duplicate) GROUP BY book_id) rated
+WHERE book.book_id = rated.book_id;
+
+DELETE FROM book WHERE book_id IN (SELECT book_id FROM book_duplicate);
+
+-- books without an ISBN13 are left alone
+DROP INDEX IF EXISTS book_isbn13_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS book_isbn13_key ON book (replace(isbn13, '-', '')) WHERE isbn13 <> '';
@@ -0,0 +1,3 @@
+-- Pictures are only shown to other users once their owner makes them
+-- public.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_public BOOLEAN NOT NULL DEFAULT FALSE;
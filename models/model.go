@@ -7,14 +7,78 @@ import (
 	"github.com/dgrijalva/jwt-go"
 )
 
+// Tokens are signed with the same keys whatever they are for, so each
+// names its kind and is only accepted where that kind is expected.
+const (
+	TokenAccess    = "access"
+	TokenChallenge = "2fa-challenge"
+)
+
 type Claims struct {
-	Uid int `json:"user"`
+	Uid  int    `json:"user"`
+	Sid  int    `json:"sid"`
+	Type string `json:"typ"`
+	jwt.StandardClaims
+}
+
+// ChallengeClaims is handed out instead of a session when the password was
+// right but a second factor is still needed.
+type ChallengeClaims struct {
+	Uid  int    `json:"challenge"`
+	Type string `json:"typ"`
 	jwt.StandardClaims
 }
 
+type ChallengeOutput struct {
+	TwoFactorRequired bool      `json:"two_factor_required"`
+	ChallengeToken    string    `json:"challenge_token"`
+	Expires           time.Time `json:"expires"`
+}
+
 type JWTOutput struct {
-	Token   string    `json:"token"`
-	Expires time.Time `json:"expires"`
+	Token          string    `json:"token"`
+	Expires        time.Time `json:"expires"`
+	RefreshToken   string    `json:"refresh_token"`
+	RefreshExpires time.Time `json:"refresh_expires"`
+}
+
+// TwoFactor is a user's authenticator app. It only counts once Enabled, after
+// the user proved they set it up by entering a code.
+type TwoFactor struct {
+	Uid           int    `json:"-"`
+	Secret        string `json:"-"`
+	Enabled       bool   `json:"enabled"`
+	LastStep      int64  `json:"-"`
+	RecoveryCodes int    `json:"recovery_codes"`
+}
+
+// Session is one signed in device. Its refresh token is swapped for a new
+// one every time it's used.
+type Session struct {
+	Id         int        `json:"id"`
+	Uid        int        `json:"-"`
+	UserAgent  string     `json:"user_agent"`
+	Ip         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"-"`
+	Current    bool       `json:"current"`
+}
+
+// ApiClient is an app or partner calling the api with a key of its own.
+// Only a hash of the key is stored; Prefix, its first characters, tells
+// keys apart in listings. RateLimit is in requests per minute.
+type ApiClient struct {
+	Id         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Hash       string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	RateLimit  int        `json:"rate_limit"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
 }
 
 type UserComment struct {
@@ -30,10 +94,24 @@ type Rate struct {
 }
 
 type AuthorR struct {
+	Id     int    `json:"author_id,omitempty"`
 	Author string `json:"author"`
 	Role   string `json:"role"`
 }
 
+type Author struct {
+	Id       int                  `json:"id"`
+	Name     string               `json:"name"`
+	Bio      string               `json:"bio"`
+	PhotoUrl string               `json:"photo_url"`
+	Books    map[string][]LowBook `json:"books,omitempty"`
+}
+
+type AuthorPage struct {
+	Authors    []Author `json:"authors"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
 type LowBook struct {
 	Title    string  `json:"title"`
 	Id       int     `json:"id"`
@@ -43,6 +121,26 @@ type LowBook struct {
 	Count    int     `json:"rates_count"`
 }
 
+type Page struct {
+	Limit  int    `json:"limit" form:"limit"`
+	Cursor string `json:"cursor" form:"cursor"`
+	Sort   string `json:"sort" form:"sort"`
+	Seed   int64  `json:"seed" form:"seed"`
+}
+
+type BookPage struct {
+	Books      []LowBook `json:"books"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+	Seed       int64     `json:"seed,omitempty"`
+	Facets     *Facets   `json:"facets,omitempty"`
+}
+
+type Suggestion struct {
+	Type string `json:"type"`
+	Id   int    `json:"id,omitempty"`
+	Text string `json:"text"`
+}
+
 type UserLogin struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
@@ -55,36 +153,206 @@ type Filter struct {
 	Search    string   `json:"search"`
 	MinPages  int      `json:"min_pages"`
 	MaxPages  int      `json:"max_pages"`
+	MinPrice  int      `json:"min_price"`
+	MaxPrice  int      `json:"max_price"`
+	Formats   []string `json:"formats"`
+	Authors   []string `json:"authors"`
+	Languages []string `json:"languages"`
+	MinRating float64  `json:"min_rating"`
+	// CollapseEditions returns one book per work instead of every edition
+	CollapseEditions bool `json:"collapse_editions"`
+	Page
+}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type RangeCount struct {
+	Min   int `json:"min"`
+	Max   int `json:"max,omitempty"`
+	Count int `json:"count"`
+}
+
+type Facets struct {
+	Genres     []FacetCount `json:"genres"`
+	Formats    []FacetCount `json:"formats"`
+	Publishers []FacetCount `json:"publishers"`
+	Pages      []RangeCount `json:"pages"`
+	Prices     []RangeCount `json:"prices"`
 }
 
 type User struct {
-	Id        int    `json:"user_id"`
-	Firstname string `json:"firstname"`
-	Lastname  string `json:"lastname"`
-	Image     string `json:"image"`
-	Email     string `json:"email"`
-	Password  string `json:"password"`
-	Role      bool   `json:"role"`
+	Id                int        `json:"user_id"`
+	Firstname         string     `json:"firstname"`
+	Lastname          string     `json:"lastname"`
+	Image             string     `json:"image"`
+	AvatarPublic      bool       `json:"avatar_public"`
+	Email             string     `json:"email"`
+	Password          string     `json:"password,omitempty"`
+	Role              string     `json:"role"`
+	Status            string     `json:"status,omitempty"`
+	StatusReason      string     `json:"status_reason,omitempty"`
+	SuspendedUntil    *time.Time `json:"suspended_until,omitempty"`
+	MustResetPassword bool       `json:"must_reset_password,omitempty"`
+	EmailVerified     bool       `json:"email_verified"`
+}
+
+const (
+	UserActive    = "active"
+	UserSuspended = "suspended"
+	UserBanned    = "banned"
+)
+
+// Blocked tells if the user may not sign in or use their tokens. A
+// suspension without an end lasts until an admin lifts it.
+func (u User) Blocked(now time.Time) bool {
+	switch u.Status {
+	case UserBanned:
+		return true
+	case UserSuspended:
+		return u.SuspendedUntil == nil || now.Before(*u.SuspendedUntil)
+	}
+	return false
+}
+
+type UserFilter struct {
+	Search string `form:"search"`
+	Role   string `form:"role"`
+	Status string `form:"status"`
+	Page
+}
+
+type UserPage struct {
+	Users      []User `json:"users"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type UserActivity struct {
+	User     User      `json:"user"`
+	Borrows  []LowBook `json:"borrows"`
+	Invoices []Invoice `json:"invoices"`
+	Ratings  []Rate    `json:"ratings"`
 }
 
 type Book struct {
-	Title           string    `json:"title"`
-	Id              int       `json:"id"`
-	Isbn            string    `json:"isbn"`
-	ImageUrl        string    `json:"imageurl"`
-	PublicationDate time.Time `json:"publicationdate"`
-	Isbn13          string    `json:"isbn13"`
-	NumberOfPages   int       `json:"numberofpages"`
-	Publisher       string    `json:"publisher"`
-	Format          string    `json:"format"`
-	Description     string    `json:"description"`
-	QuantityForSale int       `json:"qs"`
-	QuantityInLib   int       `json:"ql"`
-	Price           int       `json:"price"`
-	Genres          []string  `json:"genres"`
-	Authors         []AuthorR `json:"authors"`
-	AverageRate     float64   `json:"average_rating"`
-	RateCount       int       `json:"rate_count"`
+	Title           string           `json:"title"`
+	Id              int              `json:"id"`
+	Isbn            string           `json:"isbn"`
+	ImageUrl        string           `json:"imageurl"`
+	PublicationDate time.Time        `json:"publicationdate"`
+	Isbn13          string           `json:"isbn13"`
+	NumberOfPages   int              `json:"numberofpages"`
+	Publisher       string           `json:"publisher"`
+	Format          string           `json:"format"`
+	Language        string           `json:"language"`
+	Description     string           `json:"description"`
+	QuantityForSale int              `json:"qs"`
+	QuantityInLib   int              `json:"ql"`
+	Price           int              `json:"price"`
+	Genres          []string         `json:"genres"`
+	Authors         []AuthorR        `json:"authors"`
+	AverageRate     float64          `json:"average_rating"`
+	RateCount       int              `json:"rate_count"`
+	WorkId          int              `json:"work_id,omitempty"`
+	SeriesId        int              `json:"series_id,omitempty"`
+	Series          string           `json:"series,omitempty"`
+	SeriesVolume    int              `json:"series_volume,omitempty"`
+	Editions        []Edition        `json:"editions,omitempty"`
+	NextInSeries    *LowBook         `json:"next_in_series,omitempty"`
+	Covers          map[string]Cover `json:"covers,omitempty"`
+	UpdatedAt       time.Time        `json:"updated_at"`
+}
+
+type Edition struct {
+	Id       int    `json:"id"`
+	Title    string `json:"title"`
+	Format   string `json:"format"`
+	Isbn13   string `json:"isbn13"`
+	Price    int    `json:"price"`
+	ImageUrl string `json:"image_url"`
+}
+
+type Cover struct {
+	Jpeg string `json:"jpeg"`
+	Webp string `json:"webp"`
+}
+
+type SeriesVolume struct {
+	BookId int `json:"book_id"`
+	Volume int `json:"volume"`
+}
+
+type SeriesBook struct {
+	Volume int `json:"volume"`
+	LowBook
+}
+
+const (
+	ImportCreated  = "created"
+	ImportUpdated  = "updated"
+	ImportRejected = "rejected"
+)
+
+type ImportRow struct {
+	Row    int    `json:"row"`
+	Isbn13 string `json:"isbn13,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ImportReport struct {
+	DryRun   bool        `json:"dry_run"`
+	Created  int         `json:"created"`
+	Updated  int         `json:"updated"`
+	Rejected int         `json:"rejected"`
+	Rows     []ImportRow `json:"rows"`
+}
+
+type Series struct {
+	Id          int          `json:"id"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Books       []SeriesBook `json:"books,omitempty"`
+}
+
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+type AuditEntry struct {
+	Id         int                    `json:"id"`
+	ActorId    int                    `json:"actor_id"`
+	Action     string                 `json:"action"`
+	TargetType string                 `json:"target_type"`
+	TargetId   string                 `json:"target_id"`
+	Diff       map[string]AuditChange `json:"diff"`
+	Ip         string                 `json:"ip"`
+	CreatedAt  time.Time              `json:"created_at"`
+}
+
+type AuditFilter struct {
+	ActorId    int       `form:"actor_id"`
+	Action     string    `form:"action"`
+	TargetType string    `form:"target_type"`
+	TargetId   string    `form:"target_id"`
+	From       time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	Until      time.Time `form:"until" time_format:"2006-01-02T15:04:05Z07:00"`
+	Page
+}
+
+type AuditPage struct {
+	Entries    []AuditEntry `json:"entries"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+type Invoice struct {
+	InvoiceID    int       `json:"invoice_id"`
+	PurchaseDate time.Time `json:"purchase_date"`
+	Name         string    `json:"customer_name,omitempty"`
 }
 
 type FPG struct {
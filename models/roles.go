@@ -0,0 +1,37 @@
+package models
+
+import "slices"
+
+const (
+	RoleCustomer     = "customer"
+	RoleLibrarian    = "librarian"
+	RoleStoreManager = "store_manager"
+	RoleAdmin        = "admin"
+)
+
+const (
+	PermManageCatalog = "catalog:manage"
+	PermManageBorrows = "borrows:manage"
+	PermViewInvoices  = "invoices:view"
+	PermManageUsers   = "users:manage"
+	PermViewAudit     = "audit:view"
+	PermManageClients = "apiclients:manage"
+)
+
+// RolePermissions lists what every staff role may do. Customers have no
+// permissions beyond their own account.
+var RolePermissions = map[string][]string{
+	RoleCustomer:     {},
+	RoleLibrarian:    {PermManageCatalog, PermManageBorrows},
+	RoleStoreManager: {PermManageCatalog, PermViewInvoices},
+	RoleAdmin:        {PermManageCatalog, PermManageBorrows, PermViewInvoices, PermManageUsers, PermViewAudit, PermManageClients},
+}
+
+func ValidRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+func HasPermission(role, permission string) bool {
+	return slices.Contains(RolePermissions[role], permission)
+}
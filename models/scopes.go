@@ -0,0 +1,18 @@
+package models
+
+import "slices"
+
+// Scopes say which parts of the api a client's key opens. A partner that
+// only shows our catalog gets ScopeCatalog; signing users in takes
+// ScopeAccount, and the staff screens ScopeAdmin on top of it.
+const (
+	ScopeCatalog = "catalog"
+	ScopeAccount = "account"
+	ScopeAdmin   = "admin"
+)
+
+var Scopes = []string{ScopeCatalog, ScopeAccount, ScopeAdmin}
+
+func ValidScope(scope string) bool {
+	return slices.Contains(Scopes, scope)
+}
@@ -0,0 +1,412 @@
+// Package oai is an OAI-PMH 2.0 data provider over the book catalog, for
+// libraries that harvest our metadata.
+package oai
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meynay/BookStore/catalog"
+	"github.com/meynay/BookStore/models"
+	"github.com/meynay/BookStore/store"
+)
+
+const (
+	PageSize       = 100
+	identifierBase = "oai:bookstore:book:"
+	granularity    = "2006-01-02T15:04:05Z"
+)
+
+type MetadataFormat struct {
+	Prefix    string `xml:"metadataPrefix"`
+	Schema    string `xml:"schema"`
+	Namespace string `xml:"metadataNamespace"`
+}
+
+var formats = []MetadataFormat{
+	{"oai_dc", "http://www.openarchives.org/OAI/2.0/oai_dc.xsd", "http://www.openarchives.org/OAI/2.0/oai_dc/"},
+	{"marc21", "http://www.loc.gov/standards/marcxml/schema/MARC21slim.xsd", catalog.MARCNamespace},
+}
+
+type Error struct {
+	Code    string `xml:"code,attr"`
+	Message string `xml:",chardata"`
+}
+
+type Request struct {
+	Verb            string `xml:"verb,attr,omitempty"`
+	Identifier      string `xml:"identifier,attr,omitempty"`
+	MetadataPrefix  string `xml:"metadataPrefix,attr,omitempty"`
+	From            string `xml:"from,attr,omitempty"`
+	Until           string `xml:"until,attr,omitempty"`
+	Set             string `xml:"set,attr,omitempty"`
+	ResumptionToken string `xml:"resumptionToken,attr,omitempty"`
+	BaseURL         string `xml:",chardata"`
+}
+
+type Header struct {
+	Identifier string `xml:"identifier"`
+	Datestamp  string `xml:"datestamp"`
+}
+
+type Metadata struct {
+	Record interface{}
+}
+
+type Record struct {
+	Header   Header    `xml:"header"`
+	Metadata *Metadata `xml:"metadata,omitempty"`
+}
+
+type ResumptionToken struct {
+	Cursor int    `xml:"cursor,attr,omitempty"`
+	Token  string `xml:",chardata"`
+}
+
+type Identify struct {
+	RepositoryName    string `xml:"repositoryName"`
+	BaseURL           string `xml:"baseURL"`
+	ProtocolVersion   string `xml:"protocolVersion"`
+	AdminEmail        string `xml:"adminEmail"`
+	EarliestDatestamp string `xml:"earliestDatestamp"`
+	DeletedRecord     string `xml:"deletedRecord"`
+	Granularity       string `xml:"granularity"`
+}
+
+type List struct {
+	Headers []Header         `xml:"header"`
+	Records []Record         `xml:"record"`
+	Token   *ResumptionToken `xml:"resumptionToken,omitempty"`
+}
+
+type Response struct {
+	XMLName             xml.Name `xml:"OAI-PMH"`
+	Xmlns               string   `xml:"xmlns,attr"`
+	XmlnsXsi            string   `xml:"xmlns:xsi,attr"`
+	SchemaLocation      string   `xml:"xsi:schemaLocation,attr"`
+	ResponseDate        string   `xml:"responseDate"`
+	Request             Request  `xml:"request"`
+	Errors              []Error  `xml:"error"`
+	Identify            *Identify
+	ListMetadataFormats *struct {
+		Formats []MetadataFormat `xml:"metadataFormat"`
+	} `xml:"ListMetadataFormats,omitempty"`
+	GetRecord *struct {
+		Record Record `xml:"record"`
+	} `xml:"GetRecord,omitempty"`
+	ListIdentifiers *List `xml:"ListIdentifiers,omitempty"`
+	ListRecords     *List `xml:"ListRecords,omitempty"`
+}
+
+// Provider answers OAI-PMH requests from the book store.
+type Provider struct {
+	Books      store.BookStore
+	Name       string
+	BaseURL    string
+	AdminEmail string
+}
+
+// token is where a list stopped, along with the arguments of the request
+// that started it.
+type token struct {
+	Prefix string    `json:"p"`
+	From   time.Time `json:"f,omitempty"`
+	Until  time.Time `json:"u,omitempty"`
+	After  time.Time `json:"t"`
+	Id     int       `json:"i"`
+	Cursor int       `json:"c"`
+}
+
+func (t token) encode() string {
+	raw, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeToken(s string) (token, error) {
+	var t token
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(raw, &t)
+	}
+	if err != nil || !hasFormat(t.Prefix) || t.Id == 0 {
+		return t, errors.New("invalid resumption token")
+	}
+	return t, nil
+}
+
+func hasFormat(prefix string) bool {
+	for _, f := range formats {
+		if f.Prefix == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDate reads a from/until argument in either granularity. Day dates
+// used as until cover the whole day.
+func parseDate(s string, end bool) (time.Time, string, error) {
+	if t, err := time.Parse(granularity, s); err == nil {
+		if end {
+			t = t.Add(time.Second - time.Nanosecond)
+		}
+		return t, "seconds", nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		if end {
+			t = t.Add(24*time.Hour - time.Nanosecond)
+		}
+		return t, "day", nil
+	}
+	return time.Time{}, "", fmt.Errorf("%q is not a valid datestamp", s)
+}
+
+var arguments = map[string]struct{ required, optional []string }{
+	"Identify":            {},
+	"ListMetadataFormats": {optional: []string{"identifier"}},
+	"ListSets":            {optional: []string{"resumptionToken"}},
+	"GetRecord":           {required: []string{"identifier", "metadataPrefix"}},
+	"ListIdentifiers":     {required: []string{"metadataPrefix"}, optional: []string{"from", "until", "set", "resumptionToken"}},
+	"ListRecords":         {required: []string{"metadataPrefix"}, optional: []string{"from", "until", "set", "resumptionToken"}},
+}
+
+// checkArguments applies the OAI-PMH rules: no unknown or repeated
+// arguments, and a resumption token comes alone.
+func checkArguments(verb string, args url.Values) error {
+	spec := arguments[verb]
+	allowed := map[string]bool{"verb": true}
+	for _, name := range append(spec.required, spec.optional...) {
+		allowed[name] = true
+	}
+	for name, values := range args {
+		if !allowed[name] {
+			return fmt.Errorf("illegal argument %q", name)
+		}
+		if len(values) > 1 {
+			return fmt.Errorf("repeated argument %q", name)
+		}
+	}
+	if args.Has("resumptionToken") {
+		if len(args) != 2 {
+			return errors.New("resumptionToken is an exclusive argument")
+		}
+		return nil
+	}
+	for _, name := range spec.required {
+		if args.Get(name) == "" {
+			return fmt.Errorf("missing argument %q", name)
+		}
+	}
+	return nil
+}
+
+// Handle answers one request, given its query or form arguments.
+func (p *Provider) Handle(args url.Values) (*Response, error) {
+	response := &Response{
+		Xmlns:          "http://www.openarchives.org/OAI/2.0/",
+		XmlnsXsi:       "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: "http://www.openarchives.org/OAI/2.0/ http://www.openarchives.org/OAI/2.0/OAI-PMH.xsd",
+		ResponseDate:   time.Now().UTC().Format(granularity),
+		Request:        Request{BaseURL: p.BaseURL},
+	}
+	fail := func(code, message string) (*Response, error) {
+		response.Errors = append(response.Errors, Error{Code: code, Message: message})
+		return response, nil
+	}
+	verb := args.Get("verb")
+	if _, ok := arguments[verb]; !ok || len(args["verb"]) > 1 {
+		return fail("badVerb", "illegal or missing verb")
+	}
+	if err := checkArguments(verb, args); err != nil {
+		return fail("badArgument", err.Error())
+	}
+	// echo the request only once it is known to be valid
+	response.Request = Request{
+		Verb:            verb,
+		Identifier:      args.Get("identifier"),
+		MetadataPrefix:  args.Get("metadataPrefix"),
+		From:            args.Get("from"),
+		Until:           args.Get("until"),
+		Set:             args.Get("set"),
+		ResumptionToken: args.Get("resumptionToken"),
+		BaseURL:         p.BaseURL,
+	}
+	switch verb {
+	case "Identify":
+		earliest := time.Now().UTC()
+		books, err := p.Books.Changes(time.Time{}, time.Time{}, time.Time{}, 0, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(books) > 0 {
+			earliest = books[0].UpdatedAt
+		}
+		response.Identify = &Identify{
+			RepositoryName:    p.Name,
+			BaseURL:           p.BaseURL,
+			ProtocolVersion:   "2.0",
+			AdminEmail:        p.AdminEmail,
+			EarliestDatestamp: earliest.UTC().Format(granularity),
+			DeletedRecord:     "no",
+			Granularity:       "YYYY-MM-DDThh:mm:ssZ",
+		}
+	case "ListMetadataFormats":
+		if id := args.Get("identifier"); id != "" {
+			if _, err := p.book(id); err != nil {
+				return p.bookError(response, err)
+			}
+		}
+		response.ListMetadataFormats = &struct {
+			Formats []MetadataFormat `xml:"metadataFormat"`
+		}{formats}
+	case "ListSets":
+		return fail("noSetHierarchy", "this repository does not support sets")
+	case "GetRecord":
+		prefix := args.Get("metadataPrefix")
+		if !hasFormat(prefix) {
+			return fail("cannotDisseminateFormat", fmt.Sprintf("unsupported metadata format %q", prefix))
+		}
+		book, err := p.book(args.Get("identifier"))
+		if err != nil {
+			return p.bookError(response, err)
+		}
+		record, err := p.record(book, prefix, true)
+		if err != nil {
+			return nil, err
+		}
+		response.GetRecord = &struct {
+			Record Record `xml:"record"`
+		}{record}
+	case "ListIdentifiers", "ListRecords":
+		return p.list(response, verb, args)
+	}
+	return response, nil
+}
+
+func (p *Provider) book(identifier string) (models.Book, error) {
+	id, err := strconv.Atoi(strings.TrimPrefix(identifier, identifierBase))
+	if err != nil || !strings.HasPrefix(identifier, identifierBase) {
+		return models.Book{}, store.ErrNotFound
+	}
+	return p.Books.Get(id)
+}
+
+func (p *Provider) bookError(response *Response, err error) (*Response, error) {
+	if errors.Is(err, store.ErrNotFound) {
+		response.Errors = append(response.Errors, Error{Code: "idDoesNotExist", Message: "no such record"})
+		return response, nil
+	}
+	return nil, err
+}
+
+func (p *Provider) record(book models.Book, prefix string, withMetadata bool) (Record, error) {
+	record := Record{Header: Header{
+		Identifier: fmt.Sprintf("%s%d", identifierBase, book.Id),
+		Datestamp:  book.UpdatedAt.UTC().Format(granularity),
+	}}
+	if !withMetadata {
+		return record, nil
+	}
+	switch prefix {
+	case "oai_dc":
+		dc := catalog.DublinCore(book, "oai_dc:dc", "oai_dc", "http://www.openarchives.org/OAI/2.0/oai_dc/")
+		record.Metadata = &Metadata{Record: dc}
+	case "marc21":
+		marc, err := catalog.MARCXML(book)
+		if err != nil {
+			return record, err
+		}
+		marc.Xmlns = catalog.MARCNamespace
+		record.Metadata = &Metadata{Record: marc}
+	}
+	return record, nil
+}
+
+func (p *Provider) list(response *Response, verb string, args url.Values) (*Response, error) {
+	fail := func(code, message string) (*Response, error) {
+		response.Errors = append(response.Errors, Error{Code: code, Message: message})
+		return response, nil
+	}
+	var t token
+	if raw := args.Get("resumptionToken"); raw != "" {
+		var err error
+		if t, err = decodeToken(raw); err != nil {
+			return fail("badResumptionToken", err.Error())
+		}
+	} else {
+		t.Prefix = args.Get("metadataPrefix")
+		if args.Get("set") != "" {
+			return fail("noSetHierarchy", "this repository does not support sets")
+		}
+		if !hasFormat(t.Prefix) {
+			return fail("cannotDisseminateFormat", fmt.Sprintf("unsupported metadata format %q", t.Prefix))
+		}
+		var fromGranularity, untilGranularity string
+		var err error
+		if from := args.Get("from"); from != "" {
+			if t.From, fromGranularity, err = parseDate(from, false); err != nil {
+				return fail("badArgument", err.Error())
+			}
+		}
+		if until := args.Get("until"); until != "" {
+			if t.Until, untilGranularity, err = parseDate(until, true); err != nil {
+				return fail("badArgument", err.Error())
+			}
+		}
+		if fromGranularity != "" && untilGranularity != "" && fromGranularity != untilGranularity {
+			return fail("badArgument", "from and until have different granularities")
+		}
+		if !t.From.IsZero() && !t.Until.IsZero() && t.Until.Before(t.From) {
+			return fail("badArgument", "until is before from")
+		}
+	}
+	// one more than a page tells whether a resumption token is needed
+	books, err := p.Books.Changes(t.From, t.Until, t.After, t.Id, PageSize+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(books) == 0 {
+		if t.Id != 0 {
+			return fail("badResumptionToken", "the list has changed since the token was issued")
+		}
+		return fail("noRecordsMatch", "no records match the request")
+	}
+	list := &List{}
+	more := len(books) > PageSize
+	if more {
+		books = books[:PageSize]
+	}
+	for _, book := range books {
+		record, err := p.record(book, t.Prefix, verb == "ListRecords")
+		if err != nil {
+			return nil, err
+		}
+		if verb == "ListRecords" {
+			list.Records = append(list.Records, record)
+		} else {
+			list.Headers = append(list.Headers, record.Header)
+		}
+	}
+	if t.Id != 0 || more {
+		list.Token = &ResumptionToken{Cursor: t.Cursor}
+		if more {
+			last := books[len(books)-1]
+			next := t
+			next.After, next.Id, next.Cursor = last.UpdatedAt, last.Id, t.Cursor+len(books)
+			list.Token.Token = next.encode()
+		}
+	}
+	if verb == "ListRecords" {
+		response.ListRecords = list
+	} else {
+		response.ListIdentifiers = list
+	}
+	return response, nil
+}
@@ -0,0 +1,192 @@
+// Package opds builds OPDS 1.2 catalog feeds, the Atom dialect e-reader
+// apps use to browse and acquire books.
+package opds
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/meynay/BookStore/images"
+	"github.com/meynay/BookStore/models"
+)
+
+const (
+	NavigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	AcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	EntryType       = "application/atom+xml;type=entry;profile=opds-catalog"
+	OpenSearchType  = "application/opensearchdescription+xml"
+	Currency        = "IRR"
+)
+
+type Price struct {
+	Currency string `xml:"currencycode,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type Link struct {
+	Rel   string `xml:"rel,attr,omitempty"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+	Count int    `xml:"thr:count,attr,omitempty"`
+	Price *Price `xml:"opds:price,omitempty"`
+}
+
+type Person struct {
+	Name string `xml:"name"`
+}
+
+type Category struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr"`
+}
+
+type Content struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type Entry struct {
+	Title      string     `xml:"title"`
+	Id         string     `xml:"id"`
+	Updated    string     `xml:"updated"`
+	Authors    []Person   `xml:"author"`
+	Identifier []string   `xml:"dc:identifier"`
+	Language   string     `xml:"dc:language,omitempty"`
+	Publisher  string     `xml:"dc:publisher,omitempty"`
+	Issued     string     `xml:"dc:issued,omitempty"`
+	Categories []Category `xml:"category"`
+	Summary    string     `xml:"summary,omitempty"`
+	Content    *Content   `xml:"content,omitempty"`
+	Links      []Link     `xml:"link"`
+}
+
+type Feed struct {
+	XMLName   xml.Name `xml:"feed"`
+	Xmlns     string   `xml:"xmlns,attr"`
+	XmlnsDC   string   `xml:"xmlns:dc,attr"`
+	XmlnsOPDS string   `xml:"xmlns:opds,attr"`
+	XmlnsThr  string   `xml:"xmlns:thr,attr"`
+	Id        string   `xml:"id"`
+	Title     string   `xml:"title"`
+	Updated   string   `xml:"updated"`
+	Author    Person   `xml:"author"`
+	Links     []Link   `xml:"link"`
+	Entries   []Entry  `xml:"entry"`
+}
+
+// NewFeed starts a feed with the links every feed of the catalog shares.
+// root is the path the catalog is served under.
+func NewFeed(root, id, title, self, kind string) *Feed {
+	now := time.Now().UTC()
+	feed := &Feed{
+		Xmlns:     "http://www.w3.org/2005/Atom",
+		XmlnsDC:   "http://purl.org/dc/terms/",
+		XmlnsOPDS: "http://opds-spec.org/2010/catalog",
+		XmlnsThr:  "http://purl.org/syndication/thread/1.0",
+		Id:        "urn:bookstore:opds:" + id,
+		Title:     title,
+		Updated:   now.Format(time.RFC3339),
+		Author:    Person{Name: "BookStore"},
+		Entries:   []Entry{},
+	}
+	feed.Links = []Link{
+		{Rel: "self", Href: self, Type: kind},
+		{Rel: "start", Href: root, Type: NavigationType},
+		{Rel: "search", Href: root + "/search.xml", Type: OpenSearchType},
+	}
+	return feed
+}
+
+// Navigate adds an entry pointing at another feed.
+func (f *Feed) Navigate(id, title, summary, href, kind string, count int) {
+	f.Entries = append(f.Entries, Entry{
+		Title:   title,
+		Id:      "urn:bookstore:opds:" + id,
+		Updated: f.Updated,
+		Content: &Content{Type: "text", Value: summary},
+		Links:   []Link{{Rel: "subsection", Href: href, Type: kind, Count: count}},
+	})
+}
+
+// Link adds a feed level link, such as pagination.
+func (f *Feed) Link(rel, href, kind string) {
+	f.Links = append(f.Links, Link{Rel: rel, Href: href, Type: kind})
+}
+
+// AddBook adds an acquisition entry. Books in the library can be borrowed
+// and books in stock can be bought; the links point at the JSON API that
+// does either.
+func (f *Feed) AddBook(book models.Book) {
+	entry := Entry{
+		Title:      book.Title,
+		Id:         fmt.Sprintf("urn:bookstore:book:%d", book.Id),
+		Updated:    f.Updated,
+		Authors:    []Person{},
+		Language:   book.Language,
+		Publisher:  book.Publisher,
+		Summary:    book.Description,
+		Categories: []Category{},
+		Links: []Link{
+			{Rel: "alternate", Href: fmt.Sprintf("/getbook/%d", book.Id), Type: "application/json", Title: "Book details"},
+		},
+	}
+	if book.Isbn13 != "" {
+		entry.Identifier = append(entry.Identifier, "urn:isbn:"+book.Isbn13)
+	}
+	if !book.PublicationDate.IsZero() {
+		entry.Issued = book.PublicationDate.Format("2006-01-02")
+	}
+	for _, a := range book.Authors {
+		if a.Role == "" {
+			entry.Authors = append(entry.Authors, Person{Name: a.Author})
+		}
+	}
+	for _, genre := range book.Genres {
+		entry.Categories = append(entry.Categories, Category{Term: genre, Label: genre})
+	}
+	if book.ImageUrl != "" {
+		entry.Links = append(entry.Links,
+			Link{Rel: "http://opds-spec.org/image", Href: book.ImageUrl},
+			Link{Rel: "http://opds-spec.org/image/thumbnail", Href: images.CoverURL(book.ImageUrl, images.Thumbnail, "jpg")})
+	}
+	if book.QuantityInLib > 0 {
+		entry.Links = append(entry.Links, Link{Rel: "http://opds-spec.org/acquisition/borrow", Href: fmt.Sprintf("/borrowbook/%d", book.Id), Type: "application/json"})
+	}
+	if book.QuantityForSale > 0 {
+		entry.Links = append(entry.Links, Link{
+			Rel:   "http://opds-spec.org/acquisition/buy",
+			Href:  fmt.Sprintf("/addtocart/%d", book.Id),
+			Type:  "application/json",
+			Price: &Price{Currency: Currency, Value: strconv.Itoa(book.Price)},
+		})
+	}
+	f.Entries = append(f.Entries, entry)
+}
+
+type OpenSearchUrl struct {
+	Type     string `xml:"type,attr"`
+	Template string `xml:"template,attr"`
+}
+
+type OpenSearchDescription struct {
+	XMLName     xml.Name      `xml:"OpenSearchDescription"`
+	Xmlns       string        `xml:"xmlns,attr"`
+	ShortName   string        `xml:"ShortName"`
+	Description string        `xml:"Description"`
+	InputEncode string        `xml:"InputEncoding"`
+	Url         OpenSearchUrl `xml:"Url"`
+}
+
+// NewOpenSearch describes the search feed so clients can fill in a query.
+func NewOpenSearch(root string) OpenSearchDescription {
+	return OpenSearchDescription{
+		Xmlns:       "http://a9.com/-/spec/opensearch/1.1/",
+		ShortName:   "BookStore",
+		Description: "Search books by title, author, ISBN or description",
+		InputEncode: "UTF-8",
+		Url:         OpenSearchUrl{Type: AcquisitionType, Template: root + "/search?q={searchTerms}"},
+	}
+}
@@ -0,0 +1,262 @@
+// Package sru answers SRU searchRetrieve requests by translating their CQL
+// queries into book filters.
+package sru
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/meynay/BookStore/models"
+)
+
+// Diagnostic is an SRU diagnostic, see
+// https://www.loc.gov/standards/sru/diagnostics/diagnosticsList.html
+type Diagnostic struct {
+	Code    int
+	Details string
+	Message string
+}
+
+func (d *Diagnostic) Error() string {
+	return fmt.Sprintf("%s: %s", d.Message, d.Details)
+}
+
+func syntaxError(details string) *Diagnostic {
+	return &Diagnostic{Code: 10, Details: details, Message: "Query syntax error"}
+}
+
+type cqlToken struct {
+	text   string
+	quoted bool
+}
+
+func tokenize(query string) ([]cqlToken, error) {
+	tokens := []cqlToken{}
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == '/':
+			tokens = append(tokens, cqlToken{text: string(r)})
+			i++
+		case r == '=' || r == '<' || r == '>':
+			op := string(r)
+			if i+1 < len(runes) && (runes[i+1] == '=' || r == '<' && runes[i+1] == '>') {
+				op += string(runes[i+1])
+			}
+			tokens = append(tokens, cqlToken{text: op})
+			i += len([]rune(op))
+		case r == '"':
+			var sb strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				sb.WriteRune(runes[i])
+			}
+			if i == len(runes) {
+				return nil, syntaxError("unterminated quoted term")
+			}
+			i++
+			tokens = append(tokens, cqlToken{text: sb.String(), quoted: true})
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune(`()/=<>"`, runes[i]) {
+				i++
+			}
+			tokens = append(tokens, cqlToken{text: string(runes[start:i])})
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []cqlToken
+	pos    int
+	filter *models.Filter
+	search []string
+}
+
+func (p *parser) peek() (cqlToken, bool) {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos], true
+	}
+	return cqlToken{}, false
+}
+
+func isRelation(t cqlToken) bool {
+	if t.quoted {
+		return false
+	}
+	switch strings.ToLower(t.text) {
+	case "=", "==", "<>", "<", ">", "<=", ">=", "any", "all", "adj", "exact", "within":
+		return true
+	}
+	return false
+}
+
+func isBoolean(t cqlToken) bool {
+	if t.quoted {
+		return false
+	}
+	switch strings.ToLower(t.text) {
+	case "and", "or", "not", "prox":
+		return true
+	}
+	return false
+}
+
+// Parse translates a CQL query into a filter. Book filters are a
+// conjunction, so only "and" is supported between clauses.
+func Parse(query string) (models.Filter, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return models.Filter{}, err
+	}
+	if len(tokens) == 0 {
+		return models.Filter{}, &Diagnostic{Code: 27, Message: "Empty term unsupported"}
+	}
+	p := &parser{tokens: tokens, filter: &models.Filter{}}
+	if err := p.query(); err != nil {
+		return models.Filter{}, err
+	}
+	if t, ok := p.peek(); ok {
+		return models.Filter{}, syntaxError(fmt.Sprintf("unexpected %q", t.text))
+	}
+	p.filter.Search = strings.Join(p.search, " ")
+	return *p.filter, nil
+}
+
+func (p *parser) query() error {
+	if err := p.clause(); err != nil {
+		return err
+	}
+	for {
+		t, ok := p.peek()
+		if !ok || t.text == ")" {
+			return nil
+		}
+		if !isBoolean(t) {
+			return syntaxError(fmt.Sprintf("expected a boolean operator before %q", t.text))
+		}
+		if strings.ToLower(t.text) != "and" {
+			return &Diagnostic{Code: 37, Details: t.text, Message: "Unsupported boolean operator"}
+		}
+		p.pos++
+		if t, ok := p.peek(); ok && t.text == "/" {
+			return &Diagnostic{Code: 46, Details: "boolean modifiers", Message: "Unsupported boolean modifier"}
+		}
+		if err := p.clause(); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *parser) clause() error {
+	t, ok := p.peek()
+	if !ok {
+		return syntaxError("query ends too early")
+	}
+	if t.text == "(" && !t.quoted {
+		p.pos++
+		if err := p.query(); err != nil {
+			return err
+		}
+		if t, ok := p.peek(); !ok || t.text != ")" {
+			return syntaxError("missing closing parenthesis")
+		}
+		p.pos++
+		return nil
+	}
+	p.pos++
+	relation, ok := p.peek()
+	if !ok || !isRelation(relation) {
+		// a bare term searches everywhere
+		return p.apply("cql.serverchoice", "=", t.text)
+	}
+	p.pos++
+	if next, ok := p.peek(); ok && next.text == "/" {
+		return &Diagnostic{Code: 20, Details: relation.text, Message: "Unsupported relation modifier"}
+	}
+	term, ok := p.peek()
+	if !ok {
+		return syntaxError("missing search term")
+	}
+	p.pos++
+	return p.apply(strings.ToLower(t.text), strings.ToLower(relation.text), term.text)
+}
+
+func unsupportedRelation(relation string) error {
+	return &Diagnostic{Code: 19, Details: relation, Message: "Unsupported relation"}
+}
+
+// bound applies a numeric relation to min/max filter bounds, which are
+// inclusive.
+func bound(relation, term string, min, max *int) error {
+	n, err := strconv.Atoi(term)
+	if err != nil {
+		return &Diagnostic{Code: 36, Details: term, Message: "Term in invalid format for index or relation"}
+	}
+	switch relation {
+	case "=", "==":
+		*min, *max = n, n
+	case ">=":
+		*min = n
+	case ">":
+		*min = n + 1
+	case "<=":
+		*max = n
+	case "<":
+		*max = n - 1
+	default:
+		return unsupportedRelation(relation)
+	}
+	return nil
+}
+
+func (p *parser) apply(index, relation, term string) error {
+	if term == "" {
+		return &Diagnostic{Code: 27, Message: "Empty term unsupported"}
+	}
+	list := func(values *[]string) error {
+		switch relation {
+		case "=", "==", "exact":
+			*values = append(*values, term)
+		case "any":
+			*values = append(*values, strings.Fields(term)...)
+		default:
+			return unsupportedRelation(relation)
+		}
+		return nil
+	}
+	index = strings.TrimPrefix(strings.TrimPrefix(index, "dc."), "bath.")
+	switch index {
+	case "cql.serverchoice", "cql.anywhere", "anywhere", "any", "title", "publisher", "description", "isbn", "identifier", "keyword":
+		if relation != "=" && relation != "all" && relation != "adj" && relation != "==" {
+			return unsupportedRelation(relation)
+		}
+		p.search = append(p.search, term)
+	case "creator", "author", "name":
+		return list(&p.filter.Authors)
+	case "subject", "genre":
+		return list(&p.filter.Genres)
+	case "language":
+		return list(&p.filter.Languages)
+	case "format":
+		return list(&p.filter.Formats)
+	case "date", "year":
+		return bound(relation, term, &p.filter.StartDate, &p.filter.EndDate)
+	case "pages":
+		return bound(relation, term, &p.filter.MinPages, &p.filter.MaxPages)
+	case "price":
+		return bound(relation, term, &p.filter.MinPrice, &p.filter.MaxPrice)
+	default:
+		return &Diagnostic{Code: 16, Details: index, Message: "Unsupported index"}
+	}
+	return nil
+}
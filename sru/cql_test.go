@@ -0,0 +1,101 @@
+package sru
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/meynay/BookStore/models"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []cqlToken
+	}{
+		{`dc.title = "war and peace"`, []cqlToken{{text: "dc.title"}, {text: "="}, {text: "war and peace", quoted: true}}},
+		{`price<=100 and pages>=50`, []cqlToken{{text: "price"}, {text: "<="}, {text: "100"}, {text: "and"}, {text: "pages"}, {text: ">="}, {text: "50"}}},
+		{`(a<>b)`, []cqlToken{{text: "("}, {text: "a"}, {text: "<>"}, {text: "b"}, {text: ")"}}},
+		{`title ==/x y`, []cqlToken{{text: "title"}, {text: "=="}, {text: "/"}, {text: "x"}, {text: "y"}}},
+		{`"say \"hi\""`, []cqlToken{{text: `say "hi"`, quoted: true}}},
+		{`  حافظ  `, []cqlToken{{text: "حافظ"}}},
+		{``, []cqlToken{}},
+	}
+	for _, tt := range tests {
+		got, err := tokenize(tt.query)
+		if err != nil {
+			t.Errorf("tokenize(%q): %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenize(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+	if _, err := tokenize(`title = "open`); diagnostic(err) != 10 {
+		t.Errorf("unterminated quote: got %v, want diagnostic 10", err)
+	}
+}
+
+func diagnostic(err error) int {
+	var d *Diagnostic
+	if errors.As(err, &d) {
+		return d.Code
+	}
+	return 0
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query string
+		want  models.Filter
+	}{
+		{`rumi`, models.Filter{Search: "rumi"}},
+		{`dc.title = "masnavi" and dc.creator = rumi`, models.Filter{Search: "masnavi", Authors: []string{"rumi"}}},
+		{`(subject any "poetry mysticism") and language exact fa`, models.Filter{Genres: []string{"poetry", "mysticism"}, Languages: []string{"fa"}}},
+		{`date >= 1990 and date < 2000`, models.Filter{StartDate: 1990, EndDate: 1999}},
+		{`price > 10 and PAGES <= 300`, models.Filter{MinPrice: 11, MaxPages: 300}},
+		{`bath.isbn = 9780306406157 AND keyword adj "first edition"`, models.Filter{Search: "9780306406157 first edition"}},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.query)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		code  int
+	}{
+		{``, 27},
+		{`   `, 27},
+		{`title = ""`, 27},
+		{`title = "open`, 10},
+		{`(title = a`, 10},
+		{`title = a)`, 10},
+		{`title =`, 10},
+		{`title = a and`, 10},
+		{`title = a rumi`, 10},
+		{`title = a or title = b`, 37},
+		{`title = a not title = b`, 37},
+		{`title = a and/x title = b`, 46},
+		{`title =/stem a`, 20},
+		{`title < a`, 19},
+		{`creator within a`, 19},
+		{`price any 10`, 19},
+		{`price = cheap`, 36},
+		{`shelf = 3`, 16},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.query)
+		if got := diagnostic(err); got != tt.code {
+			t.Errorf("Parse(%q): got %v, want diagnostic %d", tt.query, err, tt.code)
+		}
+	}
+}
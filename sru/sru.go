@@ -0,0 +1,200 @@
+package sru
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/url"
+	"strconv"
+
+	"github.com/meynay/BookStore/catalog"
+	"github.com/meynay/BookStore/models"
+	"github.com/meynay/BookStore/store"
+)
+
+const (
+	Version        = "1.2"
+	DefaultRecords = 10
+	// MaxPosition bounds startRecord, since results are walked page by page
+	MaxPosition = 1000
+	dcSchema    = "info:srw/schema/1/dc-v1.1"
+	marcSchema  = "info:srw/schema/1/marcxml-v1.1"
+)
+
+type XMLDiagnostic struct {
+	Xmlns   string `xml:"xmlns,attr"`
+	Uri     string `xml:"uri"`
+	Details string `xml:"details,omitempty"`
+	Message string `xml:"message"`
+}
+
+type RecordData struct {
+	Record interface{}
+}
+
+type Record struct {
+	Schema   string     `xml:"recordSchema"`
+	Packing  string     `xml:"recordPacking"`
+	Data     RecordData `xml:"recordData"`
+	Position int        `xml:"recordPosition"`
+}
+
+type Response struct {
+	XMLName            xml.Name     `xml:"searchRetrieveResponse"`
+	Xmlns              string       `xml:"xmlns,attr"`
+	Version            string       `xml:"version"`
+	NumberOfRecords    int          `xml:"numberOfRecords"`
+	Records            *Records     `xml:"records,omitempty"`
+	NextRecordPosition int          `xml:"nextRecordPosition,omitempty"`
+	Diagnostics        *Diagnostics `xml:"diagnostics,omitempty"`
+}
+
+type Records struct {
+	Records []Record `xml:"record"`
+}
+
+type Diagnostics struct {
+	Diagnostics []XMLDiagnostic `xml:"diagnostic"`
+}
+
+func newResponse() *Response {
+	return &Response{Xmlns: "http://www.loc.gov/zing/srw/", Version: Version}
+}
+
+func (r *Response) fail(d *Diagnostic) *Response {
+	if r.Diagnostics == nil {
+		r.Diagnostics = &Diagnostics{}
+	}
+	r.Diagnostics.Diagnostics = append(r.Diagnostics.Diagnostics, XMLDiagnostic{
+		Xmlns:   "http://www.loc.gov/zing/srw/diagnostic/",
+		Uri:     "info:srw/diagnostic/1/" + strconv.Itoa(d.Code),
+		Details: d.Details,
+		Message: d.Message,
+	})
+	return r
+}
+
+func positiveArg(args url.Values, name string, def int) (int, *Diagnostic) {
+	raw := args.Get(name)
+	if raw == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return 0, &Diagnostic{Code: 6, Details: name, Message: "Unsupported parameter value"}
+	}
+	return n, nil
+}
+
+// SearchRetrieve runs a searchRetrieve operation. Protocol problems are
+// reported as diagnostics in the response; the error is for store failures.
+func SearchRetrieve(books store.BookStore, args url.Values) (*Response, error) {
+	response := newResponse()
+	if op := args.Get("operation"); op != "searchRetrieve" {
+		return response.fail(&Diagnostic{Code: 4, Details: op, Message: "Unsupported operation"}), nil
+	}
+	if v := args.Get("version"); v != "" && v != "1.1" && v != Version {
+		return response.fail(&Diagnostic{Code: 5, Details: Version, Message: "Unsupported version"}), nil
+	}
+	if args.Get("query") == "" {
+		return response.fail(&Diagnostic{Code: 7, Details: "query", Message: "Mandatory parameter not supplied"}), nil
+	}
+	schema := dcSchema
+	switch args.Get("recordSchema") {
+	case "", "dc", dcSchema:
+	case "marcxml", marcSchema:
+		schema = marcSchema
+	default:
+		return response.fail(&Diagnostic{Code: 66, Details: args.Get("recordSchema"), Message: "Unknown schema for retrieval"}), nil
+	}
+	if packing := args.Get("recordPacking"); packing != "" && packing != "xml" {
+		return response.fail(&Diagnostic{Code: 71, Details: packing, Message: "Unsupported record packing"}), nil
+	}
+	start, d := positiveArg(args, "startRecord", 1)
+	if d != nil {
+		return response.fail(d), nil
+	}
+	maximum, d := positiveArg(args, "maximumRecords", DefaultRecords)
+	if d != nil {
+		return response.fail(d), nil
+	}
+	if maximum > store.MaxLimit {
+		maximum = store.MaxLimit
+	}
+	if start < 1 || start > MaxPosition {
+		return response.fail(&Diagnostic{Code: 61, Details: strconv.Itoa(start), Message: "First record position out of range"}), nil
+	}
+	filter, err := Parse(args.Get("query"))
+	var diagnostic *Diagnostic
+	if errors.As(err, &diagnostic) {
+		return response.fail(diagnostic), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if response.NumberOfRecords, err = books.Count(filter); err != nil {
+		return nil, err
+	}
+	if maximum == 0 || start > response.NumberOfRecords {
+		return response, nil
+	}
+	ids, err := window(books, filter, start, maximum)
+	if err != nil {
+		return nil, err
+	}
+	details, err := books.Details(ids)
+	if err != nil {
+		return nil, err
+	}
+	response.Records = &Records{}
+	for i, book := range details {
+		record := Record{Schema: schema, Packing: "xml", Position: start + i}
+		if schema == dcSchema {
+			record.Data.Record = catalog.DublinCore(book, "srw_dc:dc", "srw_dc", "info:srw/schema/1/dc-schema")
+		} else {
+			marc, err := catalog.MARCXML(book)
+			if err != nil {
+				return nil, err
+			}
+			marc.Xmlns = catalog.MARCNamespace
+			record.Data.Record = marc
+		}
+		response.Records.Records = append(response.Records.Records, record)
+	}
+	if next := start + len(details); next <= response.NumberOfRecords {
+		response.NextRecordPosition = next
+	}
+	return response, nil
+}
+
+// window returns the ids of count books starting at the 1-based position
+// start. Filters page by cursor, so it walks the pages before start; the
+// sort is fixed so positions stay stable between requests.
+func window(books store.BookStore, filter models.Filter, start, count int) ([]int, error) {
+	filter.Sort = "title"
+	if filter.Search != "" {
+		filter.Sort = store.SortRelevance
+	}
+	filter.Limit = store.MaxLimit
+	ids := []int{}
+	skip := start - 1
+	for {
+		page, err := books.Filter(filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, book := range page.Books {
+			if skip > 0 {
+				skip--
+				continue
+			}
+			ids = append(ids, book.Id)
+			if len(ids) == count {
+				return ids, nil
+			}
+		}
+		if page.NextCursor == "" {
+			return ids, nil
+		}
+		filter.Cursor = page.NextCursor
+	}
+}
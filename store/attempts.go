@@ -0,0 +1,155 @@
+package store
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// AttemptStore counts failed attempts, like wrong passwords, under keys such
+// as "login:ip:203.0.113.7", and keeps temporary locks on them. Counts are
+// forgotten once window passes without another failure.
+type AttemptStore interface {
+	Fail(key string, window time.Duration) (int, error)
+	Reset(key string) error
+	Lock(key string, d time.Duration) error
+	// LockedUntil returns the zero time for keys that aren't locked.
+	LockedUntil(key string) (time.Time, error)
+}
+
+type memAttempt struct {
+	count  int
+	last   time.Time
+	window time.Duration
+}
+
+// sweepInterval is how often memAttempts walks everything for keys nobody
+// asked about again. Keys that are asked about expire on their own.
+const sweepInterval = time.Minute
+
+type memAttempts struct {
+	mu       sync.Mutex
+	attempts map[string]memAttempt
+	locks    map[string]time.Time
+	swept    time.Time
+}
+
+// NewMemoryAttempts keeps counts in process memory, so each instance of the
+// server counts on its own and restarts forget.
+func NewMemoryAttempts() AttemptStore {
+	return &memAttempts{attempts: make(map[string]memAttempt), locks: make(map[string]time.Time), swept: time.Now()}
+}
+
+func (s *memAttempts) Fail(key string, window time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.swept) > sweepInterval {
+		s.sweep(now)
+	}
+	attempt := s.attempts[key]
+	if now.Sub(attempt.last) > attempt.window {
+		attempt = memAttempt{}
+	}
+	attempt.count++
+	attempt.last = now
+	attempt.window = window
+	s.attempts[key] = attempt
+	return attempt.count, nil
+}
+
+// sweep drops what is too old to matter so the maps don't grow forever.
+func (s *memAttempts) sweep(now time.Time) {
+	s.swept = now
+	for key, attempt := range s.attempts {
+		if now.Sub(attempt.last) > attempt.window {
+			delete(s.attempts, key)
+		}
+	}
+	for key, until := range s.locks {
+		if !until.After(now) {
+			delete(s.locks, key)
+		}
+	}
+}
+
+func (s *memAttempts) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	delete(s.locks, key)
+	return nil
+}
+
+func (s *memAttempts) Lock(key string, d time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.locks[key] = time.Now().Add(d)
+	return nil
+}
+
+func (s *memAttempts) LockedUntil(key string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	until, ok := s.locks[key]
+	if !until.After(time.Now()) {
+		if ok {
+			delete(s.locks, key)
+		}
+		return time.Time{}, nil
+	}
+	return until, nil
+}
+
+type fallbackAttempts struct {
+	primary, backup AttemptStore
+}
+
+// FallbackAttempts uses primary and switches to backup for the calls where
+// primary fails, so an unreachable Redis doesn't switch protection off.
+func FallbackAttempts(primary, backup AttemptStore) AttemptStore {
+	return &fallbackAttempts{primary: primary, backup: backup}
+}
+
+func (s *fallbackAttempts) failed(err error) bool {
+	if err != nil {
+		log.Println("attempt store unavailable, counting in memory:", err)
+	}
+	return err != nil
+}
+
+func (s *fallbackAttempts) Fail(key string, window time.Duration) (int, error) {
+	count, err := s.primary.Fail(key, window)
+	if s.failed(err) {
+		return s.backup.Fail(key, window)
+	}
+	return count, nil
+}
+
+// Reset clears both. When primary can't be reached its counts just stay
+// until they expire, which only errs on the safe side.
+func (s *fallbackAttempts) Reset(key string) error {
+	s.failed(s.primary.Reset(key))
+	return s.backup.Reset(key)
+}
+
+func (s *fallbackAttempts) Lock(key string, d time.Duration) error {
+	if s.failed(s.primary.Lock(key, d)) {
+		return s.backup.Lock(key, d)
+	}
+	return nil
+}
+
+// LockedUntil asks both, since locks may have been set while primary was
+// down.
+func (s *fallbackAttempts) LockedUntil(key string) (time.Time, error) {
+	until, err := s.backup.LockedUntil(key)
+	if err != nil {
+		return until, err
+	}
+	primary, err := s.primary.LockedUntil(key)
+	if s.failed(err) || primary.Before(until) {
+		return until, nil
+	}
+	return primary, nil
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisAttempts shares counts between every instance of the server. Keys
+// expire on their own, so nothing has to clean up after them.
+type redisAttempts struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisAttempts(client *redis.Client) AttemptStore {
+	return &redisAttempts{client: client, prefix: "attempts:"}
+}
+
+func (s *redisAttempts) Fail(key string, window time.Duration) (int, error) {
+	ctx := context.Background()
+	var incr *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, s.prefix+key)
+		pipe.Expire(ctx, s.prefix+key, window)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(incr.Val()), nil
+}
+
+func (s *redisAttempts) Reset(key string) error {
+	return s.client.Del(context.Background(), s.prefix+key, s.prefix+"lock:"+key).Err()
+}
+
+func (s *redisAttempts) Lock(key string, d time.Duration) error {
+	return s.client.Set(context.Background(), s.prefix+"lock:"+key, "1", d).Err()
+}
+
+func (s *redisAttempts) LockedUntil(key string) (time.Time, error) {
+	ttl, err := s.client.PTTL(context.Background(), s.prefix+"lock:"+key).Result()
+	if err != nil {
+		return time.Time{}, err
+	}
+	// missing keys come back as negative durations
+	if ttl <= 0 {
+		return time.Time{}, nil
+	}
+	return time.Now().Add(ttl), nil
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryAttemptsWindow(t *testing.T) {
+	s := NewMemoryAttempts()
+	for want := 1; want <= 3; want++ {
+		if got, _ := s.Fail("login:ip:203.0.113.7", time.Hour); got != want {
+			t.Fatalf("Fail = %d, want %d", got, want)
+		}
+	}
+	if got, _ := s.Fail("login:ip:203.0.113.8", time.Hour); got != 1 {
+		t.Errorf("other key = %d, want 1", got)
+	}
+	s.Fail("short", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if got, _ := s.Fail("short", 10*time.Millisecond); got != 1 {
+		t.Errorf("after the window = %d, want the count to start over", got)
+	}
+	s.Reset("login:ip:203.0.113.7")
+	if got, _ := s.Fail("login:ip:203.0.113.7", time.Hour); got != 1 {
+		t.Errorf("after Reset = %d, want 1", got)
+	}
+}
+
+func TestMemoryAttemptsLock(t *testing.T) {
+	s := NewMemoryAttempts()
+	if until, _ := s.LockedUntil("login:account:a@example.com"); !until.IsZero() {
+		t.Errorf("unlocked key locked until %v", until)
+	}
+	s.Lock("login:account:a@example.com", time.Hour)
+	if until, _ := s.LockedUntil("login:account:a@example.com"); until.Before(time.Now().Add(59 * time.Minute)) {
+		t.Errorf("locked until %v, want an hour from now", until)
+	}
+	s.Lock("short", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if until, _ := s.LockedUntil("short"); !until.IsZero() {
+		t.Errorf("expired lock still until %v", until)
+	}
+	if _, ok := s.(*memAttempts).locks["short"]; ok {
+		t.Error("expired lock kept after being asked about")
+	}
+}
+
+func TestMemoryAttemptsSweep(t *testing.T) {
+	s := NewMemoryAttempts().(*memAttempts)
+	s.Fail("stale", time.Millisecond)
+	s.Lock("stale", time.Millisecond)
+	s.Fail("fresh", time.Hour)
+	time.Sleep(5 * time.Millisecond)
+
+	s.Fail("fresh", time.Hour)
+	if _, ok := s.attempts["stale"]; !ok {
+		t.Fatal("swept before the interval")
+	}
+	s.swept = time.Now().Add(-2 * sweepInterval)
+	s.Fail("fresh", time.Hour)
+	if _, ok := s.attempts["stale"]; ok {
+		t.Error("stale count survived the sweep")
+	}
+	if _, ok := s.locks["stale"]; ok {
+		t.Error("stale lock survived the sweep")
+	}
+	if s.attempts["fresh"].count != 3 {
+		t.Errorf("fresh count = %d, want 3", s.attempts["fresh"].count)
+	}
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/meynay/BookStore/models"
+)
+
+func TestAuthorsDedupedOnCreate(t *testing.T) {
+	stores := NewMemory()
+	a := models.Book{Title: "شازده احتجاب", Authors: []models.AuthorR{{Author: "هوشنگ گلشیری"}}}
+	b := models.Book{Title: "آینه‌های دردار", Authors: []models.AuthorR{{Author: " هوشنگ گلشيری "}}}
+	stores.Books.Create(&a)
+	stores.Books.Create(&b)
+	if a.Authors[0].Id != b.Authors[0].Id {
+		t.Errorf("the same name spelled with an Arabic yeh made author %d next to %d", b.Authors[0].Id, a.Authors[0].Id)
+	}
+}
+
+func TestMergeAuthors(t *testing.T) {
+	stores := NewMemory()
+	books := []models.Book{
+		{Title: "both", Authors: []models.AuthorR{{Author: "Houshang Golshiri", Role: "author"}, {Author: "H. Golshiri", Role: "author"}}},
+		{Title: "duplicate only", Authors: []models.AuthorR{{Author: "H. Golshiri", Role: "author"}, {Author: "Ahmad Shamlou", Role: "translator"}}},
+		{Title: "other role", Authors: []models.AuthorR{{Author: "Houshang Golshiri", Role: "author"}, {Author: "Golshiri H.", Role: "editor"}}},
+	}
+	for i := range books {
+		stores.Books.Create(&books[i])
+	}
+	target, dup, other := books[0].Authors[0].Id, books[0].Authors[1].Id, books[2].Authors[1].Id
+	stores.Authors.Update(models.Author{Id: dup, Name: "H. Golshiri", Bio: "نویسنده", PhotoUrl: "https://example.com/g.jpg"})
+
+	if err := stores.Authors.Merge(target, []int{dup, 999}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Merge with an unknown duplicate: got %v, want ErrNotFound", err)
+	}
+	if _, err := stores.Authors.Get(dup); err != nil {
+		t.Fatalf("failed merge deleted the duplicate: %v", err)
+	}
+	if err := stores.Authors.Merge(target, []int{dup, other}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []int{dup, other} {
+		if _, err := stores.Authors.Get(id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("duplicate %d still there: %v", id, err)
+		}
+	}
+	author, _ := stores.Authors.Get(target)
+	if author.Bio != "نویسنده" || author.PhotoUrl != "https://example.com/g.jpg" {
+		t.Errorf("merged author kept bio %q photo %q, want the duplicate's", author.Bio, author.PhotoUrl)
+	}
+	want := map[string][]models.AuthorR{
+		"both":           {{Id: target, Author: "Houshang Golshiri", Role: "author"}},
+		"duplicate only": {{Id: target, Author: "Houshang Golshiri", Role: "author"}, books[1].Authors[1]},
+		"other role":     {{Id: target, Author: "Houshang Golshiri", Role: "author"}, {Id: target, Author: "Houshang Golshiri", Role: "editor"}},
+	}
+	for _, b := range books {
+		book, _ := stores.Books.Get(b.Id)
+		if !reflect.DeepEqual(book.Authors, want[b.Title]) {
+			t.Errorf("%s: authors %+v, want %+v", b.Title, book.Authors, want[b.Title])
+		}
+	}
+	if got := len(author.Books["author"]) + len(author.Books["editor"]); got != 4 {
+		t.Errorf("merged author lists %d book roles, want 4", got)
+	}
+}
@@ -0,0 +1,225 @@
+package store
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config points at an S3 compatible bucket. PublicEndpoint is what
+// signed urls are made for when clients reach the storage through another
+// address than the server does, as with a MinIO container behind a proxy.
+type S3Config struct {
+	Endpoint       string
+	PublicEndpoint string
+	Region         string
+	Bucket         string
+	AccessKey      string
+	SecretKey      string
+}
+
+// s3Blobs talks to the bucket over plain HTTP with AWS signature version 4
+// and path style addressing, which MinIO and the other stand-ins support.
+type s3Blobs struct {
+	config S3Config
+	client *http.Client
+}
+
+func NewS3Blobs(config S3Config) BlobStore {
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.PublicEndpoint == "" {
+		config.PublicEndpoint = config.Endpoint
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	config.PublicEndpoint = strings.TrimSuffix(config.PublicEndpoint, "/")
+	return &s3Blobs{config: config, client: &http.Client{Timeout: time.Minute}}
+}
+
+func (s *s3Blobs) objectURL(endpoint, key string) (*url.URL, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = "/" + s.config.Bucket + "/" + key
+	u.RawPath = "/" + s.config.Bucket + "/" + s3Escape(key)
+	return u, nil
+}
+
+func (s *s3Blobs) do(method, key string, body []byte, contentType string) (*http.Response, error) {
+	u, err := s.objectURL(s.config.Endpoint, key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, sha256Hex(body), time.Now().UTC())
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, ErrNotFound
+	}
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, key, res.Status, msg)
+	}
+	return res, nil
+}
+
+func (s *s3Blobs) Put(key string, r io.Reader, contentType string) error {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	res, err := s.do(http.MethodPut, key, body, contentType)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func (s *s3Blobs) Get(key string) (io.ReadCloser, string, error) {
+	res, err := s.do(http.MethodGet, key, nil, "")
+	if err != nil {
+		return nil, "", err
+	}
+	return res.Body, res.Header.Get("Content-Type"), nil
+}
+
+// Delete succeeds for missing keys, like S3 itself does.
+func (s *s3Blobs) Delete(key string) error {
+	res, err := s.do(http.MethodDelete, key, nil, "")
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// URL presigns a GET of the object, which S3 accepts for up to a week.
+func (s *s3Blobs) URL(key string, expires time.Duration) (string, error) {
+	u, err := s.objectURL(s.config.PublicEndpoint, key)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.config.AccessKey + "/" + s.scope(now)},
+		"X-Amz-Date":          {now.Format("20060102T150405Z")},
+		"X-Amz-Expires":       {strconv.Itoa(int(min(expires, 7*24*time.Hour).Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	u.RawQuery = s3Query(query)
+	canonical := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		u.RawQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	query.Set("X-Amz-Signature", s.signature(now, canonical))
+	u.RawQuery = s3Query(query)
+	return u.String(), nil
+}
+
+func (s *s3Blobs) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.config.Region + "/s3/aws4_request"
+}
+
+// sign adds the Authorization header of signature version 4 to the request.
+func (s *s3Blobs) sign(req *http.Request, payloadHash string, now time.Time) {
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3Query(req.URL.Query()),
+		canonicalHeaders.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKey, s.scope(now), signed, s.signature(now, canonical)))
+}
+
+func (s *s3Blobs) signature(now time.Time, canonical string) string {
+	toSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format("20060102T150405Z"),
+		s.scope(now),
+		sha256Hex([]byte(canonical)),
+	}, "\n")
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+// s3Escape encodes everything but the unreserved characters and slashes,
+// which is stricter than url.PathEscape and what signature version 4 wants.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, strings.ReplaceAll(s3Escape(key), "/", "%2F")+"="+strings.ReplaceAll(s3Escape(value), "/", "%2F"))
+		}
+	}
+	return strings.Join(parts, "&")
+}
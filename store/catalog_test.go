@@ -0,0 +1,47 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/meynay/BookStore/models"
+)
+
+func TestIsbn13Unique(t *testing.T) {
+	books := NewMemory().Books
+	kelidar := models.Book{Title: "کلیدر", Isbn13: "9789643510000"}
+	if err := books.Create(&kelidar); err != nil {
+		t.Fatal(err)
+	}
+	// hyphens don't make it another isbn
+	if err := books.Create(&models.Book{Title: "کلیدر", Isbn13: "978-964-351-0000"}); !errors.Is(err, ErrDuplicateIsbn) {
+		t.Errorf("Create with a taken isbn13: %v, want ErrDuplicateIsbn", err)
+	}
+	untitled := models.Book{Title: "no isbn"}
+	for i := 0; i < 2; i++ {
+		untitled.Id = 0
+		if err := books.Create(&untitled); err != nil {
+			t.Errorf("Create without an isbn13: %v", err)
+		}
+	}
+	untitled.Isbn13 = "978-9643510000"
+	if err := books.Update(untitled); !errors.Is(err, ErrDuplicateIsbn) {
+		t.Errorf("Update to a taken isbn13: %v, want ErrDuplicateIsbn", err)
+	}
+	kelidar.Price = 1500000
+	if err := books.Update(kelidar); err != nil {
+		t.Errorf("Update keeping its own isbn13: %v", err)
+	}
+
+	statuses, err := books.Import([]models.Book{{Title: "کلیدر", Isbn13: "9789643510000", Price: 1600000}, {Title: "new", Isbn13: "9780000000002"}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{models.ImportUpdated, models.ImportCreated}; !reflect.DeepEqual(statuses, want) {
+		t.Errorf("Import = %v, want %v", statuses, want)
+	}
+	if book, _ := books.Get(kelidar.Id); book.Price != 1600000 {
+		t.Errorf("imported price = %d, want 1600000", book.Price)
+	}
+}
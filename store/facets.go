@@ -0,0 +1,33 @@
+package store
+
+import "github.com/meynay/BookStore/models"
+
+const FacetLimit = 20
+
+// Lower bounds of the page-count and price buckets; the last bucket of
+// each is open-ended.
+var (
+	PageBuckets  = []int{0, 100, 200, 300, 500}
+	PriceBuckets = []int{0, 50000, 100000, 200000, 500000}
+)
+
+func ranges(edges, counts []int) []models.RangeCount {
+	result := []models.RangeCount{}
+	for i, lo := range edges {
+		r := models.RangeCount{Min: lo, Count: counts[i]}
+		if i+1 < len(edges) {
+			r.Max = edges[i+1]
+		}
+		result = append(result, r)
+	}
+	return result
+}
+
+func bucketOf(edges []int, value int) int {
+	for i := len(edges) - 1; i >= 0; i-- {
+		if value >= edges[i] {
+			return i
+		}
+	}
+	return -1
+}
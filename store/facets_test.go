@@ -0,0 +1,59 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/meynay/BookStore/models"
+)
+
+func TestFacets(t *testing.T) {
+	books := NewMemory().Books
+	for _, book := range []models.Book{
+		{Title: "a", Genres: []string{"رمان", "تاریخی"}, Format: "paperback", Publisher: "چشمه", NumberOfPages: 90, Price: 40000},
+		{Title: "b", Genres: []string{"رمان"}, Format: "ebook", Publisher: "چشمه", NumberOfPages: 250, Price: 120000},
+		{Title: "c", Genres: []string{"رمان"}, Format: "paperback", Publisher: "ققنوس", NumberOfPages: 700, Price: 600000},
+		{Title: "d", Genres: []string{"شعر"}, Format: "paperback", Publisher: "نیلوفر", NumberOfPages: 100, Price: 50000},
+	} {
+		if err := books.Create(&book); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page, err := books.Filter(models.Filter{Genres: []string{"رمان"}, Page: models.Page{Limit: 2, Sort: "title"}})
+	if err != nil || page.Facets == nil {
+		t.Fatalf("Filter = %+v, %v", page, err)
+	}
+	facets := page.Facets
+	// counted over every match, not just this page, and only over matches
+	if want := []models.FacetCount{{Value: "رمان", Count: 3}, {Value: "تاریخی", Count: 1}}; !reflect.DeepEqual(facets.Genres, want) {
+		t.Errorf("genres = %+v, want %+v", facets.Genres, want)
+	}
+	if want := []models.FacetCount{{Value: "paperback", Count: 2}, {Value: "ebook", Count: 1}}; !reflect.DeepEqual(facets.Formats, want) {
+		t.Errorf("formats = %+v, want %+v", facets.Formats, want)
+	}
+	if want := []models.FacetCount{{Value: "چشمه", Count: 2}, {Value: "ققنوس", Count: 1}}; !reflect.DeepEqual(facets.Publishers, want) {
+		t.Errorf("publishers = %+v, want %+v", facets.Publishers, want)
+	}
+	wantPages := []models.RangeCount{{Min: 0, Max: 100, Count: 1}, {Min: 100, Max: 200}, {Min: 200, Max: 300, Count: 1}, {Min: 300, Max: 500}, {Min: 500, Count: 1}}
+	if !reflect.DeepEqual(facets.Pages, wantPages) {
+		t.Errorf("pages = %+v, want %+v", facets.Pages, wantPages)
+	}
+	wantPrices := []models.RangeCount{{Min: 0, Max: 50000, Count: 1}, {Min: 50000, Max: 100000}, {Min: 100000, Max: 200000, Count: 1}, {Min: 200000, Max: 500000}, {Min: 500000, Count: 1}}
+	if !reflect.DeepEqual(facets.Prices, wantPrices) {
+		t.Errorf("prices = %+v, want %+v", facets.Prices, wantPrices)
+	}
+
+	next, err := books.Filter(models.Filter{Genres: []string{"رمان"}, Page: models.Page{Limit: 2, Sort: "title", Cursor: page.NextCursor}})
+	if err != nil || len(next.Books) != 1 || next.Facets != nil {
+		t.Errorf("second page = %d books, facets %v, %v; want 1 book and no facets", len(next.Books), next.Facets, err)
+	}
+}
+
+func TestBucketOf(t *testing.T) {
+	for value, want := range map[int]int{-1: -1, 0: 0, 99: 0, 100: 1, 499: 3, 500: 4, 10000: 4} {
+		if got := bucketOf(PageBuckets, value); got != want {
+			t.Errorf("bucketOf(%d) = %d, want %d", value, got, want)
+		}
+	}
+}
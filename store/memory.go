@@ -0,0 +1,995 @@
+package store
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/meynay/BookStore/functions"
+	"github.com/meynay/BookStore/images"
+	"github.com/meynay/BookStore/models"
+)
+
+type memBorrow struct {
+	uid      int
+	bid      int
+	returned bool
+	at       time.Time
+}
+
+type memInvoice struct {
+	uid   int
+	open  bool
+	date  time.Time
+	books []int
+}
+
+type memRating struct {
+	rating int
+	review string
+	at     time.Time
+}
+
+type memComment struct {
+	uid    int
+	bid    int
+	review string
+}
+
+type memory struct {
+	mu       sync.Mutex
+	books    map[int]models.Book
+	authors  map[int]models.Author
+	works    map[int]string
+	series   map[int]models.Series
+	newbooks map[int]time.Time
+	users    map[int]models.User
+	faves    map[int]map[int]bool
+	reads    map[int][]int
+	borrows  []memBorrow
+	invoices map[int]*memInvoice
+	ratings  map[int]map[int]memRating
+	comments []memComment
+	audit    []models.AuditEntry
+	sessions []models.Session
+	refresh  map[string]memRefresh
+	totp     map[int]memTwoFactor
+	resets   map[string]memReset
+	clients  []models.ApiClient
+}
+
+// NewMemory returns stores backed by process memory, meant for tests and
+// local runs without a database.
+func NewMemory() Stores {
+	m := &memory{
+		books:    make(map[int]models.Book),
+		authors:  make(map[int]models.Author),
+		works:    make(map[int]string),
+		series:   make(map[int]models.Series),
+		newbooks: make(map[int]time.Time),
+		users:    make(map[int]models.User),
+		faves:    make(map[int]map[int]bool),
+		reads:    make(map[int][]int),
+		invoices: make(map[int]*memInvoice),
+		ratings:  make(map[int]map[int]memRating),
+		refresh:  make(map[string]memRefresh),
+		totp:     make(map[int]memTwoFactor),
+		resets:   make(map[string]memReset),
+	}
+	return Stores{
+		Books:     &memBooks{m},
+		Authors:   &memAuthors{m},
+		Series:    &memSeries{m},
+		Users:     &memUsers{m},
+		Borrows:   &memBorrows{m},
+		Invoices:  &memInvoices{m},
+		Ratings:   &memRatings{m},
+		Audit:     &memAudit{m},
+		Sessions:  &memSessions{m},
+		TwoFactor: &memTwoFactors{m},
+		Resets:    &memResets{m},
+		Clients:   &memClients{m},
+	}
+}
+
+func lowBook(book models.Book) models.LowBook {
+	return models.LowBook{
+		Title:    book.Title,
+		Id:       book.Id,
+		Price:    book.Price,
+		ImageUrl: images.CardURL(book.ImageUrl),
+		Rate:     book.AverageRate,
+		Count:    book.RateCount,
+	}
+}
+
+func nextId[T any](m map[int]T) int {
+	id := 0
+	for k := range m {
+		if k > id {
+			id = k
+		}
+	}
+	return id + 1
+}
+
+func (m *memory) lowBooks(ids []int) []models.LowBook {
+	books := []models.LowBook{}
+	for _, id := range ids {
+		if book, ok := m.books[id]; ok {
+			books = append(books, lowBook(book))
+		}
+	}
+	return books
+}
+
+func (m *memory) name(uid int) string {
+	user := m.users[uid]
+	return user.Firstname + " " + user.Lastname
+}
+
+type memBooks struct {
+	*memory
+}
+
+func (s *memBooks) List(page models.Page) (models.BookPage, error) {
+	spec, err := parsePage(page, SortShuffle)
+	if err != nil {
+		return models.BookPage{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	books := []models.Book{}
+	for _, book := range s.books {
+		books = append(books, book)
+	}
+	return paginate(books, spec)
+}
+
+// sortValue returns the textual key used for ordering and cursors, and
+// whether it has to be compared numerically.
+func sortValue(spec pageSpec, book models.Book) (string, bool) {
+	switch spec.key {
+	case "title":
+		return book.Title, false
+	case "price":
+		return strconv.Itoa(book.Price), true
+	case "avg_rate":
+		return strconv.FormatFloat(book.AverageRate, 'g', -1, 64), true
+	case "rate_count":
+		return strconv.Itoa(book.RateCount), true
+	case "publication_date":
+		return book.PublicationDate.UTC().Format("2006-01-02T15:04:05.000000000"), false
+	case SortRelevance:
+		return strconv.FormatFloat(spec.ranks[book.Id], 'g', -1, 64), true
+	}
+	return shuffleKey(spec.seed, book.Id), false
+}
+
+func compareValues(a, b string, numeric bool) int {
+	if numeric {
+		x, _ := strconv.ParseFloat(a, 64)
+		y, _ := strconv.ParseFloat(b, 64)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a, b)
+}
+
+func paginate(books []models.Book, spec pageSpec) (models.BookPage, error) {
+	cmp := func(a models.Book, value string, id int) int {
+		av, numeric := sortValue(spec, a)
+		c := compareValues(av, value, numeric)
+		if c == 0 {
+			c = a.Id - id
+		}
+		if spec.desc {
+			c = -c
+		}
+		return c
+	}
+	sort.Slice(books, func(i, j int) bool {
+		v, _ := sortValue(spec, books[j])
+		return cmp(books[i], v, books[j].Id) < 0
+	})
+	result := models.BookPage{Books: []models.LowBook{}}
+	var last models.Book
+	for _, book := range books {
+		if spec.after != nil && cmp(book, spec.after.Value, spec.after.Id) <= 0 {
+			continue
+		}
+		if len(result.Books) == spec.limit {
+			v, _ := sortValue(spec, last)
+			result.NextCursor = spec.next(v, last.Id)
+			break
+		}
+		result.Books = append(result.Books, lowBook(book))
+		last = book
+	}
+	if spec.key == SortShuffle {
+		result.Seed = spec.seed
+	}
+	return result, nil
+}
+
+func (s *memBooks) ByIds(ids []int) ([]models.LowBook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lowBooks(ids), nil
+}
+
+func (s *memBooks) NewBooks(maxAge time.Duration) ([]models.LowBook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := []int{}
+	for id, t := range s.newbooks {
+		if time.Since(t) > maxAge {
+			delete(s.newbooks, id)
+		} else {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return s.lowBooks(ids), nil
+}
+
+func (s *memBooks) Get(id int) (models.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	book, ok := s.books[id]
+	if !ok {
+		return book, ErrNotFound
+	}
+	book.Genres = append([]string{}, book.Genres...)
+	book.Authors = append([]models.AuthorR{}, book.Authors...)
+	book.Series = s.series[book.SeriesId].Name
+	return book, nil
+}
+
+func (s *memBooks) Filter(filter models.Filter) (models.BookPage, error) {
+	terms := searchTerms(filter)
+	def := SortShuffle
+	if len(terms) > 0 {
+		def = SortRelevance
+	}
+	spec, err := parsePage(filter.Page, def)
+	if err != nil {
+		return models.BookPage{}, err
+	}
+	if spec.key == SortRelevance && len(terms) == 0 {
+		return models.BookPage{}, ErrInvalidPage
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	books, ranks := s.filtered(filter, terms)
+	spec.ranks = ranks
+	result, err := paginate(books, spec)
+	if spec.after == nil {
+		result.Facets = memFacets(books)
+	}
+	return result, err
+}
+
+func searchTerms(filter models.Filter) []string {
+	if isbn, ok := isbnLike(filter.Search); ok {
+		return []string{isbn}
+	}
+	return functions.SearchTerms(filter.Search)
+}
+
+// filtered returns the books matching filter with their search ranks. The
+// caller holds the lock.
+func (s *memBooks) filtered(filter models.Filter, terms []string) ([]models.Book, map[int]float64) {
+	ranks := make(map[int]float64)
+	books := []models.Book{}
+	for _, book := range s.books {
+		if !matches(book, filter) {
+			continue
+		}
+		if len(terms) > 0 {
+			rank, ok := searchRank(book, terms)
+			if !ok {
+				continue
+			}
+			ranks[book.Id] = rank
+		}
+		books = append(books, book)
+	}
+	if filter.CollapseEditions {
+		books = collapseEditions(books)
+	}
+	return books, ranks
+}
+
+func (s *memBooks) Count(filter models.Filter) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	books, _ := s.filtered(filter, searchTerms(filter))
+	return len(books), nil
+}
+
+func matches(book models.Book, filter models.Filter) bool {
+	year := book.PublicationDate.Year()
+	switch {
+	case filter.MinPages > 0 && book.NumberOfPages < filter.MinPages, filter.MaxPages > 0 && book.NumberOfPages > filter.MaxPages:
+		return false
+	case filter.StartDate > 0 && year < filter.StartDate, filter.EndDate > 0 && year > filter.EndDate:
+		return false
+	case filter.MinPrice > 0 && book.Price < filter.MinPrice, filter.MaxPrice > 0 && book.Price > filter.MaxPrice:
+		return false
+	case filter.MinRating > 0 && book.AverageRate < filter.MinRating:
+		return false
+	case len(filter.Formats) > 0 && !hasAny([]string{book.Format}, filter.Formats):
+		return false
+	case len(filter.Languages) > 0 && !hasAny([]string{book.Language}, filter.Languages):
+		return false
+	case len(filter.Genres) > 0 && !hasAny(book.Genres, filter.Genres):
+		return false
+	}
+	if len(filter.Authors) > 0 {
+		names := []string{}
+		for _, a := range book.Authors {
+			names = append(names, a.Author)
+		}
+		return hasAny(names, filter.Authors)
+	}
+	return true
+}
+
+func facetCounts(counts map[string]int) []models.FacetCount {
+	result := []models.FacetCount{}
+	for value, count := range counts {
+		result = append(result, models.FacetCount{Value: value, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Value < result[j].Value
+	})
+	if len(result) > FacetLimit {
+		result = result[:FacetLimit]
+	}
+	return result
+}
+
+func memFacets(books []models.Book) *models.Facets {
+	genres, formats, publishers := map[string]int{}, map[string]int{}, map[string]int{}
+	pages, prices := make([]int, len(PageBuckets)), make([]int, len(PriceBuckets))
+	for _, book := range books {
+		for _, g := range book.Genres {
+			genres[g]++
+		}
+		formats[book.Format]++
+		publishers[book.Publisher]++
+		if i := bucketOf(PageBuckets, book.NumberOfPages); i >= 0 {
+			pages[i]++
+		}
+		if i := bucketOf(PriceBuckets, book.Price); i >= 0 {
+			prices[i]++
+		}
+	}
+	return &models.Facets{
+		Genres:     facetCounts(genres),
+		Formats:    facetCounts(formats),
+		Publishers: facetCounts(publishers),
+		Pages:      ranges(PageBuckets, pages),
+		Prices:     ranges(PriceBuckets, prices),
+	}
+}
+
+// searchRank approximates ts_rank_cd with the default weights of the
+// Postgres search document: every term has to prefix-match some field.
+func searchRank(book models.Book, terms []string) (float64, bool) {
+	authors := []string{}
+	for _, a := range book.Authors {
+		authors = append(authors, a.Author)
+	}
+	isbns := strings.ToLower(strings.ReplaceAll(book.Isbn+" "+book.Isbn13, "-", ""))
+	fields := []struct {
+		words  []string
+		weight float64
+	}{
+		{functions.SearchTerms(book.Title), 1},
+		{strings.Fields(isbns), 1},
+		{functions.SearchTerms(strings.Join(authors, " ")), 0.4},
+		{functions.SearchTerms(book.Publisher), 0.2},
+		{functions.SearchTerms(book.Description), 0.1},
+	}
+	rank := 0.0
+	for _, term := range terms {
+		best := 0.0
+		for _, f := range fields {
+			for _, w := range f.words {
+				if strings.HasPrefix(w, term) && f.weight > best {
+					best = f.weight
+				}
+			}
+		}
+		if best == 0 {
+			return 0, false
+		}
+		rank += best
+	}
+	return rank, true
+}
+
+func (s *memBooks) Suggest(prefix string, limit int) ([]models.Suggestion, error) {
+	term := functions.NormalizePersian(strings.TrimSpace(prefix))
+	type scored struct {
+		models.Suggestion
+		score int
+	}
+	seen := make(map[string]bool)
+	candidates := []scored{}
+	add := func(kind string, id int, text string) {
+		key := kind + "\x00" + text
+		normalized := functions.NormalizePersian(text)
+		if seen[key] || !strings.Contains(normalized, term) {
+			return
+		}
+		seen[key] = true
+		score := 1
+		if strings.HasPrefix(normalized, term) {
+			score = 2
+		}
+		candidates = append(candidates, scored{models.Suggestion{Type: kind, Id: id, Text: text}, score})
+	}
+	s.mu.Lock()
+	for _, book := range s.books {
+		add("book", book.Id, book.Title)
+		add("publisher", 0, book.Publisher)
+		for _, a := range book.Authors {
+			add("author", 0, a.Author)
+		}
+		for _, g := range book.Genres {
+			add("genre", 0, g)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].Text < candidates[j].Text
+	})
+	suggestions := []models.Suggestion{}
+	for _, c := range candidates {
+		if len(suggestions) == limit {
+			break
+		}
+		suggestions = append(suggestions, c.Suggestion)
+	}
+	return suggestions, nil
+}
+
+func hasAny(have, want []string) bool {
+	for _, h := range have {
+		for _, w := range want {
+			if h == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *memBooks) Create(book *models.Book) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.isbnTaken(book.Isbn13, 0) {
+		return ErrDuplicateIsbn
+	}
+	s.insertBook(book)
+	return nil
+}
+
+// isbnTaken tells if a book other than id has the ISBN13, compared the way
+// the unique index in Postgres compares them.
+func (m *memory) isbnTaken(isbn13 string, id int) bool {
+	isbn := strings.ReplaceAll(isbn13, "-", "")
+	if isbn == "" {
+		return false
+	}
+	for other, book := range m.books {
+		if other != id && strings.ReplaceAll(book.Isbn13, "-", "") == isbn {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memory) insertBook(book *models.Book) {
+	book.Id = nextId(m.books)
+	for i, a := range book.Authors {
+		book.Authors[i].Id = m.authorId(a.Author)
+	}
+	book.WorkId, book.SeriesId, book.SeriesVolume = 0, 0, 0
+	book.UpdatedAt = time.Now()
+	m.books[book.Id] = *book
+	m.newbooks[book.Id] = time.Now()
+}
+
+func (s *memBooks) Update(book models.Book) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.books[book.Id]
+	if !ok {
+		return ErrNotFound
+	}
+	if s.isbnTaken(book.Isbn13, book.Id) {
+		return ErrDuplicateIsbn
+	}
+	book.Genres, book.Authors = old.Genres, old.Authors
+	book.AverageRate, book.RateCount = old.AverageRate, old.RateCount
+	book.WorkId, book.SeriesId, book.SeriesVolume = old.WorkId, old.SeriesId, old.SeriesVolume
+	book.UpdatedAt = time.Now()
+	s.books[book.Id] = book
+	return nil
+}
+
+func (s *memBooks) SaleQuantity(id int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	book, ok := s.books[id]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return book.QuantityForSale, nil
+}
+
+func (s *memBooks) SetSaleQuantity(id, quantity int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	book, ok := s.books[id]
+	if !ok {
+		return ErrNotFound
+	}
+	book.QuantityForSale = quantity
+	s.books[id] = book
+	return nil
+}
+
+func (s *memBooks) SetRating(id int, avg float64, count int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	book, ok := s.books[id]
+	if !ok {
+		return ErrNotFound
+	}
+	book.AverageRate, book.RateCount = avg, count
+	s.books[id] = book
+	return nil
+}
+
+func (s *memBooks) SetImage(id int, url string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	book, ok := s.books[id]
+	if !ok {
+		return ErrNotFound
+	}
+	book.ImageUrl = url
+	book.UpdatedAt = time.Now()
+	s.books[id] = book
+	return nil
+}
+
+type memUsers struct {
+	*memory
+}
+
+func (s *memUsers) Get(id int) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return user, ErrNotFound
+	}
+	return user, nil
+}
+
+func (s *memUsers) GetByEmail(email string) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return models.User{}, ErrNotFound
+}
+
+func (s *memUsers) Create(user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user.Id = nextId(s.users)
+	if user.Status == "" {
+		user.Status = models.UserActive
+	}
+	s.users[user.Id] = *user
+	return nil
+}
+
+func (s *memUsers) SetImage(id int, image string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Image = image
+	s.users[id] = user
+	return nil
+}
+
+func (s *memUsers) SetAvatarPublic(id int, public bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.AvatarPublic = public
+	s.users[id] = user
+	return nil
+}
+
+func (s *memUsers) ImageInUse(image string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.Image == image {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memUsers) SetRole(id int, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Role = role
+	s.users[id] = user
+	return nil
+}
+
+func (s *memUsers) SetPassword(email, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, user := range s.users {
+		if user.Email == email {
+			user.Password = hash
+			user.MustResetPassword = false
+			s.users[id] = user
+		}
+	}
+	return nil
+}
+
+func (s *memUsers) IsFaved(uid, bid int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.faves[uid][bid], nil
+}
+
+func (s *memUsers) AddFave(uid, bid int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.faves[uid] == nil {
+		s.faves[uid] = make(map[int]bool)
+	}
+	s.faves[uid][bid] = true
+	return nil
+}
+
+func (s *memUsers) RemoveFave(uid, bid int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.faves[uid], bid)
+	return nil
+}
+
+func (s *memUsers) Faves(uid int) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := []int{}
+	for bid := range s.faves[uid] {
+		ids = append(ids, bid)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (s *memUsers) AddRead(uid, bid int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reads[uid] = append(s.reads[uid], bid)
+	return nil
+}
+
+func (s *memUsers) Reads(uid int) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int{}, s.reads[uid]...), nil
+}
+
+type memBorrows struct {
+	*memory
+}
+
+func (s *memBorrows) IsBorrowed(bid int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range s.borrows {
+		if b.bid == bid && !b.returned {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memBorrows) HasActive(uid int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range s.borrows {
+		if b.uid == uid && !b.returned {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memBorrows) Borrow(uid, bid int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.borrows = append(s.borrows, memBorrow{uid: uid, bid: bid, at: at})
+	return nil
+}
+
+func (s *memBorrows) Return(bid int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := false
+	for i := range s.borrows {
+		if s.borrows[i].bid == bid && !s.borrows[i].returned {
+			s.borrows[i].returned = true
+			found = true
+		}
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *memBorrows) History(uid int) ([]models.LowBook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := []int{}
+	for _, b := range s.borrows {
+		if b.uid == uid {
+			ids = append(ids, b.bid)
+		}
+	}
+	return s.lowBooks(ids), nil
+}
+
+func (s *memBorrows) Active() ([]models.LowBook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := []int{}
+	for _, b := range s.borrows {
+		if !b.returned {
+			ids = append(ids, b.bid)
+		}
+	}
+	return s.lowBooks(ids), nil
+}
+
+type memInvoices struct {
+	*memory
+}
+
+func (s *memInvoices) open(uid int) (int, bool) {
+	for iid, invoice := range s.invoices {
+		if invoice.uid == uid && invoice.open {
+			return iid, true
+		}
+	}
+	return 0, false
+}
+
+func (s *memInvoices) Open(uid int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	iid, ok := s.open(uid)
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return iid, nil
+}
+
+func (s *memInvoices) CreateOpen(uid int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	iid := nextId(s.invoices)
+	s.invoices[iid] = &memInvoice{uid: uid, open: true, date: time.Now()}
+	return iid, nil
+}
+
+func (s *memInvoices) AddBook(iid, bid int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	invoice, ok := s.invoices[iid]
+	if !ok {
+		return ErrNotFound
+	}
+	invoice.books = append(invoice.books, bid)
+	return nil
+}
+
+func (s *memInvoices) RemoveBook(iid, bid int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	invoice, ok := s.invoices[iid]
+	if !ok {
+		return ErrNotFound
+	}
+	books := []int{}
+	for _, b := range invoice.books {
+		if b != bid {
+			books = append(books, b)
+		}
+	}
+	invoice.books = books
+	return nil
+}
+
+func (s *memInvoices) InOpen(uid, bid int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	iid, ok := s.open(uid)
+	if !ok {
+		return false, nil
+	}
+	for _, b := range s.invoices[iid].books {
+		if b == bid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memInvoices) OpenBooks(uid int) ([]models.LowBook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	iid, ok := s.open(uid)
+	if !ok {
+		return []models.LowBook{}, nil
+	}
+	return s.lowBooks(s.invoices[iid].books), nil
+}
+
+func (s *memInvoices) Books(iid int) ([]models.LowBook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	invoice, ok := s.invoices[iid]
+	if !ok {
+		return []models.LowBook{}, nil
+	}
+	return s.lowBooks(invoice.books), nil
+}
+
+func (s *memInvoices) Close(iid int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	invoice, ok := s.invoices[iid]
+	if !ok {
+		return ErrNotFound
+	}
+	invoice.open = false
+	invoice.date = at
+	return nil
+}
+
+func (s *memInvoices) closed(match func(uid int) bool, withName bool) []models.Invoice {
+	ids := []int{}
+	for iid, invoice := range s.invoices {
+		if !invoice.open && match(invoice.uid) {
+			ids = append(ids, iid)
+		}
+	}
+	sort.Ints(ids)
+	invoices := []models.Invoice{}
+	for _, iid := range ids {
+		invoice := models.Invoice{InvoiceID: iid, PurchaseDate: s.invoices[iid].date}
+		if withName {
+			invoice.Name = s.name(s.invoices[iid].uid)
+		}
+		invoices = append(invoices, invoice)
+	}
+	return invoices
+}
+
+func (s *memInvoices) History(uid int) ([]models.Invoice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed(func(u int) bool { return u == uid }, false), nil
+}
+
+func (s *memInvoices) AllClosed() ([]models.Invoice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed(func(int) bool { return true }, true), nil
+}
+
+type memRatings struct {
+	*memory
+}
+
+func (s *memRatings) Rating(uid, bid int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.ratings[bid][uid]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return r.rating, nil
+}
+
+func (s *memRatings) SaveRating(uid int, rate models.Rate, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ratings[rate.Bid] == nil {
+		s.ratings[rate.Bid] = make(map[int]memRating)
+	}
+	s.ratings[rate.Bid][uid] = memRating{rating: rate.Rating, review: rate.Review, at: at}
+	return nil
+}
+
+func (s *memRatings) Ratings(bid int) ([]models.UserComment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uids := []int{}
+	for uid := range s.ratings[bid] {
+		uids = append(uids, uid)
+	}
+	sort.Ints(uids)
+	comments := []models.UserComment{}
+	for _, uid := range uids {
+		r := s.ratings[bid][uid]
+		comments = append(comments, models.UserComment{Name: s.name(uid), Rate: r.rating, Comment: r.review})
+	}
+	return comments, nil
+}
+
+func (s *memRatings) AddComment(uid int, rate models.Rate, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.comments = append(s.comments, memComment{uid: uid, bid: rate.Bid, review: rate.Review})
+	return nil
+}
+
+func (s *memRatings) Comments(bid int) ([]models.UserComment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	comments := []models.UserComment{}
+	for _, c := range s.comments {
+		if c.bid == bid {
+			comments = append(comments, models.UserComment{Name: s.name(c.uid), Comment: c.review})
+		}
+	}
+	return comments, nil
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"time"
+
+	"github.com/meynay/BookStore/models"
+)
+
+type memAudit struct {
+	*memory
+}
+
+func (s *memAudit) Record(entry *models.AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry.Id = len(s.audit) + 1
+	entry.CreatedAt = time.Now()
+	if entry.Diff == nil {
+		entry.Diff = map[string]models.AuditChange{}
+	}
+	s.audit = append(s.audit, *entry)
+	return nil
+}
+
+func auditMatches(filter models.AuditFilter, entry models.AuditEntry) bool {
+	return (filter.ActorId == 0 || entry.ActorId == filter.ActorId) &&
+		(filter.Action == "" || entry.Action == filter.Action) &&
+		(filter.TargetType == "" || entry.TargetType == filter.TargetType) &&
+		(filter.TargetId == "" || entry.TargetId == filter.TargetId) &&
+		(filter.From.IsZero() || !entry.CreatedAt.Before(filter.From)) &&
+		(filter.Until.IsZero() || entry.CreatedAt.Before(filter.Until))
+}
+
+func (s *memAudit) List(filter models.AuditFilter) (models.AuditPage, error) {
+	spec, err := parseKeyset(filter.Page, "audit_id")
+	if err != nil {
+		return models.AuditPage{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := models.AuditPage{Entries: []models.AuditEntry{}}
+	for i := len(s.audit) - 1; i >= 0; i-- {
+		entry := s.audit[i]
+		if spec.after != nil && entry.Id >= spec.after.Id || !auditMatches(filter, entry) {
+			continue
+		}
+		if len(result.Entries) == spec.limit {
+			last := result.Entries[len(result.Entries)-1]
+			result.NextCursor = spec.next("", last.Id)
+			break
+		}
+		result.Entries = append(result.Entries, entry)
+	}
+	return result, nil
+}
+
+func (s *memAudit) Each(filter models.AuditFilter, fn func(entry models.AuditEntry) error) error {
+	s.mu.Lock()
+	entries := append([]models.AuditEntry{}, s.audit...)
+	s.mu.Unlock()
+	for _, entry := range entries {
+		if !auditMatches(filter, entry) {
+			continue
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
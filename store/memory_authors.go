@@ -0,0 +1,160 @@
+package store
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/meynay/BookStore/functions"
+	"github.com/meynay/BookStore/models"
+)
+
+// authorId finds an author by normalized name, creating it if needed.
+func (m *memory) authorId(name string) int {
+	key := functions.NormalizePersian(strings.TrimSpace(name))
+	ids := []int{}
+	for id, author := range m.authors {
+		if functions.NormalizePersian(strings.TrimSpace(author.Name)) == key {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > 0 {
+		sort.Ints(ids)
+		return ids[0]
+	}
+	id := nextId(m.authors)
+	m.authors[id] = models.Author{Id: id, Name: strings.TrimSpace(name)}
+	return id
+}
+
+type memAuthors struct {
+	*memory
+}
+
+func (s *memAuthors) List(search string, page models.Page) (models.AuthorPage, error) {
+	spec, err := parseKeyset(page, "name")
+	if err != nil {
+		return models.AuthorPage{}, err
+	}
+	term := functions.NormalizePersian(strings.TrimSpace(search))
+	s.mu.Lock()
+	authors := []models.Author{}
+	for _, author := range s.authors {
+		if strings.Contains(functions.NormalizePersian(author.Name), term) {
+			authors = append(authors, author)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(authors, func(i, j int) bool {
+		if authors[i].Name != authors[j].Name {
+			return authors[i].Name < authors[j].Name
+		}
+		return authors[i].Id < authors[j].Id
+	})
+	result := models.AuthorPage{Authors: []models.Author{}}
+	for _, author := range authors {
+		if spec.after != nil && (author.Name < spec.after.Value || author.Name == spec.after.Value && author.Id <= spec.after.Id) {
+			continue
+		}
+		if len(result.Authors) == spec.limit {
+			last := result.Authors[len(result.Authors)-1]
+			result.NextCursor = spec.next(last.Name, last.Id)
+			break
+		}
+		result.Authors = append(result.Authors, author)
+	}
+	return result, nil
+}
+
+func (s *memAuthors) Get(id int) (models.Author, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	author, ok := s.authors[id]
+	if !ok {
+		return author, ErrNotFound
+	}
+	author.Books = make(map[string][]models.LowBook)
+	ids := []int{}
+	for bid := range s.books {
+		ids = append(ids, bid)
+	}
+	sort.Ints(ids)
+	for _, bid := range ids {
+		for _, a := range s.books[bid].Authors {
+			if a.Id != id {
+				continue
+			}
+			role := a.Role
+			if role == "" {
+				role = "author"
+			}
+			author.Books[role] = append(author.Books[role], lowBook(s.books[bid]))
+		}
+	}
+	return author, nil
+}
+
+func (s *memAuthors) Update(author models.Author) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.authors[author.Id]; !ok {
+		return ErrNotFound
+	}
+	author.Name = strings.TrimSpace(author.Name)
+	author.Books = nil
+	s.authors[author.Id] = author
+	for bid, book := range s.books {
+		for i, a := range book.Authors {
+			if a.Id == author.Id {
+				book.Authors[i].Author = author.Name
+				book.UpdatedAt = time.Now()
+			}
+		}
+		s.books[bid] = book
+	}
+	return nil
+}
+
+func (s *memAuthors) Merge(target int, duplicates []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	canonical, ok := s.authors[target]
+	if !ok {
+		return ErrNotFound
+	}
+	dup := make(map[int]bool)
+	for _, id := range duplicates {
+		author, ok := s.authors[id]
+		if !ok || id == target {
+			return ErrNotFound
+		}
+		if canonical.Bio == "" {
+			canonical.Bio = author.Bio
+		}
+		if canonical.PhotoUrl == "" {
+			canonical.PhotoUrl = author.PhotoUrl
+		}
+		dup[id] = true
+	}
+	for bid, book := range s.books {
+		authors := []models.AuthorR{}
+		seen := make(map[models.AuthorR]bool)
+		for _, a := range book.Authors {
+			if dup[a.Id] {
+				a.Id, a.Author = target, canonical.Name
+				book.UpdatedAt = time.Now()
+			}
+			if !seen[a] {
+				seen[a] = true
+				authors = append(authors, a)
+			}
+		}
+		book.Authors = authors
+		s.books[bid] = book
+	}
+	for id := range dup {
+		delete(s.authors, id)
+	}
+	s.authors[target] = canonical
+	return nil
+}
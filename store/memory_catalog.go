@@ -0,0 +1,148 @@
+package store
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/meynay/BookStore/models"
+)
+
+func (s *memBooks) Import(books []models.Book, dryRun bool) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byIsbn := make(map[string]int)
+	for id, book := range s.books {
+		isbn := strings.ReplaceAll(book.Isbn13, "-", "")
+		if old, ok := byIsbn[isbn]; !ok || id < old {
+			byIsbn[isbn] = id
+		}
+	}
+	statuses := make([]string, len(books))
+	for i := range books {
+		book := &books[i]
+		id, ok := byIsbn[book.Isbn13]
+		if !ok {
+			statuses[i] = models.ImportCreated
+			if dryRun {
+				byIsbn[book.Isbn13] = -1
+				continue
+			}
+			s.insertBook(book)
+			byIsbn[book.Isbn13] = book.Id
+			continue
+		}
+		statuses[i] = models.ImportUpdated
+		if dryRun {
+			continue
+		}
+		old := s.books[id]
+		book.Id = id
+		if book.ImageUrl == "" {
+			book.ImageUrl = old.ImageUrl
+		}
+		if book.QuantityForSale == 0 {
+			book.QuantityForSale = old.QuantityForSale
+		}
+		if book.QuantityInLib == 0 {
+			book.QuantityInLib = old.QuantityInLib
+		}
+		if len(book.Genres) == 0 {
+			book.Genres = old.Genres
+		}
+		if len(book.Authors) == 0 {
+			book.Authors = old.Authors
+		}
+		for j, a := range book.Authors {
+			book.Authors[j].Id = s.authorId(a.Author)
+		}
+		book.AverageRate, book.RateCount = old.AverageRate, old.RateCount
+		book.WorkId, book.SeriesId, book.SeriesVolume = old.WorkId, old.SeriesId, old.SeriesVolume
+		book.UpdatedAt = time.Now()
+		s.books[id] = *book
+	}
+	return statuses, nil
+}
+
+func (s *memBooks) Each(fn func(book models.Book) error) error {
+	s.mu.Lock()
+	ids := []int{}
+	for id := range s.books {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+	sort.Ints(ids)
+	for _, id := range ids {
+		book, err := s.Get(id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(book); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memBooks) Details(ids []int) ([]models.Book, error) {
+	books := []models.Book{}
+	for _, id := range ids {
+		book, err := s.Get(id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+	}
+	return books, nil
+}
+
+func (s *memBooks) Genres() ([]models.FacetCount, error) {
+	s.mu.Lock()
+	counts := make(map[string]int)
+	for _, book := range s.books {
+		for _, genre := range book.Genres {
+			counts[genre]++
+		}
+	}
+	s.mu.Unlock()
+	genres := []models.FacetCount{}
+	for genre, count := range counts {
+		genres = append(genres, models.FacetCount{Value: genre, Count: count})
+	}
+	sort.Slice(genres, func(i, j int) bool { return genres[i].Value < genres[j].Value })
+	return genres, nil
+}
+
+func (s *memBooks) Changes(from, until, afterTime time.Time, afterId, limit int) ([]models.Book, error) {
+	s.mu.Lock()
+	ids := []int{}
+	for id, book := range s.books {
+		at := book.UpdatedAt
+		switch {
+		case !from.IsZero() && at.Before(from), !until.IsZero() && at.After(until):
+			continue
+		case afterId != 0 && (at.Before(afterTime) || at.Equal(afterTime) && id <= afterId):
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := s.books[ids[i]], s.books[ids[j]]
+		if !a.UpdatedAt.Equal(b.UpdatedAt) {
+			return a.UpdatedAt.Before(b.UpdatedAt)
+		}
+		return a.Id < b.Id
+	})
+	s.mu.Unlock()
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return s.Details(ids)
+}
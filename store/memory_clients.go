@@ -0,0 +1,61 @@
+package store
+
+import (
+	"slices"
+	"time"
+
+	"github.com/meynay/BookStore/models"
+)
+
+type memClients struct {
+	*memory
+}
+
+func (s *memClients) Create(client *models.ApiClient) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client.Id = len(s.clients) + 1
+	client.CreatedAt = time.Now()
+	client.LastUsedAt, client.RevokedAt = nil, nil
+	client.Scopes = slices.Clone(client.Scopes)
+	s.clients = append(s.clients, *client)
+	return nil
+}
+
+func (s *memClients) ByHash(hash string) (models.ApiClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, client := range s.clients {
+		if client.Hash == hash && client.RevokedAt == nil {
+			return client, nil
+		}
+	}
+	return models.ApiClient{}, ErrNotFound
+}
+
+func (s *memClients) List() ([]models.ApiClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.clients), nil
+}
+
+func (s *memClients) Revoke(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id < 1 || id > len(s.clients) || s.clients[id-1].RevokedAt != nil {
+		return ErrNotFound
+	}
+	now := time.Now()
+	s.clients[id-1].RevokedAt = &now
+	return nil
+}
+
+func (s *memClients) Touch(id int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id < 1 || id > len(s.clients) {
+		return ErrNotFound
+	}
+	s.clients[id-1].LastUsedAt = &at
+	return nil
+}
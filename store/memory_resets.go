@@ -0,0 +1,46 @@
+package store
+
+import "time"
+
+type memReset struct {
+	uid     int
+	expires time.Time
+}
+
+type memResets struct {
+	*memory
+}
+
+func (s *memResets) Create(uid int, hash string, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resets[hash] = memReset{uid: uid, expires: expires}
+	return nil
+}
+
+func (s *memResets) Lookup(hash string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reset, ok := s.resets[hash]
+	if !ok || !reset.expires.After(time.Now()) {
+		return 0, ErrNotFound
+	}
+	return reset.uid, nil
+}
+
+func (s *memResets) Consume(hash string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	reset, ok := s.resets[hash]
+	if !ok || !reset.expires.After(now) {
+		return 0, ErrNotFound
+	}
+	// deleting is using up here; expired tokens go along the way
+	for key, other := range s.resets {
+		if other.uid == reset.uid || !other.expires.After(now) {
+			delete(s.resets, key)
+		}
+	}
+	return reset.uid, nil
+}
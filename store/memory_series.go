@@ -0,0 +1,217 @@
+package store
+
+import (
+	"sort"
+	"time"
+
+	"github.com/meynay/BookStore/images"
+	"github.com/meynay/BookStore/models"
+)
+
+// collapseEditions keeps the lowest id book of every work.
+func collapseEditions(books []models.Book) []models.Book {
+	first := make(map[int]int)
+	for _, book := range books {
+		if book.WorkId == 0 {
+			continue
+		}
+		if id, ok := first[book.WorkId]; !ok || book.Id < id {
+			first[book.WorkId] = book.Id
+		}
+	}
+	collapsed := []models.Book{}
+	for _, book := range books {
+		if book.WorkId == 0 || first[book.WorkId] == book.Id {
+			collapsed = append(collapsed, book)
+		}
+	}
+	return collapsed
+}
+
+func (s *memBooks) GroupEditions(ids []int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	works := []int{}
+	for _, id := range ids {
+		book, ok := s.books[id]
+		if !ok {
+			return 0, ErrNotFound
+		}
+		if book.WorkId != 0 {
+			works = append(works, book.WorkId)
+		}
+	}
+	var wid int
+	if len(works) > 0 {
+		sort.Ints(works)
+		wid = works[0]
+	} else {
+		wid = nextId(s.works)
+		s.works[wid] = s.books[ids[0]].Title
+	}
+	merged := make(map[int]bool)
+	for _, id := range works {
+		merged[id] = true
+	}
+	for _, id := range ids {
+		book := s.books[id]
+		book.WorkId = wid
+		s.books[id] = book
+	}
+	for id, book := range s.books {
+		if merged[book.WorkId] {
+			book.WorkId = wid
+			s.books[id] = book
+		}
+	}
+	for id := range merged {
+		if id != wid {
+			delete(s.works, id)
+		}
+	}
+	return wid, nil
+}
+
+func (s *memBooks) Ungroup(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	book, ok := s.books[id]
+	if !ok {
+		return ErrNotFound
+	}
+	wid := book.WorkId
+	if wid == 0 {
+		return nil
+	}
+	book.WorkId = 0
+	s.books[id] = book
+	for _, other := range s.books {
+		if other.WorkId == wid {
+			return nil
+		}
+	}
+	delete(s.works, wid)
+	return nil
+}
+
+func (s *memBooks) Editions(id int) ([]models.Edition, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	editions := []models.Edition{}
+	book, ok := s.books[id]
+	if !ok || book.WorkId == 0 {
+		return editions, nil
+	}
+	others := []models.Book{}
+	for _, other := range s.books {
+		if other.WorkId == book.WorkId && other.Id != id {
+			others = append(others, other)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		if !others[i].PublicationDate.Equal(others[j].PublicationDate) {
+			return others[i].PublicationDate.Before(others[j].PublicationDate)
+		}
+		return others[i].Id < others[j].Id
+	})
+	for _, other := range others {
+		editions = append(editions, models.Edition{Id: other.Id, Title: other.Title, Format: other.Format, Isbn13: other.Isbn13, Price: other.Price, ImageUrl: images.CardURL(other.ImageUrl)})
+	}
+	return editions, nil
+}
+
+type memSeries struct {
+	*memory
+}
+
+func (s *memSeries) Create(series *models.Series) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	series.Id = nextId(s.series)
+	s.series[series.Id] = models.Series{Id: series.Id, Name: series.Name, Description: series.Description}
+	return nil
+}
+
+func (s *memSeries) Get(id int) (models.Series, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	series, ok := s.series[id]
+	if !ok {
+		return series, ErrNotFound
+	}
+	series.Books = []models.SeriesBook{}
+	for _, book := range s.books {
+		if book.SeriesId == id {
+			series.Books = append(series.Books, models.SeriesBook{Volume: book.SeriesVolume, LowBook: lowBook(book)})
+		}
+	}
+	// books without a volume go last, like NULLS LAST in Postgres
+	sort.Slice(series.Books, func(i, j int) bool {
+		a, b := series.Books[i], series.Books[j]
+		if (a.Volume == 0) != (b.Volume == 0) {
+			return b.Volume == 0
+		}
+		if a.Volume != b.Volume {
+			return a.Volume < b.Volume
+		}
+		return a.Id < b.Id
+	})
+	return series, nil
+}
+
+func (s *memSeries) SetVolumes(id int, volumes []models.SeriesVolume) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.series[id]; !ok {
+		return ErrNotFound
+	}
+	for _, volume := range volumes {
+		if _, ok := s.books[volume.BookId]; !ok {
+			return ErrNotFound
+		}
+	}
+	for _, volume := range volumes {
+		book := s.books[volume.BookId]
+		book.SeriesId, book.SeriesVolume = id, volume.Volume
+		book.UpdatedAt = time.Now()
+		s.books[volume.BookId] = book
+	}
+	return nil
+}
+
+func (s *memSeries) Remove(id, bid int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	book, ok := s.books[bid]
+	if !ok || book.SeriesId != id {
+		return ErrNotFound
+	}
+	book.SeriesId, book.SeriesVolume = 0, 0
+	book.UpdatedAt = time.Now()
+	s.books[bid] = book
+	return nil
+}
+
+func (s *memSeries) Next(bid int) (models.LowBook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	book, ok := s.books[bid]
+	if !ok || book.SeriesId == 0 || book.SeriesVolume == 0 {
+		return models.LowBook{}, ErrNotFound
+	}
+	var next *models.Book
+	for _, other := range s.books {
+		if other.SeriesId != book.SeriesId || other.SeriesVolume <= book.SeriesVolume {
+			continue
+		}
+		if next == nil || other.SeriesVolume < next.SeriesVolume ||
+			other.SeriesVolume == next.SeriesVolume && (other.Price < next.Price || other.Price == next.Price && other.Id < next.Id) {
+			o := other
+			next = &o
+		}
+	}
+	if next == nil {
+		return models.LowBook{}, ErrNotFound
+	}
+	return lowBook(*next), nil
+}
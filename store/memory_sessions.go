@@ -0,0 +1,115 @@
+package store
+
+import (
+	"sort"
+	"time"
+
+	"github.com/meynay/BookStore/models"
+)
+
+type memRefresh struct {
+	sid  int
+	used bool
+}
+
+type memSessions struct {
+	*memory
+}
+
+func (s *memSessions) Create(session *models.Session, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	session.Id = len(s.sessions) + 1
+	session.CreatedAt, session.LastUsedAt = now, now
+	session.RevokedAt = nil
+	s.sessions = append(s.sessions, *session)
+	s.refresh[hash] = memRefresh{sid: session.Id}
+	return nil
+}
+
+func (s *memSessions) Rotate(hash, next, ip, userAgent string, expires time.Time) (models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.refresh[hash]
+	if !ok {
+		return models.Session{}, ErrNotFound
+	}
+	now := time.Now()
+	session := &s.sessions[token.sid-1]
+	if token.used {
+		if session.RevokedAt == nil {
+			session.RevokedAt = &now
+		}
+		return *session, ErrTokenReused
+	}
+	if session.RevokedAt != nil || !session.ExpiresAt.After(now) {
+		return models.Session{}, ErrNotFound
+	}
+	s.refresh[hash] = memRefresh{sid: token.sid, used: true}
+	s.refresh[next] = memRefresh{sid: token.sid}
+	session.Ip, session.UserAgent, session.LastUsedAt, session.ExpiresAt = ip, userAgent, now, expires
+	return *session, nil
+}
+
+func (s *memSessions) ByRefresh(hash string) (models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.refresh[hash]
+	if !ok {
+		return models.Session{}, ErrNotFound
+	}
+	return s.sessions[token.sid-1], nil
+}
+
+func sessionActive(session models.Session, now time.Time) bool {
+	return session.RevokedAt == nil && session.ExpiresAt.After(now)
+}
+
+func (s *memSessions) Active(id int) (models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id < 1 || id > len(s.sessions) || !sessionActive(s.sessions[id-1], time.Now()) {
+		return models.Session{}, ErrNotFound
+	}
+	return s.sessions[id-1], nil
+}
+
+func (s *memSessions) List(uid int) ([]models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	sessions := []models.Session{}
+	for _, session := range s.sessions {
+		if session.Uid == uid && sessionActive(session, now) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+	return sessions, nil
+}
+
+func (s *memSessions) Revoke(uid, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if id < 1 || id > len(s.sessions) || s.sessions[id-1].Uid != uid || !sessionActive(s.sessions[id-1], now) {
+		return ErrNotFound
+	}
+	s.sessions[id-1].RevokedAt = &now
+	return nil
+}
+
+func (s *memSessions) RevokeAll(uid int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for i := range s.sessions {
+		if s.sessions[i].Uid == uid && s.sessions[i].RevokedAt == nil {
+			s.sessions[i].RevokedAt = &now
+		}
+	}
+	return nil
+}
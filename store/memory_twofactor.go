@@ -0,0 +1,104 @@
+package store
+
+import "github.com/meynay/BookStore/models"
+
+type memTwoFactor struct {
+	models.TwoFactor
+	codes map[string]bool
+}
+
+type memTwoFactors struct {
+	*memory
+}
+
+func (s *memTwoFactors) Get(uid int) (models.TwoFactor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tf, ok := s.totp[uid]
+	if !ok {
+		return models.TwoFactor{Uid: uid}, ErrNotFound
+	}
+	tf.RecoveryCodes = 0
+	for _, used := range tf.codes {
+		if !used {
+			tf.RecoveryCodes++
+		}
+	}
+	return tf.TwoFactor, nil
+}
+
+func (s *memTwoFactors) Begin(uid int, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.totp[uid] = memTwoFactor{TwoFactor: models.TwoFactor{Uid: uid, Secret: secret}}
+	return nil
+}
+
+func recoveryCodeSet(recoveryCodes []string) map[string]bool {
+	codes := make(map[string]bool)
+	for _, hash := range recoveryCodes {
+		codes[hash] = false
+	}
+	return codes
+}
+
+func (s *memTwoFactors) Enable(uid int, step int64, recoveryCodes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tf, ok := s.totp[uid]
+	if !ok {
+		return ErrNotFound
+	}
+	tf.Enabled, tf.LastStep = true, step
+	tf.codes = recoveryCodeSet(recoveryCodes)
+	s.totp[uid] = tf
+	return nil
+}
+
+func (s *memTwoFactors) Disable(uid int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.totp[uid]; !ok {
+		return ErrNotFound
+	}
+	delete(s.totp, uid)
+	return nil
+}
+
+func (s *memTwoFactors) SetRecoveryCodes(uid int, recoveryCodes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tf, ok := s.totp[uid]
+	if !ok {
+		return ErrNotFound
+	}
+	tf.codes = recoveryCodeSet(recoveryCodes)
+	s.totp[uid] = tf
+	return nil
+}
+
+func (s *memTwoFactors) UseStep(uid int, step int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tf, ok := s.totp[uid]
+	if !ok || step <= tf.LastStep {
+		return false, nil
+	}
+	tf.LastStep = step
+	s.totp[uid] = tf
+	return true, nil
+}
+
+func (s *memTwoFactors) UseRecoveryCode(uid int, hash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tf, ok := s.totp[uid]
+	if !ok {
+		return false, nil
+	}
+	if used, ok := tf.codes[hash]; !ok || used {
+		return false, nil
+	}
+	tf.codes[hash] = true
+	return true, nil
+}
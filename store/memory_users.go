@@ -0,0 +1,102 @@
+package store
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meynay/BookStore/models"
+)
+
+func (s *memUsers) List(filter models.UserFilter) (models.UserPage, error) {
+	spec, err := parseKeyset(filter.Page, "user_id")
+	if err != nil {
+		return models.UserPage{}, err
+	}
+	term := strings.ToLower(strings.TrimSpace(filter.Search))
+	s.mu.Lock()
+	users := []models.User{}
+	for _, user := range s.users {
+		name := strings.ToLower(user.Firstname + " " + user.Lastname)
+		if !strings.Contains(name, term) && !strings.Contains(strings.ToLower(user.Email), term) {
+			continue
+		}
+		if filter.Role != "" && user.Role != filter.Role || filter.Status != "" && user.Status != filter.Status {
+			continue
+		}
+		users = append(users, user)
+	}
+	s.mu.Unlock()
+	sort.Slice(users, func(i, j int) bool { return users[i].Id < users[j].Id })
+	result := models.UserPage{Users: []models.User{}}
+	for _, user := range users {
+		if spec.after != nil && user.Id <= spec.after.Id {
+			continue
+		}
+		if len(result.Users) == spec.limit {
+			last := result.Users[len(result.Users)-1]
+			result.NextCursor = spec.next(strconv.Itoa(last.Id), last.Id)
+			break
+		}
+		result.Users = append(result.Users, user)
+	}
+	return result, nil
+}
+
+func (s *memUsers) SetStatus(id int, status, reason string, until *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Status, user.StatusReason, user.SuspendedUntil = status, reason, until
+	s.users[id] = user
+	return nil
+}
+
+func (s *memUsers) ForcePasswordReset(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.MustResetPassword = true
+	s.users[id] = user
+	return nil
+}
+
+func (s *memUsers) SetEmailVerified(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.EmailVerified = true
+	s.users[id] = user
+	return nil
+}
+
+func (s *memRatings) ByUser(uid int) ([]models.Rate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bids := []int{}
+	for bid, ratings := range s.ratings {
+		if _, ok := ratings[uid]; ok {
+			bids = append(bids, bid)
+		}
+	}
+	// newest first, like the Postgres store
+	sort.Slice(bids, func(i, j int) bool {
+		return s.ratings[bids[i]][uid].at.After(s.ratings[bids[j]][uid].at)
+	})
+	rates := []models.Rate{}
+	for _, bid := range bids {
+		r := s.ratings[bid][uid]
+		rates = append(rates, models.Rate{Bid: bid, Rating: r.rating, Review: r.review})
+	}
+	return rates, nil
+}
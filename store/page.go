@@ -0,0 +1,142 @@
+package store
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/meynay/BookStore/models"
+)
+
+const (
+	DefaultLimit  = 20
+	MaxLimit      = 100
+	SortShuffle   = "shuffle"
+	SortRelevance = "relevance"
+)
+
+var ErrInvalidPage = errors.New("invalid sort or cursor")
+
+// sortColumns maps the public sort names to their column and the SQL type
+// used to cast cursor values back when resuming a page.
+var sortColumns = map[string][2]string{
+	"title":            {"title", "text"},
+	"price":            {"price", "integer"},
+	"avg_rate":         {"avg_rate", "double precision"},
+	"rate_count":       {"rate_count", "integer"},
+	"publication_date": {"publication_date", "timestamp"},
+}
+
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	Id    int    `json:"i"`
+	Seed  int64  `json:"r,omitempty"`
+}
+
+type pageSpec struct {
+	key   string
+	desc  bool
+	limit int
+	seed  int64
+	after *cursor
+	// ranks holds search relevance per book id for the in-memory store
+	ranks map[int]float64
+}
+
+// parsePage validates a page request. Sort names may be prefixed with "-"
+// for descending order; an empty sort falls back to def.
+func parsePage(page models.Page, def string) (pageSpec, error) {
+	spec := pageSpec{limit: page.Limit, seed: page.Seed}
+	if spec.limit <= 0 {
+		spec.limit = DefaultLimit
+	}
+	if spec.limit > MaxLimit {
+		spec.limit = MaxLimit
+	}
+	sort := page.Sort
+	if sort == "" {
+		sort = def
+	}
+	spec.desc = strings.HasPrefix(sort, "-")
+	spec.key = strings.TrimPrefix(sort, "-")
+	if _, ok := sortColumns[spec.key]; !ok && spec.key != SortShuffle && spec.key != SortRelevance {
+		return spec, ErrInvalidPage
+	}
+	// best matches always come first
+	if spec.key == SortRelevance {
+		spec.desc = true
+	}
+	if page.Cursor != "" {
+		c, err := decodeCursor(page.Cursor, spec.sortName())
+		if err != nil {
+			return spec, err
+		}
+		spec.after = c
+		spec.seed = c.Seed
+	}
+	if spec.key == SortShuffle && spec.seed == 0 {
+		spec.seed = rand.Int63()
+	}
+	return spec, nil
+}
+
+// parseKeyset validates a page over a fixed ordering, such as the author
+// list, where the client cannot choose the sort.
+func parseKeyset(page models.Page, key string) (pageSpec, error) {
+	spec := pageSpec{key: key, limit: page.Limit}
+	if spec.limit <= 0 {
+		spec.limit = DefaultLimit
+	}
+	if spec.limit > MaxLimit {
+		spec.limit = MaxLimit
+	}
+	if page.Cursor != "" {
+		c, err := decodeCursor(page.Cursor, key)
+		if err != nil {
+			return spec, err
+		}
+		spec.after = c
+	}
+	return spec, nil
+}
+
+func decodeCursor(s, sort string) (*cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidPage
+	}
+	var c cursor
+	if err := json.Unmarshal(raw, &c); err != nil || c.Sort != sort {
+		return nil, ErrInvalidPage
+	}
+	return &c, nil
+}
+
+func (spec pageSpec) sortName() string {
+	if spec.desc {
+		return "-" + spec.key
+	}
+	return spec.key
+}
+
+func (spec pageSpec) next(value string, id int) string {
+	c := cursor{Sort: spec.sortName(), Value: value, Id: id}
+	if spec.key == SortShuffle {
+		c.Seed = spec.seed
+	}
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// shuffleKey gives every book a stable pseudo-random position for a seed.
+// It must match the md5 expression used by the Postgres store.
+func shuffleKey(seed int64, id int) string {
+	sum := md5.Sum([]byte(fmt.Sprintf("%d:%d", seed, id)))
+	return hex.EncodeToString(sum[:])
+}
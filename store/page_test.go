@@ -0,0 +1,77 @@
+package store
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/meynay/BookStore/models"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		sort  string
+		value string
+		id    int
+	}{
+		{"title", "شاهنامه", 12},
+		{"-price", "150000", 3},
+		{"publication_date", "2001-03-04T00:00:00Z", 77},
+		{"shuffle", "9e107d9d372bb6826bd81d3542a419d6", 5},
+		{"relevance", "0.0759", 40},
+	}
+	for _, tt := range tests {
+		spec, err := parsePage(models.Page{Sort: tt.sort}, "title")
+		if err != nil {
+			t.Fatalf("parsePage(%q): %v", tt.sort, err)
+		}
+		next := spec.next(tt.value, tt.id)
+		resumed, err := parsePage(models.Page{Sort: tt.sort, Cursor: next}, "title")
+		if err != nil {
+			t.Fatalf("%s: resuming from %q: %v", tt.sort, next, err)
+		}
+		if resumed.after == nil || resumed.after.Value != tt.value || resumed.after.Id != tt.id {
+			t.Errorf("%s: resumed after %+v, want value %q id %d", tt.sort, resumed.after, tt.value, tt.id)
+		}
+		if resumed.seed != spec.seed {
+			t.Errorf("%s: seed %d changed to %d", tt.sort, spec.seed, resumed.seed)
+		}
+	}
+}
+
+func TestCursorRejected(t *testing.T) {
+	spec, _ := parsePage(models.Page{Sort: "price"}, "title")
+	priceCursor := spec.next("100", 1)
+	tests := []struct {
+		name   string
+		sort   string
+		cursor string
+	}{
+		{"not base64", "price", "***"},
+		{"not json", "price", base64.RawURLEncoding.EncodeToString([]byte("price:100"))},
+		{"padded", "price", priceCursor + "=="},
+		{"truncated", "price", priceCursor[:len(priceCursor)-4]},
+		{"other sort", "title", priceCursor},
+		{"other direction", "-price", priceCursor},
+		{"edited sort", "title", base64.RawURLEncoding.EncodeToString([]byte(`{"s":"price","v":"a","i":1}`))},
+		{"unknown sort", "isbn", priceCursor},
+	}
+	for _, tt := range tests {
+		_, err := parsePage(models.Page{Sort: tt.sort, Cursor: tt.cursor}, "title")
+		if !errors.Is(err, ErrInvalidPage) {
+			t.Errorf("%s: got %v, want ErrInvalidPage", tt.name, err)
+		}
+	}
+	if _, err := parseKeyset(models.Page{Cursor: priceCursor}, "name"); !errors.Is(err, ErrInvalidPage) {
+		t.Errorf("keyset with a book cursor: got %v, want ErrInvalidPage", err)
+	}
+}
+
+func TestPageLimits(t *testing.T) {
+	for limit, want := range map[int]int{0: DefaultLimit, -5: DefaultLimit, 7: 7, MaxLimit + 1: MaxLimit} {
+		spec, err := parsePage(models.Page{Limit: limit}, "title")
+		if err != nil || spec.limit != want {
+			t.Errorf("limit %d: got %d, %v; want %d", limit, spec.limit, err, want)
+		}
+	}
+}
@@ -0,0 +1,839 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/meynay/BookStore/functions"
+	"github.com/meynay/BookStore/images"
+	"github.com/meynay/BookStore/models"
+)
+
+const lowBookColumns = "book_id, title, image_url, price, avg_rate, rate_count"
+
+func NewPostgres(db *sql.DB) Stores {
+	return Stores{
+		Books:     &pgBooks{db: db},
+		Authors:   &pgAuthors{db: db},
+		Series:    &pgSeries{db: db},
+		Users:     &pgUsers{db: db},
+		Borrows:   &pgBorrows{db: db},
+		Invoices:  &pgInvoices{db: db},
+		Ratings:   &pgRatings{db: db},
+		Audit:     &pgAudit{db: db},
+		Sessions:  &pgSessions{db: db},
+		TwoFactor: &pgTwoFactor{db: db},
+		Resets:    &pgResets{db: db},
+		Clients:   &pgClients{db: db},
+	}
+}
+
+func scanLowBooks(rows *sql.Rows) ([]models.LowBook, error) {
+	defer rows.Close()
+	books := []models.LowBook{}
+	for rows.Next() {
+		var book models.LowBook
+		if err := rows.Scan(&book.Id, &book.Title, &book.ImageUrl, &book.Price, &book.Rate, &book.Count); err != nil {
+			return nil, err
+		}
+		book.ImageUrl = images.CardURL(book.ImageUrl)
+		books = append(books, book)
+	}
+	return books, rows.Err()
+}
+
+func scanIds(rows *sql.Rows) ([]int, error) {
+	defer rows.Close()
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+type query struct {
+	where []string
+	args  []interface{}
+	rank  string
+}
+
+// arg binds v as the next positional parameter and returns its placeholder.
+func (q *query) arg(v interface{}) string {
+	q.args = append(q.args, v)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+func exists(db *sql.DB, query string, args ...interface{}) (bool, error) {
+	var b bool
+	err := db.QueryRow(fmt.Sprintf("SELECT EXISTS(%s)", query), args...).Scan(&b)
+	return b, err
+}
+
+func placeholders(n, from int) string {
+	p := make([]string, n)
+	for i := range p {
+		p[i] = fmt.Sprintf("$%d", i+from)
+	}
+	return strings.Join(p, ", ")
+}
+
+type pgBooks struct {
+	db *sql.DB
+}
+
+func (s *pgBooks) List(page models.Page) (models.BookPage, error) {
+	spec, err := parsePage(page, SortShuffle)
+	if err != nil {
+		return models.BookPage{}, err
+	}
+	return s.page(&query{}, spec)
+}
+
+func (s *pgBooks) ByIds(ids []int) ([]models.LowBook, error) {
+	if len(ids) == 0 {
+		return []models.LowBook{}, nil
+	}
+	query := fmt.Sprintf("SELECT "+lowBookColumns+" FROM book WHERE book_id IN (%s)", placeholders(len(ids), 1))
+	rows, err := s.db.Query(query, functions.ConvertToInterfaceSlice(ids)...)
+	if err != nil {
+		return nil, err
+	}
+	return scanLowBooks(rows)
+}
+
+func (s *pgBooks) NewBooks(maxAge time.Duration) ([]models.LowBook, error) {
+	if _, err := s.db.Exec("DELETE FROM newbook WHERE time_added < $1", time.Now().Add(-maxAge)); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query("SELECT " + lowBookColumns + " FROM book WHERE book_id IN (SELECT book_id FROM newbook)")
+	if err != nil {
+		return nil, err
+	}
+	return scanLowBooks(rows)
+}
+
+func (s *pgBooks) Get(id int) (models.Book, error) {
+	var book models.Book
+	err := s.db.QueryRow("SELECT book_id, title, isbn, image_url, publication_date, isbn13, num_pages, publisher, book_format, language, description, price, quantity_sale, quantity_lib, avg_rate, rate_count, COALESCE(work_id, 0), COALESCE(series_id, 0), COALESCE((SELECT name FROM series WHERE series.series_id = book.series_id), ''), COALESCE(series_volume, 0), updated_at FROM book WHERE book_id = $1", id).
+		Scan(&book.Id, &book.Title, &book.Isbn, &book.ImageUrl, &book.PublicationDate, &book.Isbn13, &book.NumberOfPages, &book.Publisher, &book.Format, &book.Language, &book.Description, &book.Price, &book.QuantityForSale, &book.QuantityInLib, &book.AverageRate, &book.RateCount, &book.WorkId, &book.SeriesId, &book.Series, &book.SeriesVolume, &book.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return book, ErrNotFound
+	}
+	if err != nil {
+		return book, err
+	}
+	book.Genres = []string{}
+	rows, err := s.db.Query("SELECT genre FROM book_genre WHERE book_id=$1", id)
+	if err != nil {
+		return book, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var g string
+		if err := rows.Scan(&g); err != nil {
+			return book, err
+		}
+		book.Genres = append(book.Genres, g)
+	}
+	book.Authors = []models.AuthorR{}
+	rows, err = s.db.Query("SELECT authors.author_id, authors.name, COALESCE(book_author.role, '') FROM book_author INNER JOIN authors ON authors.author_id = book_author.author_id WHERE book_author.book_id=$1", id)
+	if err != nil {
+		return book, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var author models.AuthorR
+		if err := rows.Scan(&author.Id, &author.Author, &author.Role); err != nil {
+			return book, err
+		}
+		book.Authors = append(book.Authors, author)
+	}
+	return book, rows.Err()
+}
+
+func (s *pgBooks) Filter(filter models.Filter) (models.BookPage, error) {
+	q, terms := filterQuery(filter)
+	def := SortShuffle
+	if terms != "" {
+		def = SortRelevance
+	}
+	spec, err := parsePage(filter.Page, def)
+	if err != nil {
+		return models.BookPage{}, err
+	}
+	if spec.key == SortRelevance && terms == "" {
+		return models.BookPage{}, ErrInvalidPage
+	}
+	var facets *models.Facets
+	if spec.after == nil {
+		if facets, err = s.facets(q); err != nil {
+			return models.BookPage{}, err
+		}
+	}
+	result, err := s.page(q, spec)
+	result.Facets = facets
+	return result, err
+}
+
+func filterQuery(filter models.Filter) (*query, string) {
+	q := &query{}
+	terms := searchQuery(filter.Search)
+	if terms != "" {
+		tsquery := fmt.Sprintf("to_tsquery('simple', %s)", q.arg(terms))
+		q.where = append(q.where, "search_vector @@ "+tsquery)
+		q.rank = fmt.Sprintf("ts_rank_cd(search_vector, %s)", tsquery)
+	}
+	if filter.MinPages > 0 {
+		q.where = append(q.where, "num_pages >= "+q.arg(filter.MinPages))
+	}
+	if filter.MaxPages > 0 {
+		q.where = append(q.where, "num_pages <= "+q.arg(filter.MaxPages))
+	}
+	if filter.StartDate > 0 {
+		q.where = append(q.where, "publication_date >= "+q.arg(time.Date(filter.StartDate, 1, 1, 0, 0, 0, 0, time.UTC)))
+	}
+	if filter.EndDate > 0 {
+		q.where = append(q.where, "publication_date <= "+q.arg(time.Date(filter.EndDate, 12, 31, 23, 59, 59, 0, time.UTC)))
+	}
+	if filter.MinPrice > 0 {
+		q.where = append(q.where, "price >= "+q.arg(filter.MinPrice))
+	}
+	if filter.MaxPrice > 0 {
+		q.where = append(q.where, "price <= "+q.arg(filter.MaxPrice))
+	}
+	if filter.MinRating > 0 {
+		q.where = append(q.where, "avg_rate >= "+q.arg(filter.MinRating))
+	}
+	if len(filter.Formats) > 0 {
+		q.where = append(q.where, fmt.Sprintf("book_format = ANY(%s)", q.arg(pq.Array(filter.Formats))))
+	}
+	if len(filter.Languages) > 0 {
+		q.where = append(q.where, fmt.Sprintf("language = ANY(%s)", q.arg(pq.Array(filter.Languages))))
+	}
+	if len(filter.Genres) > 0 {
+		q.where = append(q.where, fmt.Sprintf("book_id IN (SELECT book_id FROM book_genre WHERE genre = ANY(%s))", q.arg(pq.Array(filter.Genres))))
+	}
+	if len(filter.Authors) > 0 {
+		q.where = append(q.where, fmt.Sprintf("book_id IN (SELECT book_id FROM book_author INNER JOIN authors ON authors.author_id = book_author.author_id WHERE authors.name = ANY(%s))", q.arg(pq.Array(filter.Authors))))
+	}
+	if filter.CollapseEditions {
+		// keep the lowest id edition of every work among the ones that match;
+		// unqualified columns in the conditions resolve to "other" here
+		conditions := append([]string{"other.work_id = book.work_id", "other.book_id < book.book_id"}, q.where...)
+		q.where = append(q.where, "(work_id IS NULL OR NOT EXISTS (SELECT 1 FROM book other WHERE "+strings.Join(conditions, " AND ")+"))")
+	}
+	return q, terms
+}
+
+func (q *query) whereClause() string {
+	if len(q.where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(q.where, " AND ")
+}
+
+func (s *pgBooks) facetCounts(query string, args []interface{}) ([]models.FacetCount, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := []models.FacetCount{}
+	for rows.Next() {
+		var fc models.FacetCount
+		if err := rows.Scan(&fc.Value, &fc.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, fc)
+	}
+	return counts, rows.Err()
+}
+
+func bucketColumns(column string, edges []int) []string {
+	cols := []string{}
+	for i, lo := range edges {
+		cond := fmt.Sprintf("%s >= %d", column, lo)
+		if i+1 < len(edges) {
+			cond += fmt.Sprintf(" AND %s < %d", column, edges[i+1])
+		}
+		cols = append(cols, fmt.Sprintf("COUNT(*) FILTER (WHERE %s)", cond))
+	}
+	return cols
+}
+
+// facets counts the books matching q per genre, format, publisher, page
+// bucket and price bucket.
+func (s *pgBooks) facets(q *query) (*models.Facets, error) {
+	where := q.whereClause()
+	facets := &models.Facets{}
+	var err error
+	facets.Genres, err = s.facetCounts(fmt.Sprintf("SELECT genre, COUNT(*) FROM book_genre WHERE book_id IN (SELECT book_id FROM book%s) GROUP BY genre ORDER BY 2 DESC, 1 LIMIT %d", where, FacetLimit), q.args)
+	if err != nil {
+		return nil, err
+	}
+	facets.Formats, err = s.facetCounts(fmt.Sprintf("SELECT book_format, COUNT(*) FROM book%s GROUP BY book_format ORDER BY 2 DESC, 1 LIMIT %d", where, FacetLimit), q.args)
+	if err != nil {
+		return nil, err
+	}
+	facets.Publishers, err = s.facetCounts(fmt.Sprintf("SELECT publisher, COUNT(*) FROM book%s GROUP BY publisher ORDER BY 2 DESC, 1 LIMIT %d", where, FacetLimit), q.args)
+	if err != nil {
+		return nil, err
+	}
+	cols := append(bucketColumns("num_pages", PageBuckets), bucketColumns("price", PriceBuckets)...)
+	counts := make([]int, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range counts {
+		dest[i] = &counts[i]
+	}
+	if err := s.db.QueryRow("SELECT "+strings.Join(cols, ", ")+" FROM book"+where, q.args...).Scan(dest...); err != nil {
+		return nil, err
+	}
+	facets.Pages = ranges(PageBuckets, counts[:len(PageBuckets)])
+	facets.Prices = ranges(PriceBuckets, counts[len(PageBuckets):])
+	return facets, nil
+}
+
+// page runs a keyset-paginated select of low books matching q, ordered by
+// spec and resuming after its cursor.
+func (s *pgBooks) page(q *query, spec pageSpec) (models.BookPage, error) {
+	expr, typ := "", "text"
+	if spec.key == SortShuffle {
+		expr = fmt.Sprintf("md5(%s::text || ':' || book_id::text)", q.arg(spec.seed))
+	} else if spec.key == SortRelevance {
+		expr, typ = q.rank, "real"
+	} else {
+		expr, typ = sortColumns[spec.key][0], sortColumns[spec.key][1]
+	}
+	op, dir := ">", "ASC"
+	if spec.desc {
+		op, dir = "<", "DESC"
+	}
+	if spec.after != nil {
+		q.where = append(q.where, fmt.Sprintf("(%s, book_id) %s (%s::%s, %s)", expr, op, q.arg(spec.after.Value), typ, q.arg(spec.after.Id)))
+	}
+	var sb strings.Builder
+	sb.WriteString("SELECT " + lowBookColumns + ", " + expr + "::text FROM book" + q.whereClause())
+	sb.WriteString(fmt.Sprintf(" ORDER BY %s %s, book_id %s LIMIT %s", expr, dir, dir, q.arg(spec.limit+1)))
+	rows, err := s.db.Query(sb.String(), q.args...)
+	if err != nil {
+		return models.BookPage{}, err
+	}
+	defer rows.Close()
+	result := models.BookPage{Books: []models.LowBook{}}
+	var last string
+	for rows.Next() {
+		if len(result.Books) == spec.limit {
+			b := result.Books[len(result.Books)-1]
+			result.NextCursor = spec.next(last, b.Id)
+			break
+		}
+		var book models.LowBook
+		if err := rows.Scan(&book.Id, &book.Title, &book.ImageUrl, &book.Price, &book.Rate, &book.Count, &last); err != nil {
+			return models.BookPage{}, err
+		}
+		book.ImageUrl = images.CardURL(book.ImageUrl)
+		result.Books = append(result.Books, book)
+	}
+	if spec.key == SortShuffle {
+		result.Seed = spec.seed
+	}
+	return result, rows.Err()
+}
+
+func (s *pgBooks) Suggest(prefix string, limit int) ([]models.Suggestion, error) {
+	term := functions.NormalizePersian(strings.TrimSpace(prefix))
+	like := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(term) + "%"
+	rows, err := s.db.Query(`SELECT type, id, text FROM (
+		(SELECT 'book' AS type, book_id AS id, title AS text, normalize_fa(title) LIKE $2 AS prefix, similarity(normalize_fa(title), $1) AS score
+			FROM book WHERE normalize_fa(title) LIKE $2 OR normalize_fa(title) % $1 ORDER BY 4 DESC, 5 DESC LIMIT $3)
+		UNION ALL
+		(SELECT 'author', author_id, name, normalize_fa(name) LIKE $2, similarity(normalize_fa(name), $1)
+			FROM authors WHERE normalize_fa(name) LIKE $2 OR normalize_fa(name) % $1 ORDER BY 4 DESC, 5 DESC LIMIT $3)
+		UNION ALL
+		(SELECT 'publisher', 0, publisher, normalize_fa(publisher) LIKE $2, similarity(normalize_fa(publisher), $1)
+			FROM book WHERE normalize_fa(publisher) LIKE $2 OR normalize_fa(publisher) % $1 GROUP BY publisher ORDER BY 4 DESC, 5 DESC LIMIT $3)
+		UNION ALL
+		(SELECT 'genre', 0, genre, normalize_fa(genre) LIKE $2, similarity(normalize_fa(genre), $1)
+			FROM book_genre WHERE normalize_fa(genre) LIKE $2 OR normalize_fa(genre) % $1 GROUP BY genre ORDER BY 4 DESC, 5 DESC LIMIT $3)
+	) suggestions ORDER BY prefix DESC, score DESC, text LIMIT $3`, term, like, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	suggestions := []models.Suggestion{}
+	for rows.Next() {
+		var suggestion models.Suggestion
+		if err := rows.Scan(&suggestion.Type, &suggestion.Id, &suggestion.Text); err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+	return suggestions, rows.Err()
+}
+
+func (s *pgBooks) Create(book *models.Book) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := insertBook(tx, book); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertBook(tx *sql.Tx, book *models.Book) error {
+	if err := tx.QueryRow("SELECT COALESCE(MAX(book_id), 0) + 1 FROM book").Scan(&book.Id); err != nil {
+		return err
+	}
+	_, err := tx.Exec("INSERT INTO book(book_id, title, isbn, image_url, publication_date, isbn13, num_pages, publisher, book_format, language, description, price, quantity_sale, quantity_lib, avg_rate, rate_count) VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16)", book.Id, book.Title, book.Isbn, book.ImageUrl, book.PublicationDate, book.Isbn13, book.NumberOfPages, book.Publisher, book.Format, book.Language, book.Description, book.Price, book.QuantityForSale, book.QuantityInLib, book.AverageRate, book.RateCount)
+	if err != nil {
+		return duplicateIsbn(err)
+	}
+	return linkNewBook(tx, book)
+}
+
+// linkNewBook adds what goes with a book that was just inserted: its place
+// among the new books, its genres and its authors.
+func linkNewBook(tx *sql.Tx, book *models.Book) error {
+	if _, err := tx.Exec("INSERT INTO newbook(book_id, time_added) VALUES($1, $2)", book.Id, time.Now()); err != nil {
+		return err
+	}
+	if err := insertGenres(tx, book.Id, book.Genres); err != nil {
+		return err
+	}
+	return insertAuthors(tx, book.Id, book.Authors)
+}
+
+func insertGenres(tx *sql.Tx, bid int, genres []string) error {
+	for _, genre := range genres {
+		if _, err := tx.Exec("INSERT INTO book_genre(book_id, genre) VALUES($1, $2)", bid, genre); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertAuthors links the authors to the book, reusing an existing author
+// whose normalized name matches before creating a new one.
+func insertAuthors(tx *sql.Tx, bid int, authors []models.AuthorR) error {
+	for _, author := range authors {
+		var aid int
+		err := tx.QueryRow("SELECT author_id FROM authors WHERE normalize_fa(btrim(name)) = normalize_fa(btrim($1)) ORDER BY author_id LIMIT 1", author.Author).Scan(&aid)
+		if errors.Is(err, sql.ErrNoRows) {
+			if err := tx.QueryRow("SELECT COALESCE(MAX(author_id), 0) + 1 FROM authors").Scan(&aid); err != nil {
+				return err
+			}
+			_, err = tx.Exec("INSERT INTO authors(author_id, name) VALUES($1, $2)", aid, strings.TrimSpace(author.Author))
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO book_author(book_id, author_id, role) VALUES($1, $2, $3)", bid, aid, author.Role); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *pgBooks) Update(book models.Book) error {
+	res, err := s.db.Exec("UPDATE book SET title=$1, isbn=$2, image_url=$3, publication_date=$4, isbn13=$5, num_pages=$6, publisher=$7, book_format=$8, language=$9, description=$10, price=$11, quantity_sale=$12, quantity_lib=$13 WHERE book_id=$14", book.Title, book.Isbn, book.ImageUrl, book.PublicationDate, book.Isbn13, book.NumberOfPages, book.Publisher, book.Format, book.Language, book.Description, book.Price, book.QuantityForSale, book.QuantityInLib, book.Id)
+	if err != nil {
+		return duplicateIsbn(err)
+	}
+	return affected(res)
+}
+
+func (s *pgBooks) SaleQuantity(id int) (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT quantity_sale FROM book WHERE book_id = $1", id).Scan(&count)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return count, err
+}
+
+func (s *pgBooks) SetSaleQuantity(id, quantity int) error {
+	_, err := s.db.Exec("UPDATE book SET quantity_sale=$1 WHERE book_id=$2", quantity, id)
+	return err
+}
+
+func (s *pgBooks) SetRating(id int, avg float64, count int) error {
+	_, err := s.db.Exec("UPDATE book SET avg_rate=$1, rate_count=$2 WHERE book_id=$3", avg, count, id)
+	return err
+}
+
+func (s *pgBooks) SetImage(id int, url string) error {
+	res, err := s.db.Exec("UPDATE book SET image_url=$1 WHERE book_id=$2", url, id)
+	if err != nil {
+		return err
+	}
+	return affected(res)
+}
+
+// duplicateIsbn turns a violation of the unique ISBN13 index into
+// ErrDuplicateIsbn.
+func duplicateIsbn(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "book_isbn13_key" {
+		return ErrDuplicateIsbn
+	}
+	return err
+}
+
+func affected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type pgUsers struct {
+	db *sql.DB
+}
+
+const userColumns = "user_id, firstname, lastname, email, password, image, role, status, status_reason, suspended_until, must_reset_password, email_verified, avatar_public"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row scanner) (models.User, error) {
+	var user models.User
+	err := row.Scan(&user.Id, &user.Firstname, &user.Lastname, &user.Email, &user.Password, &user.Image, &user.Role, &user.Status, &user.StatusReason, &user.SuspendedUntil, &user.MustResetPassword, &user.EmailVerified, &user.AvatarPublic)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, ErrNotFound
+	}
+	return user, err
+}
+
+func (s *pgUsers) Get(id int) (models.User, error) {
+	return scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE user_id=$1", id))
+}
+
+func (s *pgUsers) GetByEmail(email string) (models.User, error) {
+	return scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE email=$1", email))
+}
+
+func (s *pgUsers) Create(user *models.User) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := tx.QueryRow("SELECT COALESCE(MAX(user_id), 0) + 1 FROM users").Scan(&user.Id); err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO users(user_id, firstname, lastname, password, email, image, role, email_verified) values ($1, $2, $3, $4, $5, $6, $7, $8)", user.Id, user.Firstname, user.Lastname, user.Password, user.Email, user.Image, user.Role, user.EmailVerified)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *pgUsers) SetImage(id int, image string) error {
+	_, err := s.db.Exec("UPDATE users SET image=$1 WHERE user_id=$2", image, id)
+	return err
+}
+
+func (s *pgUsers) SetAvatarPublic(id int, public bool) error {
+	_, err := s.db.Exec("UPDATE users SET avatar_public=$1 WHERE user_id=$2", public, id)
+	return err
+}
+
+func (s *pgUsers) ImageInUse(image string) (bool, error) {
+	return exists(s.db, "SELECT 1 FROM users WHERE image=$1", image)
+}
+
+func (s *pgUsers) SetRole(id int, role string) error {
+	res, err := s.db.Exec("UPDATE users SET role=$1 WHERE user_id=$2", role, id)
+	if err != nil {
+		return err
+	}
+	return affected(res)
+}
+
+// SetPassword also lifts a forced reset, since the user just chose a new
+// password.
+func (s *pgUsers) SetPassword(email, hash string) error {
+	_, err := s.db.Exec("UPDATE users SET password=$1, must_reset_password=FALSE WHERE email=$2", hash, email)
+	return err
+}
+
+func (s *pgUsers) IsFaved(uid, bid int) (bool, error) {
+	return exists(s.db, "SELECT 1 FROM user_fave WHERE book_id=$1 AND user_id=$2", bid, uid)
+}
+
+func (s *pgUsers) AddFave(uid, bid int) error {
+	_, err := s.db.Exec("INSERT INTO user_fave(book_id, user_id) values($1, $2)", bid, uid)
+	return err
+}
+
+func (s *pgUsers) RemoveFave(uid, bid int) error {
+	_, err := s.db.Exec("DELETE FROM user_fave WHERE book_id=$1 AND user_id=$2", bid, uid)
+	return err
+}
+
+func (s *pgUsers) Faves(uid int) ([]int, error) {
+	rows, err := s.db.Query("SELECT book_id FROM user_fave WHERE user_id=$1", uid)
+	if err != nil {
+		return nil, err
+	}
+	return scanIds(rows)
+}
+
+func (s *pgUsers) AddRead(uid, bid int) error {
+	_, err := s.db.Exec("INSERT INTO user_read(book_id, userid) VALUES($1, $2)", bid, uid)
+	return err
+}
+
+func (s *pgUsers) Reads(uid int) ([]int, error) {
+	rows, err := s.db.Query("SELECT book_id FROM user_read WHERE userid = $1", uid)
+	if err != nil {
+		return nil, err
+	}
+	return scanIds(rows)
+}
+
+type pgBorrows struct {
+	db *sql.DB
+}
+
+func scanBorrowedBooks(rows *sql.Rows) ([]models.LowBook, error) {
+	defer rows.Close()
+	books := []models.LowBook{}
+	for rows.Next() {
+		var book models.LowBook
+		if err := rows.Scan(&book.Id, &book.Title, &book.ImageUrl, &book.Rate, &book.Count); err != nil {
+			return nil, err
+		}
+		book.ImageUrl = images.CardURL(book.ImageUrl)
+		books = append(books, book)
+	}
+	return books, rows.Err()
+}
+
+func (s *pgBorrows) IsBorrowed(bid int) (bool, error) {
+	return exists(s.db, "SELECT 1 FROM borrow_book WHERE book_id = $1 AND returned = 'no'", bid)
+}
+
+func (s *pgBorrows) HasActive(uid int) (bool, error) {
+	return exists(s.db, "SELECT 1 FROM borrow_book WHERE user_id=$1 and returned='no'", uid)
+}
+
+func (s *pgBorrows) Borrow(uid, bid int, at time.Time) error {
+	_, err := s.db.Exec("INSERT INTO borrow_book(book_id, user_id, returned, borrow_time) values($1, $2, 'no', $3)", bid, uid, at)
+	return err
+}
+
+func (s *pgBorrows) Return(bid int) error {
+	res, err := s.db.Exec("UPDATE borrow_book SET returned='yes' WHERE book_id=$1 AND returned = 'no'", bid)
+	if err != nil {
+		return err
+	}
+	return affected(res)
+}
+
+func (s *pgBorrows) History(uid int) ([]models.LowBook, error) {
+	rows, err := s.db.Query("SELECT book.book_id, title, image_url, avg_rate, rate_count FROM borrow_book INNER JOIN book ON borrow_book.book_id = book.book_id WHERE user_id = $1", uid)
+	if err != nil {
+		return nil, err
+	}
+	return scanBorrowedBooks(rows)
+}
+
+func (s *pgBorrows) Active() ([]models.LowBook, error) {
+	rows, err := s.db.Query("SELECT book.book_id, title, image_url, avg_rate, rate_count FROM borrow_book INNER JOIN book ON borrow_book.book_id = book.book_id WHERE returned='no'")
+	if err != nil {
+		return nil, err
+	}
+	return scanBorrowedBooks(rows)
+}
+
+type pgInvoices struct {
+	db *sql.DB
+}
+
+func scanInvoiceBooks(rows *sql.Rows) ([]models.LowBook, error) {
+	defer rows.Close()
+	books := []models.LowBook{}
+	for rows.Next() {
+		var book models.LowBook
+		if err := rows.Scan(&book.Id, &book.Price, &book.Title, &book.ImageUrl); err != nil {
+			return nil, err
+		}
+		book.ImageUrl = images.CardURL(book.ImageUrl)
+		books = append(books, book)
+	}
+	return books, rows.Err()
+}
+
+func (s *pgInvoices) Open(uid int) (int, error) {
+	var iid int
+	err := s.db.QueryRow("SELECT invoice_id FROM invoice WHERE user_id=$1 AND status='open'", uid).Scan(&iid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return iid, err
+}
+
+func (s *pgInvoices) CreateOpen(uid int) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var iid int
+	if err := tx.QueryRow("SELECT COALESCE(MAX(invoice_id), 0) + 1 FROM invoice").Scan(&iid); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("INSERT INTO invoice(invoice_id, user_id, status, purchase_date) VALUES($1, $2, 'open', $3)", iid, uid, time.Now()); err != nil {
+		return 0, err
+	}
+	return iid, tx.Commit()
+}
+
+func (s *pgInvoices) AddBook(iid, bid int) error {
+	_, err := s.db.Exec("INSERT INTO invoice_book(invoice_id, book_id) VALUES($1, $2)", iid, bid)
+	return err
+}
+
+func (s *pgInvoices) RemoveBook(iid, bid int) error {
+	_, err := s.db.Exec("DELETE FROM invoice_book WHERE invoice_id=$1 AND book_id=$2", iid, bid)
+	return err
+}
+
+func (s *pgInvoices) InOpen(uid, bid int) (bool, error) {
+	return exists(s.db, "SELECT 1 FROM invoice INNER JOIN invoice_book ON invoice.invoice_id=invoice_book.invoice_id WHERE invoice.user_id=$1 AND invoice_book.book_id=$2 AND invoice.status='open'", uid, bid)
+}
+
+func (s *pgInvoices) OpenBooks(uid int) ([]models.LowBook, error) {
+	rows, err := s.db.Query("SELECT book.book_id, book.price, book.title, book.image_url FROM invoice INNER JOIN invoice_book ON invoice.invoice_id = invoice_book.invoice_id INNER JOIN book ON book.book_id = invoice_book.book_id WHERE invoice.status = 'open' AND invoice.user_id = $1", uid)
+	if err != nil {
+		return nil, err
+	}
+	return scanInvoiceBooks(rows)
+}
+
+func (s *pgInvoices) Books(iid int) ([]models.LowBook, error) {
+	rows, err := s.db.Query("SELECT book.book_id, book.price, book.title, book.image_url FROM invoice_book INNER JOIN book ON book.book_id=invoice_book.book_id WHERE invoice_book.invoice_id=$1", iid)
+	if err != nil {
+		return nil, err
+	}
+	return scanInvoiceBooks(rows)
+}
+
+func (s *pgInvoices) Close(iid int, at time.Time) error {
+	_, err := s.db.Exec("UPDATE invoice SET status='close', purchase_date=$2 WHERE invoice_id=$1", iid, at)
+	return err
+}
+
+func (s *pgInvoices) History(uid int) ([]models.Invoice, error) {
+	rows, err := s.db.Query("SELECT invoice_id, purchase_date FROM invoice WHERE user_id=$1 AND status='close'", uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	invoices := []models.Invoice{}
+	for rows.Next() {
+		var invoice models.Invoice
+		if err := rows.Scan(&invoice.InvoiceID, &invoice.PurchaseDate); err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, invoice)
+	}
+	return invoices, rows.Err()
+}
+
+func (s *pgInvoices) AllClosed() ([]models.Invoice, error) {
+	rows, err := s.db.Query("SELECT invoice_id, purchase_date, (firstname || ' ' || lastname) as name FROM invoice INNER JOIN users on users.user_id=invoice.user_id WHERE status='close'")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	invoices := []models.Invoice{}
+	for rows.Next() {
+		var invoice models.Invoice
+		if err := rows.Scan(&invoice.InvoiceID, &invoice.PurchaseDate, &invoice.Name); err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, invoice)
+	}
+	return invoices, rows.Err()
+}
+
+type pgRatings struct {
+	db *sql.DB
+}
+
+func (s *pgRatings) Rating(uid, bid int) (int, error) {
+	var rating int
+	err := s.db.QueryRow("SELECT rating FROM user_rating WHERE user_id=$1 AND book_id=$2", uid, bid).Scan(&rating)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return rating, err
+}
+
+func (s *pgRatings) SaveRating(uid int, rate models.Rate, at time.Time) error {
+	res, err := s.db.Exec("UPDATE user_rating SET rating=$3, review=$4, date_added=$5 WHERE user_id=$1 AND book_id=$2", uid, rate.Bid, rate.Rating, rate.Review, at)
+	if err != nil {
+		return err
+	}
+	if affected(res) == nil {
+		return nil
+	}
+	_, err = s.db.Exec("INSERT INTO user_rating(user_id, book_id, rating, review, date_added) values($1, $2, $3, $4, $5)", uid, rate.Bid, rate.Rating, rate.Review, at)
+	return err
+}
+
+func scanComments(rows *sql.Rows, withRate bool) ([]models.UserComment, error) {
+	defer rows.Close()
+	comments := []models.UserComment{}
+	for rows.Next() {
+		var comment models.UserComment
+		dest := []interface{}{&comment.Name, &comment.Comment}
+		if withRate {
+			dest = append(dest, &comment.Rate)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+	return comments, rows.Err()
+}
+
+func (s *pgRatings) Ratings(bid int) ([]models.UserComment, error) {
+	rows, err := s.db.Query("SELECT (firstname || ' ' || lastname) as name, review, rating FROM user_rating INNER JOIN users ON user_rating.user_id=users.user_id WHERE user_rating.book_id=$1", bid)
+	if err != nil {
+		return nil, err
+	}
+	return scanComments(rows, true)
+}
+
+func (s *pgRatings) AddComment(uid int, rate models.Rate, at time.Time) error {
+	_, err := s.db.Exec("INSERT INTO comment(book_id, user_id, review, date_added) values($1, $2, $3, $4)", rate.Bid, uid, rate.Review, at)
+	return err
+}
+
+func (s *pgRatings) Comments(bid int) ([]models.UserComment, error) {
+	rows, err := s.db.Query("SELECT (firstname || ' ' || lastname) as name, review FROM comment INNER JOIN users ON comment.user_id=users.user_id WHERE comment.book_id=$1", bid)
+	if err != nil {
+		return nil, err
+	}
+	return scanComments(rows, false)
+}
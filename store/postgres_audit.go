@@ -0,0 +1,107 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/meynay/BookStore/models"
+)
+
+type pgAudit struct {
+	db *sql.DB
+}
+
+func (s *pgAudit) Record(entry *models.AuditEntry) error {
+	diff, err := json.Marshal(entry.Diff)
+	if err != nil {
+		return err
+	}
+	return s.db.QueryRow("INSERT INTO audit_log(actor_id, action, target_type, target_id, diff, ip) VALUES($1, $2, $3, $4, COALESCE(NULLIF($5, 'null')::jsonb, '{}'), $6) RETURNING audit_id, created_at",
+		entry.ActorId, entry.Action, entry.TargetType, entry.TargetId, string(diff), entry.Ip).Scan(&entry.Id, &entry.CreatedAt)
+}
+
+func auditQuery(filter models.AuditFilter) *query {
+	q := &query{}
+	if filter.ActorId != 0 {
+		q.where = append(q.where, "actor_id = "+q.arg(filter.ActorId))
+	}
+	if filter.Action != "" {
+		q.where = append(q.where, "action = "+q.arg(filter.Action))
+	}
+	if filter.TargetType != "" {
+		q.where = append(q.where, "target_type = "+q.arg(filter.TargetType))
+	}
+	if filter.TargetId != "" {
+		q.where = append(q.where, "target_id = "+q.arg(filter.TargetId))
+	}
+	if !filter.From.IsZero() {
+		q.where = append(q.where, "created_at >= "+q.arg(filter.From))
+	}
+	if !filter.Until.IsZero() {
+		q.where = append(q.where, "created_at < "+q.arg(filter.Until))
+	}
+	return q
+}
+
+const auditColumns = "audit_id, actor_id, action, target_type, target_id, diff, ip, created_at"
+
+func scanAudit(rows *sql.Rows) (models.AuditEntry, error) {
+	var entry models.AuditEntry
+	var diff []byte
+	if err := rows.Scan(&entry.Id, &entry.ActorId, &entry.Action, &entry.TargetType, &entry.TargetId, &diff, &entry.Ip, &entry.CreatedAt); err != nil {
+		return entry, err
+	}
+	return entry, json.Unmarshal(diff, &entry.Diff)
+}
+
+// List pages through the log newest first.
+func (s *pgAudit) List(filter models.AuditFilter) (models.AuditPage, error) {
+	spec, err := parseKeyset(filter.Page, "audit_id")
+	if err != nil {
+		return models.AuditPage{}, err
+	}
+	q := auditQuery(filter)
+	if spec.after != nil {
+		q.where = append(q.where, "audit_id < "+q.arg(spec.after.Id))
+	}
+	rows, err := s.db.Query(fmt.Sprintf("SELECT %s FROM audit_log%s ORDER BY audit_id DESC LIMIT %s", auditColumns, q.whereClause(), q.arg(spec.limit+1)), q.args...)
+	if err != nil {
+		return models.AuditPage{}, err
+	}
+	defer rows.Close()
+	result := models.AuditPage{Entries: []models.AuditEntry{}}
+	for rows.Next() {
+		if len(result.Entries) == spec.limit {
+			last := result.Entries[len(result.Entries)-1]
+			result.NextCursor = spec.next("", last.Id)
+			break
+		}
+		entry, err := scanAudit(rows)
+		if err != nil {
+			return models.AuditPage{}, err
+		}
+		result.Entries = append(result.Entries, entry)
+	}
+	return result, rows.Err()
+}
+
+// Each streams every matching entry oldest first, for exports.
+func (s *pgAudit) Each(filter models.AuditFilter, fn func(entry models.AuditEntry) error) error {
+	q := auditQuery(filter)
+	rows, err := s.db.Query(fmt.Sprintf("SELECT %s FROM audit_log%s ORDER BY audit_id", auditColumns, q.whereClause()), q.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		entry, err := scanAudit(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
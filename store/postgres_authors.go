@@ -0,0 +1,125 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/meynay/BookStore/functions"
+	"github.com/meynay/BookStore/images"
+	"github.com/meynay/BookStore/models"
+)
+
+type pgAuthors struct {
+	db *sql.DB
+}
+
+func (s *pgAuthors) List(search string, page models.Page) (models.AuthorPage, error) {
+	spec, err := parseKeyset(page, "name")
+	if err != nil {
+		return models.AuthorPage{}, err
+	}
+	q := &query{}
+	if term := functions.NormalizePersian(strings.TrimSpace(search)); term != "" {
+		like := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(term)
+		q.where = append(q.where, fmt.Sprintf("normalize_fa(name) LIKE '%%' || %s || '%%'", q.arg(like)))
+	}
+	if spec.after != nil {
+		q.where = append(q.where, fmt.Sprintf("(name, author_id) > (%s, %s)", q.arg(spec.after.Value), q.arg(spec.after.Id)))
+	}
+	rows, err := s.db.Query(fmt.Sprintf("SELECT author_id, name, bio, photo_url FROM authors%s ORDER BY name, author_id LIMIT %s", q.whereClause(), q.arg(spec.limit+1)), q.args...)
+	if err != nil {
+		return models.AuthorPage{}, err
+	}
+	defer rows.Close()
+	result := models.AuthorPage{Authors: []models.Author{}}
+	for rows.Next() {
+		if len(result.Authors) == spec.limit {
+			last := result.Authors[len(result.Authors)-1]
+			result.NextCursor = spec.next(last.Name, last.Id)
+			break
+		}
+		var author models.Author
+		if err := rows.Scan(&author.Id, &author.Name, &author.Bio, &author.PhotoUrl); err != nil {
+			return models.AuthorPage{}, err
+		}
+		result.Authors = append(result.Authors, author)
+	}
+	return result, rows.Err()
+}
+
+func (s *pgAuthors) Get(id int) (models.Author, error) {
+	author := models.Author{Id: id, Books: make(map[string][]models.LowBook)}
+	err := s.db.QueryRow("SELECT name, bio, photo_url FROM authors WHERE author_id=$1", id).Scan(&author.Name, &author.Bio, &author.PhotoUrl)
+	if errors.Is(err, sql.ErrNoRows) {
+		return author, ErrNotFound
+	}
+	if err != nil {
+		return author, err
+	}
+	rows, err := s.db.Query("SELECT COALESCE(NULLIF(book_author.role, ''), 'author'), book.book_id, title, image_url, price, avg_rate, rate_count FROM book_author INNER JOIN book ON book.book_id = book_author.book_id WHERE book_author.author_id=$1 ORDER BY publication_date, book.book_id", id)
+	if err != nil {
+		return author, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var role string
+		var book models.LowBook
+		if err := rows.Scan(&role, &book.Id, &book.Title, &book.ImageUrl, &book.Price, &book.Rate, &book.Count); err != nil {
+			return author, err
+		}
+		book.ImageUrl = images.CardURL(book.ImageUrl)
+		author.Books[role] = append(author.Books[role], book)
+	}
+	return author, rows.Err()
+}
+
+func (s *pgAuthors) Update(author models.Author) error {
+	res, err := s.db.Exec("UPDATE authors SET name=$1, bio=$2, photo_url=$3 WHERE author_id=$4", strings.TrimSpace(author.Name), author.Bio, author.PhotoUrl, author.Id)
+	if err != nil {
+		return err
+	}
+	return affected(res)
+}
+
+// Merge repoints every book_author row of the duplicates to target, keeps
+// the first non-empty bio and photo, and deletes the duplicates.
+func (s *pgAuthors) Merge(target int, duplicates []int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	dups := pq.Array(duplicates)
+	var found int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM authors WHERE author_id = $1 OR author_id = ANY($2)", target, dups).Scan(&found); err != nil {
+		return err
+	}
+	if found != len(duplicates)+1 {
+		return ErrNotFound
+	}
+	_, err = tx.Exec(`INSERT INTO book_author(book_id, author_id, role)
+		SELECT DISTINCT book_id, $1::integer, role FROM book_author dup
+		WHERE dup.author_id = ANY($2) AND NOT EXISTS (
+			SELECT 1 FROM book_author own
+			WHERE own.book_id = dup.book_id AND own.author_id = $1 AND own.role IS NOT DISTINCT FROM dup.role)`, target, dups)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM book_author WHERE author_id = ANY($1)", dups); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE authors SET
+		bio = CASE WHEN bio = '' THEN COALESCE((SELECT bio FROM authors d WHERE d.author_id = ANY($2) AND d.bio <> '' ORDER BY d.author_id LIMIT 1), '') ELSE bio END,
+		photo_url = CASE WHEN photo_url = '' THEN COALESCE((SELECT photo_url FROM authors d WHERE d.author_id = ANY($2) AND d.photo_url <> '' ORDER BY d.author_id LIMIT 1), '') ELSE photo_url END
+		WHERE author_id = $1`, target, dups)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM authors WHERE author_id = ANY($1)", dups); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,197 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/meynay/BookStore/models"
+)
+
+// Import upserts the books by ISBN13 in a single transaction and reports
+// for each one whether it was created or updated. A dry run does the same
+// work and rolls it back.
+func (s *pgBooks) Import(books []models.Book, dryRun bool) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	statuses := make([]string, len(books))
+	for i := range books {
+		book := &books[i]
+		created, err := upsertImported(tx, book)
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			if err := linkNewBook(tx, book); err != nil {
+				return nil, err
+			}
+			statuses[i] = models.ImportCreated
+			continue
+		}
+		if err := relinkImported(tx, *book); err != nil {
+			return nil, err
+		}
+		statuses[i] = models.ImportUpdated
+	}
+	if dryRun {
+		return statuses, nil
+	}
+	return statuses, tx.Commit()
+}
+
+// upsertImported inserts the book, or overwrites the catalog data of the
+// one with its ISBN13, telling which it did. Stock and cover are only
+// replaced when the import carries them.
+func upsertImported(tx *sql.Tx, book *models.Book) (bool, error) {
+	var next int
+	if err := tx.QueryRow("SELECT COALESCE(MAX(book_id), 0) + 1 FROM book").Scan(&next); err != nil {
+		return false, err
+	}
+	var created bool
+	err := tx.QueryRow(`INSERT INTO book(book_id, title, isbn, isbn13, publication_date, num_pages, publisher, book_format, language, description, price, image_url, quantity_sale, quantity_lib)
+		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14)
+		ON CONFLICT (replace(isbn13, '-', '')) WHERE isbn13 <> '' DO UPDATE SET title=EXCLUDED.title, isbn=EXCLUDED.isbn, isbn13=EXCLUDED.isbn13, publication_date=EXCLUDED.publication_date,
+		num_pages=EXCLUDED.num_pages, publisher=EXCLUDED.publisher, book_format=EXCLUDED.book_format, language=EXCLUDED.language, description=EXCLUDED.description, price=EXCLUDED.price,
+		image_url=COALESCE(NULLIF(EXCLUDED.image_url, ''), book.image_url), quantity_sale=COALESCE(NULLIF(EXCLUDED.quantity_sale, 0), book.quantity_sale), quantity_lib=COALESCE(NULLIF(EXCLUDED.quantity_lib, 0), book.quantity_lib)
+		RETURNING book_id, xmax = 0`, next, book.Title, book.Isbn, book.Isbn13, book.PublicationDate, book.NumberOfPages, book.Publisher, book.Format, book.Language, book.Description, book.Price, book.ImageUrl, book.QuantityForSale, book.QuantityInLib).Scan(&book.Id, &created)
+	return created, err
+}
+
+// relinkImported replaces the genres and authors of an updated book, each
+// only when the record lists any.
+func relinkImported(tx *sql.Tx, book models.Book) error {
+	if len(book.Genres) > 0 {
+		if _, err := tx.Exec("DELETE FROM book_genre WHERE book_id=$1", book.Id); err != nil {
+			return err
+		}
+		if err := insertGenres(tx, book.Id, book.Genres); err != nil {
+			return err
+		}
+	}
+	if len(book.Authors) > 0 {
+		if _, err := tx.Exec("DELETE FROM book_author WHERE book_id=$1", book.Id); err != nil {
+			return err
+		}
+		if err := insertAuthors(tx, book.Id, book.Authors); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fullBookColumns selects everything Get returns, with genres and authors
+// folded into arrays so many books can be read in one query.
+const fullBookColumns = `book_id, title, isbn, image_url, publication_date, isbn13, num_pages, publisher, book_format, language, description, price, quantity_sale, quantity_lib, avg_rate, rate_count,
+	COALESCE(work_id, 0), COALESCE(series_id, 0), COALESCE((SELECT name FROM series WHERE series.series_id = book.series_id), ''), COALESCE(series_volume, 0), updated_at,
+	ARRAY(SELECT genre FROM book_genre WHERE book_genre.book_id = book.book_id ORDER BY genre),
+	ARRAY(SELECT authors.author_id FROM book_author INNER JOIN authors ON authors.author_id = book_author.author_id WHERE book_author.book_id = book.book_id ORDER BY authors.author_id, book_author.role),
+	ARRAY(SELECT authors.name FROM book_author INNER JOIN authors ON authors.author_id = book_author.author_id WHERE book_author.book_id = book.book_id ORDER BY authors.author_id, book_author.role),
+	ARRAY(SELECT COALESCE(book_author.role, '') FROM book_author INNER JOIN authors ON authors.author_id = book_author.author_id WHERE book_author.book_id = book.book_id ORDER BY authors.author_id, book_author.role)`
+
+func scanFullBook(rows *sql.Rows) (models.Book, error) {
+	var book models.Book
+	var aids []int64
+	var names, roles []string
+	book.Genres = []string{}
+	err := rows.Scan(&book.Id, &book.Title, &book.Isbn, &book.ImageUrl, &book.PublicationDate, &book.Isbn13, &book.NumberOfPages, &book.Publisher, &book.Format, &book.Language, &book.Description, &book.Price, &book.QuantityForSale, &book.QuantityInLib, &book.AverageRate, &book.RateCount,
+		&book.WorkId, &book.SeriesId, &book.Series, &book.SeriesVolume, &book.UpdatedAt, pq.Array(&book.Genres), pq.Array(&aids), pq.Array(&names), pq.Array(&roles))
+	if err != nil {
+		return book, err
+	}
+	book.Authors = []models.AuthorR{}
+	for i := range aids {
+		book.Authors = append(book.Authors, models.AuthorR{Id: int(aids[i]), Author: names[i], Role: roles[i]})
+	}
+	return book, nil
+}
+
+// Each calls fn with every book, in id order, with the same fields Get
+// returns. Rows are streamed so the catalog never has to fit in memory.
+func (s *pgBooks) Each(fn func(book models.Book) error) error {
+	rows, err := s.db.Query("SELECT " + fullBookColumns + " FROM book ORDER BY book_id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		book, err := scanFullBook(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(book); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Details returns the full books for ids, in the same order, skipping ids
+// that don't exist.
+func (s *pgBooks) Details(ids []int) ([]models.Book, error) {
+	rows, err := s.db.Query("SELECT "+fullBookColumns+" FROM book WHERE book_id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byId := make(map[int]models.Book)
+	for rows.Next() {
+		book, err := scanFullBook(rows)
+		if err != nil {
+			return nil, err
+		}
+		byId[book.Id] = book
+	}
+	books := []models.Book{}
+	for _, id := range ids {
+		if book, ok := byId[id]; ok {
+			books = append(books, book)
+		}
+	}
+	return books, rows.Err()
+}
+
+// Genres lists every genre with the number of books in it.
+func (s *pgBooks) Genres() ([]models.FacetCount, error) {
+	return s.facetCounts("SELECT genre, COUNT(*) FROM book_genre GROUP BY genre ORDER BY genre", nil)
+}
+
+func (s *pgBooks) Count(filter models.Filter) (int, error) {
+	q, _ := filterQuery(filter)
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM book"+q.whereClause(), q.args...).Scan(&count)
+	return count, err
+}
+
+// Changes lists up to limit books whose catalog data changed between from
+// and until, both inclusive and ignored when zero, in updated_at and id
+// order. A non-zero afterId resumes after the book at (afterTime, afterId).
+func (s *pgBooks) Changes(from, until, afterTime time.Time, afterId, limit int) ([]models.Book, error) {
+	q := &query{}
+	if !from.IsZero() {
+		q.where = append(q.where, "updated_at >= "+q.arg(from))
+	}
+	if !until.IsZero() {
+		q.where = append(q.where, "updated_at <= "+q.arg(until))
+	}
+	if afterId != 0 {
+		q.where = append(q.where, fmt.Sprintf("(updated_at, book_id) > (%s, %s)", q.arg(afterTime), q.arg(afterId)))
+	}
+	rows, err := s.db.Query(fmt.Sprintf("SELECT %s FROM book%s ORDER BY updated_at, book_id LIMIT %s", fullBookColumns, q.whereClause(), q.arg(limit)), q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	books := []models.Book{}
+	for rows.Next() {
+		book, err := scanFullBook(rows)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+	}
+	return books, rows.Err()
+}
@@ -0,0 +1,66 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/meynay/BookStore/models"
+)
+
+type pgClients struct {
+	db *sql.DB
+}
+
+const clientColumns = "client_id, name, key_prefix, key_hash, scopes, rate_limit, created_at, last_used_at, revoked_at"
+
+func scanClient(row scanner) (models.ApiClient, error) {
+	var client models.ApiClient
+	err := row.Scan(&client.Id, &client.Name, &client.Prefix, &client.Hash, pq.Array(&client.Scopes), &client.RateLimit, &client.CreatedAt, &client.LastUsedAt, &client.RevokedAt)
+	return client, err
+}
+
+func (s *pgClients) Create(client *models.ApiClient) error {
+	client.CreatedAt = time.Now()
+	return s.db.QueryRow("INSERT INTO api_clients(name, key_prefix, key_hash, scopes, rate_limit, created_at) VALUES($1, $2, $3, $4, $5, $6) RETURNING client_id",
+		client.Name, client.Prefix, client.Hash, pq.Array(client.Scopes), client.RateLimit, client.CreatedAt).Scan(&client.Id)
+}
+
+func (s *pgClients) ByHash(hash string) (models.ApiClient, error) {
+	client, err := scanClient(s.db.QueryRow("SELECT "+clientColumns+" FROM api_clients WHERE key_hash=$1 AND revoked_at IS NULL", hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return client, ErrNotFound
+	}
+	return client, err
+}
+
+func (s *pgClients) List() ([]models.ApiClient, error) {
+	rows, err := s.db.Query("SELECT " + clientColumns + " FROM api_clients ORDER BY client_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	clients := []models.ApiClient{}
+	for rows.Next() {
+		client, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, rows.Err()
+}
+
+func (s *pgClients) Revoke(id int) error {
+	res, err := s.db.Exec("UPDATE api_clients SET revoked_at=$1 WHERE client_id=$2 AND revoked_at IS NULL", time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return affected(res)
+}
+
+func (s *pgClients) Touch(id int, at time.Time) error {
+	_, err := s.db.Exec("UPDATE api_clients SET last_used_at=$1 WHERE client_id=$2", at, id)
+	return err
+}
@@ -0,0 +1,50 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type pgResets struct {
+	db *sql.DB
+}
+
+func (s *pgResets) Create(uid int, hash string, expires time.Time) error {
+	_, err := s.db.Exec("INSERT INTO password_resets(token_hash, user_id, created_at, expires_at) VALUES($1, $2, $3, $4)", hash, uid, time.Now(), expires)
+	return err
+}
+
+func (s *pgResets) Lookup(hash string) (int, error) {
+	var uid int
+	err := s.db.QueryRow("SELECT user_id FROM password_resets WHERE token_hash=$1 AND used_at IS NULL AND expires_at > $2", hash, time.Now()).Scan(&uid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return uid, err
+}
+
+func (s *pgResets) Consume(hash string) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	var uid int
+	err = tx.QueryRow("UPDATE password_resets SET used_at=$1 WHERE token_hash=$2 AND used_at IS NULL AND expires_at > $1 RETURNING user_id", now, hash).Scan(&uid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("UPDATE password_resets SET used_at=$1 WHERE user_id=$2 AND used_at IS NULL", now, uid); err != nil {
+		return 0, err
+	}
+	// used and expired tokens are of no use to anyone
+	if _, err := tx.Exec("DELETE FROM password_resets WHERE expires_at < $1", now.Add(-24*time.Hour)); err != nil {
+		return 0, err
+	}
+	return uid, tx.Commit()
+}
@@ -0,0 +1,181 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+	"github.com/meynay/BookStore/images"
+	"github.com/meynay/BookStore/models"
+)
+
+// GroupEditions puts the books under one work. If some of them already
+// belong to works, those works are merged into the oldest one; otherwise a
+// new work is created with the title of the first book.
+func (s *pgBooks) GroupEditions(ids []int) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	bids := pq.Array(ids)
+	var found int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM book WHERE book_id = ANY($1)", bids).Scan(&found); err != nil {
+		return 0, err
+	}
+	if found != len(ids) {
+		return 0, ErrNotFound
+	}
+	var works []int
+	rows, err := tx.Query("SELECT DISTINCT work_id FROM book WHERE book_id = ANY($1) AND work_id IS NOT NULL ORDER BY work_id", bids)
+	if err != nil {
+		return 0, err
+	}
+	if works, err = scanIds(rows); err != nil {
+		return 0, err
+	}
+	var wid int
+	if len(works) > 0 {
+		wid = works[0]
+	} else if err := tx.QueryRow("INSERT INTO work(title) SELECT title FROM book WHERE book_id=$1 RETURNING work_id", ids[0]).Scan(&wid); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("UPDATE book SET work_id=$1 WHERE book_id = ANY($2) OR work_id = ANY($3)", wid, bids, pq.Array(works)); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM work WHERE work_id = ANY($1) AND work_id <> $2", pq.Array(works), wid); err != nil {
+		return 0, err
+	}
+	return wid, tx.Commit()
+}
+
+// Ungroup takes the book out of its work and drops the work once it has no
+// editions left.
+func (s *pgBooks) Ungroup(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var wid sql.NullInt64
+	err = tx.QueryRow("SELECT work_id FROM book WHERE book_id=$1", id).Scan(&wid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !wid.Valid {
+		return tx.Commit()
+	}
+	if _, err := tx.Exec("UPDATE book SET work_id=NULL WHERE book_id=$1", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM work WHERE work_id=$1 AND NOT EXISTS (SELECT 1 FROM book WHERE work_id=$1)", wid.Int64); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Editions returns the other books of the same work.
+func (s *pgBooks) Editions(id int) ([]models.Edition, error) {
+	rows, err := s.db.Query(`SELECT other.book_id, other.title, other.book_format, other.isbn13, other.price, other.image_url
+		FROM book INNER JOIN book other ON other.work_id = book.work_id AND other.book_id <> book.book_id
+		WHERE book.book_id=$1 ORDER BY other.publication_date, other.book_id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	editions := []models.Edition{}
+	for rows.Next() {
+		var edition models.Edition
+		if err := rows.Scan(&edition.Id, &edition.Title, &edition.Format, &edition.Isbn13, &edition.Price, &edition.ImageUrl); err != nil {
+			return nil, err
+		}
+		edition.ImageUrl = images.CardURL(edition.ImageUrl)
+		editions = append(editions, edition)
+	}
+	return editions, rows.Err()
+}
+
+type pgSeries struct {
+	db *sql.DB
+}
+
+func (s *pgSeries) Create(series *models.Series) error {
+	return s.db.QueryRow("INSERT INTO series(name, description) VALUES($1, $2) RETURNING series_id", series.Name, series.Description).Scan(&series.Id)
+}
+
+func (s *pgSeries) Get(id int) (models.Series, error) {
+	series := models.Series{Id: id, Books: []models.SeriesBook{}}
+	err := s.db.QueryRow("SELECT name, description FROM series WHERE series_id=$1", id).Scan(&series.Name, &series.Description)
+	if errors.Is(err, sql.ErrNoRows) {
+		return series, ErrNotFound
+	}
+	if err != nil {
+		return series, err
+	}
+	rows, err := s.db.Query("SELECT COALESCE(series_volume, 0), "+lowBookColumns+" FROM book WHERE series_id=$1 ORDER BY series_volume NULLS LAST, book_id", id)
+	if err != nil {
+		return series, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var book models.SeriesBook
+		if err := rows.Scan(&book.Volume, &book.Id, &book.Title, &book.ImageUrl, &book.Price, &book.Rate, &book.Count); err != nil {
+			return series, err
+		}
+		book.ImageUrl = images.CardURL(book.ImageUrl)
+		series.Books = append(series.Books, book)
+	}
+	return series, rows.Err()
+}
+
+// SetVolumes adds the books to the series, or moves them from another one,
+// with the given volume numbers.
+func (s *pgSeries) SetVolumes(id int, volumes []models.SeriesVolume) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if ok, err := exists(s.db, "SELECT 1 FROM series WHERE series_id=$1", id); err != nil || !ok {
+		if err == nil {
+			err = ErrNotFound
+		}
+		return err
+	}
+	for _, volume := range volumes {
+		res, err := tx.Exec("UPDATE book SET series_id=$1, series_volume=NULLIF($2, 0) WHERE book_id=$3", id, volume.Volume, volume.BookId)
+		if err != nil {
+			return err
+		}
+		if err := affected(res); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *pgSeries) Remove(id, bid int) error {
+	res, err := s.db.Exec("UPDATE book SET series_id=NULL, series_volume=NULL WHERE book_id=$1 AND series_id=$2", bid, id)
+	if err != nil {
+		return err
+	}
+	return affected(res)
+}
+
+// Next returns the book with the smallest volume after the given one in its
+// series. Editions of the same work share a volume, so the cheapest one wins.
+func (s *pgSeries) Next(bid int) (models.LowBook, error) {
+	var book models.LowBook
+	err := s.db.QueryRow(`SELECT later.book_id, later.title, later.image_url, later.price, later.avg_rate, later.rate_count
+		FROM book INNER JOIN book later ON later.series_id = book.series_id AND later.series_volume > book.series_volume
+		WHERE book.book_id=$1 ORDER BY later.series_volume, later.price, later.book_id LIMIT 1`, bid).
+		Scan(&book.Id, &book.Title, &book.ImageUrl, &book.Price, &book.Rate, &book.Count)
+	if errors.Is(err, sql.ErrNoRows) {
+		return book, ErrNotFound
+	}
+	book.ImageUrl = images.CardURL(book.ImageUrl)
+	return book, err
+}
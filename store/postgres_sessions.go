@@ -0,0 +1,133 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/meynay/BookStore/models"
+)
+
+type pgSessions struct {
+	db *sql.DB
+}
+
+const sessionColumns = "session_id, user_id, user_agent, ip, created_at, last_used_at, expires_at, revoked_at"
+
+func scanSession(row scanner) (models.Session, error) {
+	var session models.Session
+	err := row.Scan(&session.Id, &session.Uid, &session.UserAgent, &session.Ip, &session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &session.RevokedAt)
+	return session, err
+}
+
+func (s *pgSessions) Create(session *models.Session, hash string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	err = tx.QueryRow("INSERT INTO sessions(user_id, user_agent, ip, created_at, last_used_at, expires_at) VALUES($1, $2, $3, $4, $4, $5) RETURNING session_id",
+		session.Uid, session.UserAgent, session.Ip, now, session.ExpiresAt).Scan(&session.Id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO refresh_tokens(token_hash, session_id, created_at) VALUES($1, $2, $3)", hash, session.Id, now); err != nil {
+		return err
+	}
+	session.CreatedAt, session.LastUsedAt = now, now
+	return tx.Commit()
+}
+
+func (s *pgSessions) Rotate(hash, next, ip, userAgent string, expires time.Time) (models.Session, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return models.Session{}, err
+	}
+	defer tx.Rollback()
+	var usedAt *time.Time
+	var sid int
+	err = tx.QueryRow("SELECT session_id, used_at FROM refresh_tokens WHERE token_hash=$1 FOR UPDATE", hash).Scan(&sid, &usedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Session{}, ErrNotFound
+	}
+	if err != nil {
+		return models.Session{}, err
+	}
+	session, err := scanSession(tx.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE session_id=$1 FOR UPDATE", sid))
+	if err != nil {
+		return models.Session{}, err
+	}
+	now := time.Now()
+	if usedAt != nil {
+		if session.RevokedAt == nil {
+			if _, err := tx.Exec("UPDATE sessions SET revoked_at=$1 WHERE session_id=$2", now, sid); err != nil {
+				return models.Session{}, err
+			}
+			if err := tx.Commit(); err != nil {
+				return models.Session{}, err
+			}
+		}
+		return session, ErrTokenReused
+	}
+	if session.RevokedAt != nil || !session.ExpiresAt.After(now) {
+		return models.Session{}, ErrNotFound
+	}
+	if _, err := tx.Exec("UPDATE refresh_tokens SET used_at=$1 WHERE token_hash=$2", now, hash); err != nil {
+		return models.Session{}, err
+	}
+	if _, err := tx.Exec("INSERT INTO refresh_tokens(token_hash, session_id, created_at) VALUES($1, $2, $3)", next, sid, now); err != nil {
+		return models.Session{}, err
+	}
+	if _, err := tx.Exec("UPDATE sessions SET ip=$1, user_agent=$2, last_used_at=$3, expires_at=$4 WHERE session_id=$5", ip, userAgent, now, expires, sid); err != nil {
+		return models.Session{}, err
+	}
+	session.Ip, session.UserAgent, session.LastUsedAt, session.ExpiresAt = ip, userAgent, now, expires
+	return session, tx.Commit()
+}
+
+func (s *pgSessions) ByRefresh(hash string) (models.Session, error) {
+	session, err := scanSession(s.db.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE session_id=(SELECT session_id FROM refresh_tokens WHERE token_hash=$1)", hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Session{}, ErrNotFound
+	}
+	return session, err
+}
+
+func (s *pgSessions) Active(id int) (models.Session, error) {
+	session, err := scanSession(s.db.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE session_id=$1 AND revoked_at IS NULL AND expires_at > $2", id, time.Now()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Session{}, ErrNotFound
+	}
+	return session, err
+}
+
+func (s *pgSessions) List(uid int) ([]models.Session, error) {
+	rows, err := s.db.Query("SELECT "+sessionColumns+" FROM sessions WHERE user_id=$1 AND revoked_at IS NULL AND expires_at > $2 ORDER BY last_used_at DESC", uid, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := []models.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *pgSessions) Revoke(uid, id int) error {
+	res, err := s.db.Exec("UPDATE sessions SET revoked_at=$1 WHERE session_id=$2 AND user_id=$3 AND revoked_at IS NULL AND expires_at > $1", time.Now(), id, uid)
+	if err != nil {
+		return err
+	}
+	return affected(res)
+}
+
+func (s *pgSessions) RevokeAll(uid int) error {
+	_, err := s.db.Exec("UPDATE sessions SET revoked_at=$1 WHERE user_id=$2 AND revoked_at IS NULL", time.Now(), uid)
+	return err
+}
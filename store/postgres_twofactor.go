@@ -0,0 +1,109 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/meynay/BookStore/models"
+)
+
+type pgTwoFactor struct {
+	db *sql.DB
+}
+
+func (s *pgTwoFactor) Get(uid int) (models.TwoFactor, error) {
+	tf := models.TwoFactor{Uid: uid}
+	err := s.db.QueryRow("SELECT secret, enabled, last_step, (SELECT COUNT(*) FROM recovery_codes r WHERE r.user_id = t.user_id AND r.used_at IS NULL) FROM two_factor t WHERE user_id=$1", uid).
+		Scan(&tf.Secret, &tf.Enabled, &tf.LastStep, &tf.RecoveryCodes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return tf, ErrNotFound
+	}
+	return tf, err
+}
+
+func (s *pgTwoFactor) Begin(uid int, secret string) error {
+	_, err := s.db.Exec(`INSERT INTO two_factor(user_id, secret) VALUES($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret=EXCLUDED.secret, enabled=FALSE, last_step=0, created_at=NOW()`, uid, secret)
+	return err
+}
+
+func setRecoveryCodes(tx *sql.Tx, uid int, recoveryCodes []string) error {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id=$1", uid); err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes(user_id, code_hash) VALUES($1, $2)", uid, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *pgTwoFactor) Enable(uid int, step int64, recoveryCodes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("UPDATE two_factor SET enabled=TRUE, last_step=$1 WHERE user_id=$2", step, uid)
+	if err != nil {
+		return err
+	}
+	if err := affected(res); err != nil {
+		return err
+	}
+	if err := setRecoveryCodes(tx, uid, recoveryCodes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *pgTwoFactor) Disable(uid int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id=$1", uid); err != nil {
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM two_factor WHERE user_id=$1", uid)
+	if err != nil {
+		return err
+	}
+	if err := affected(res); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *pgTwoFactor) SetRecoveryCodes(uid int, recoveryCodes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := setRecoveryCodes(tx, uid, recoveryCodes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *pgTwoFactor) UseStep(uid int, step int64) (bool, error) {
+	res, err := s.db.Exec("UPDATE two_factor SET last_step=$1 WHERE user_id=$2 AND last_step < $1", step, uid)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *pgTwoFactor) UseRecoveryCode(uid int, hash string) (bool, error) {
+	res, err := s.db.Exec("UPDATE recovery_codes SET used_at=$1 WHERE user_id=$2 AND code_hash=$3 AND used_at IS NULL", time.Now(), uid, hash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
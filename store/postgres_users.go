@@ -0,0 +1,93 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meynay/BookStore/models"
+)
+
+// List pages through users by id. The search matches names and emails.
+func (s *pgUsers) List(filter models.UserFilter) (models.UserPage, error) {
+	spec, err := parseKeyset(filter.Page, "user_id")
+	if err != nil {
+		return models.UserPage{}, err
+	}
+	q := &query{}
+	if term := strings.TrimSpace(filter.Search); term != "" {
+		like := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(term) + "%"
+		arg := q.arg(like)
+		q.where = append(q.where, fmt.Sprintf("((firstname || ' ' || lastname) ILIKE %[1]s OR email ILIKE %[1]s)", arg))
+	}
+	if filter.Role != "" {
+		q.where = append(q.where, "role = "+q.arg(filter.Role))
+	}
+	if filter.Status != "" {
+		q.where = append(q.where, "status = "+q.arg(filter.Status))
+	}
+	if spec.after != nil {
+		q.where = append(q.where, "user_id > "+q.arg(spec.after.Id))
+	}
+	rows, err := s.db.Query(fmt.Sprintf("SELECT %s FROM users%s ORDER BY user_id LIMIT %s", userColumns, q.whereClause(), q.arg(spec.limit+1)), q.args...)
+	if err != nil {
+		return models.UserPage{}, err
+	}
+	defer rows.Close()
+	result := models.UserPage{Users: []models.User{}}
+	for rows.Next() {
+		if len(result.Users) == spec.limit {
+			last := result.Users[len(result.Users)-1]
+			result.NextCursor = spec.next(strconv.Itoa(last.Id), last.Id)
+			break
+		}
+		user, err := scanUser(rows)
+		if err != nil {
+			return models.UserPage{}, err
+		}
+		result.Users = append(result.Users, user)
+	}
+	return result, rows.Err()
+}
+
+func (s *pgUsers) SetStatus(id int, status, reason string, until *time.Time) error {
+	res, err := s.db.Exec("UPDATE users SET status=$1, status_reason=$2, suspended_until=$3 WHERE user_id=$4", status, reason, until, id)
+	if err != nil {
+		return err
+	}
+	return affected(res)
+}
+
+func (s *pgUsers) ForcePasswordReset(id int) error {
+	res, err := s.db.Exec("UPDATE users SET must_reset_password=TRUE WHERE user_id=$1", id)
+	if err != nil {
+		return err
+	}
+	return affected(res)
+}
+
+func (s *pgUsers) SetEmailVerified(id int) error {
+	res, err := s.db.Exec("UPDATE users SET email_verified=TRUE WHERE user_id=$1", id)
+	if err != nil {
+		return err
+	}
+	return affected(res)
+}
+
+func (s *pgRatings) ByUser(uid int) ([]models.Rate, error) {
+	rows, err := s.db.Query("SELECT book_id, rating, review FROM user_rating WHERE user_id=$1 ORDER BY date_added DESC", uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rates := []models.Rate{}
+	for rows.Next() {
+		var rate models.Rate
+		if err := rows.Scan(&rate.Bid, &rate.Rating, &rate.Review); err != nil {
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+	return rates, rows.Err()
+}
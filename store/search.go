@@ -0,0 +1,35 @@
+package store
+
+import (
+	"strings"
+
+	"github.com/meynay/BookStore/functions"
+)
+
+// isbnLike reports whether s, without separators, looks like an ISBN-10 or
+// ISBN-13 so it can be matched as a single token.
+func isbnLike(s string) (string, bool) {
+	compact := strings.NewReplacer("-", "", " ", "").Replace(functions.NormalizePersian(s))
+	if len(compact) != 10 && len(compact) != 13 {
+		return "", false
+	}
+	for i, r := range compact {
+		if (r < '0' || r > '9') && !(i == 9 && len(compact) == 10 && r == 'x') {
+			return "", false
+		}
+	}
+	return compact, true
+}
+
+// searchQuery turns user input into a to_tsquery expression where every
+// term must match as a prefix.
+func searchQuery(search string) string {
+	if isbn, ok := isbnLike(search); ok {
+		return isbn
+	}
+	terms := functions.SearchTerms(search)
+	for i := range terms {
+		terms[i] += ":*"
+	}
+	return strings.Join(terms, " & ")
+}
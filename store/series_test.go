@@ -0,0 +1,98 @@
+package store
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/meynay/BookStore/models"
+)
+
+func filterIds(t *testing.T, books BookStore, filter models.Filter) []int {
+	t.Helper()
+	filter.Sort = "title"
+	page, err := books.Filter(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []int{}
+	for _, book := range page.Books {
+		ids = append(ids, book.Id)
+	}
+	return ids
+}
+
+func TestCollapseEditions(t *testing.T) {
+	stores := NewMemory()
+	for _, book := range []models.Book{
+		{Title: "a paperback", Format: "paperback"},
+		{Title: "b ebook", Format: "ebook"},
+		{Title: "c standalone", Format: "paperback"},
+		{Title: "d other work", Format: "paperback"},
+		{Title: "e other work ebook", Format: "ebook"},
+	} {
+		stores.Books.Create(&book)
+	}
+	first, _ := stores.Books.GroupEditions([]int{1, 2})
+	second, _ := stores.Books.GroupEditions([]int{4, 5})
+	// grouping books of two works merges them into the older work
+	if merged, err := stores.Books.GroupEditions([]int{2, 5}); err != nil || merged != first {
+		t.Fatalf("GroupEditions across works = %d, %v; want %d", merged, err, first)
+	}
+	if editions, _ := stores.Books.Editions(1); len(editions) != 3 {
+		t.Errorf("book 1 lists %d other editions, want 3", len(editions))
+	}
+	if second == first {
+		t.Fatal("two groups got the same work")
+	}
+
+	tests := []struct {
+		name   string
+		filter models.Filter
+		want   []int
+	}{
+		{"every edition", models.Filter{}, []int{1, 2, 3, 4, 5}},
+		{"collapsed", models.Filter{CollapseEditions: true}, []int{1, 3}},
+		// the kept edition is the lowest id one among the matches
+		{"collapsed ebooks", models.Filter{CollapseEditions: true, Formats: []string{"ebook"}}, []int{2}},
+	}
+	for _, tt := range tests {
+		if got := filterIds(t, stores.Books, tt.filter); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	stores.Books.Ungroup(1)
+	if got := filterIds(t, stores.Books, models.Filter{CollapseEditions: true}); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Errorf("after ungrouping book 1: got %v, want [1 2 3]", got)
+	}
+}
+
+func TestSeriesNext(t *testing.T) {
+	stores := NewMemory()
+	for _, book := range []models.Book{
+		{Title: "volume 1"}, {Title: "volume 2 hardcover", Price: 300}, {Title: "volume 2 paperback", Price: 200}, {Title: "volume 3"}, {Title: "unnumbered"},
+	} {
+		stores.Books.Create(&book)
+	}
+	series := models.Series{Name: "کلیدر"}
+	stores.Series.Create(&series)
+	err := stores.Series.SetVolumes(series.Id, []models.SeriesVolume{{BookId: 1, Volume: 1}, {BookId: 2, Volume: 2}, {BookId: 3, Volume: 2}, {BookId: 4, Volume: 3}, {BookId: 5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for bid, want := range map[int]int{1: 3, 2: 4, 3: 4} {
+		if next, err := stores.Series.Next(bid); err != nil || next.Id != want {
+			t.Errorf("Next(%d) = %d, %v; want %d", bid, next.Id, err, want)
+		}
+	}
+	for _, bid := range []int{4, 5} {
+		if _, err := stores.Series.Next(bid); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Next(%d): got %v, want ErrNotFound", bid, err)
+		}
+	}
+	got, _ := stores.Series.Get(series.Id)
+	if len(got.Books) != 5 || got.Books[0].Id != 1 || got.Books[4].Id != 5 {
+		t.Errorf("series books = %+v, want volume order with the unnumbered last", got.Books)
+	}
+}
@@ -0,0 +1,183 @@
+package store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/meynay/BookStore/models"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// ErrDuplicateIsbn means another book already has the ISBN13.
+var ErrDuplicateIsbn = errors.New("another book has this isbn13")
+
+// ErrTokenReused means a refresh token was presented after it had already
+// been swapped, so someone else has a copy of it.
+var ErrTokenReused = errors.New("refresh token reused")
+
+type BookStore interface {
+	List(page models.Page) (models.BookPage, error)
+	ByIds(ids []int) ([]models.LowBook, error)
+	NewBooks(maxAge time.Duration) ([]models.LowBook, error)
+	Get(id int) (models.Book, error)
+	Filter(filter models.Filter) (models.BookPage, error)
+	Suggest(prefix string, limit int) ([]models.Suggestion, error)
+	Create(book *models.Book) error
+	Update(book models.Book) error
+	SaleQuantity(id int) (int, error)
+	SetSaleQuantity(id, quantity int) error
+	SetRating(id int, avg float64, count int) error
+	SetImage(id int, url string) error
+	GroupEditions(ids []int) (int, error)
+	Ungroup(id int) error
+	Editions(id int) ([]models.Edition, error)
+	Import(books []models.Book, dryRun bool) ([]string, error)
+	Each(fn func(book models.Book) error) error
+	Details(ids []int) ([]models.Book, error)
+	Genres() ([]models.FacetCount, error)
+	Count(filter models.Filter) (int, error)
+	Changes(from, until, afterTime time.Time, afterId, limit int) ([]models.Book, error)
+}
+
+type SeriesStore interface {
+	Create(series *models.Series) error
+	Get(id int) (models.Series, error)
+	SetVolumes(id int, volumes []models.SeriesVolume) error
+	Remove(id, bid int) error
+	Next(bid int) (models.LowBook, error)
+}
+
+type AuthorStore interface {
+	List(search string, page models.Page) (models.AuthorPage, error)
+	Get(id int) (models.Author, error)
+	Update(author models.Author) error
+	Merge(target int, duplicates []int) error
+}
+
+type UserStore interface {
+	Get(id int) (models.User, error)
+	GetByEmail(email string) (models.User, error)
+	Create(user *models.User) error
+	SetImage(id int, image string) error
+	SetAvatarPublic(id int, public bool) error
+	ImageInUse(image string) (bool, error)
+	SetRole(id int, role string) error
+	List(filter models.UserFilter) (models.UserPage, error)
+	SetStatus(id int, status, reason string, until *time.Time) error
+	ForcePasswordReset(id int) error
+	SetEmailVerified(id int) error
+	SetPassword(email, hash string) error
+	IsFaved(uid, bid int) (bool, error)
+	AddFave(uid, bid int) error
+	RemoveFave(uid, bid int) error
+	Faves(uid int) ([]int, error)
+	AddRead(uid, bid int) error
+	Reads(uid int) ([]int, error)
+}
+
+type BorrowStore interface {
+	IsBorrowed(bid int) (bool, error)
+	HasActive(uid int) (bool, error)
+	Borrow(uid, bid int, at time.Time) error
+	Return(bid int) error
+	History(uid int) ([]models.LowBook, error)
+	Active() ([]models.LowBook, error)
+}
+
+type InvoiceStore interface {
+	Open(uid int) (int, error)
+	CreateOpen(uid int) (int, error)
+	AddBook(iid, bid int) error
+	RemoveBook(iid, bid int) error
+	InOpen(uid, bid int) (bool, error)
+	OpenBooks(uid int) ([]models.LowBook, error)
+	Books(iid int) ([]models.LowBook, error)
+	Close(iid int, at time.Time) error
+	History(uid int) ([]models.Invoice, error)
+	AllClosed() ([]models.Invoice, error)
+}
+
+type RatingStore interface {
+	Rating(uid, bid int) (int, error)
+	SaveRating(uid int, rate models.Rate, at time.Time) error
+	Ratings(bid int) ([]models.UserComment, error)
+	AddComment(uid int, rate models.Rate, at time.Time) error
+	Comments(bid int) ([]models.UserComment, error)
+	ByUser(uid int) ([]models.Rate, error)
+}
+
+// AuditStore only appends; entries are never changed or removed.
+type AuditStore interface {
+	Record(entry *models.AuditEntry) error
+	List(filter models.AuditFilter) (models.AuditPage, error)
+	Each(filter models.AuditFilter, fn func(entry models.AuditEntry) error) error
+}
+
+// TwoFactorStore keeps authenticator secrets and hashed recovery codes. Get
+// returns ErrNotFound for users who never started enrolling.
+type TwoFactorStore interface {
+	Get(uid int) (models.TwoFactor, error)
+	// Begin stores a new secret that isn't enforced until Enable.
+	Begin(uid int, secret string) error
+	Enable(uid int, step int64, recoveryCodes []string) error
+	Disable(uid int) error
+	SetRecoveryCodes(uid int, recoveryCodes []string) error
+	// UseStep records a code's step as used, failing for steps at or before
+	// the last one so a code can't be replayed.
+	UseStep(uid int, step int64) (bool, error)
+	UseRecoveryCode(uid int, hash string) (bool, error)
+}
+
+// ResetStore keeps hashes of password reset tokens until they expire.
+type ResetStore interface {
+	Create(uid int, hash string, expires time.Time) error
+	// Lookup finds the user of an unused, unexpired token without using it.
+	Lookup(hash string) (int, error)
+	// Consume uses up an unexpired token along with every other token of its
+	// user, returning ErrNotFound if there is no such token to use.
+	Consume(hash string) (int, error)
+}
+
+// SessionStore keeps signed in devices. Refresh tokens are only stored as
+// hashes. Sessions that are revoked or past their expiry count as missing.
+type SessionStore interface {
+	Create(session *models.Session, hash string) error
+	// Rotate swaps the refresh token with hash for next and extends the
+	// session. Presenting a swapped token again revokes the whole session
+	// and returns it together with ErrTokenReused.
+	Rotate(hash, next, ip, userAgent string, expires time.Time) (models.Session, error)
+	// ByRefresh returns the session a refresh token was handed out for,
+	// whether or not it was used since.
+	ByRefresh(hash string) (models.Session, error)
+	Active(id int) (models.Session, error)
+	List(uid int) ([]models.Session, error)
+	Revoke(uid, id int) error
+	RevokeAll(uid int) error
+}
+
+// ApiClientStore keeps the apps allowed to call the api. Keys are only
+// stored as hashes.
+type ApiClientStore interface {
+	Create(client *models.ApiClient) error
+	// ByHash finds the client whose key has hash, unless it was revoked.
+	ByHash(hash string) (models.ApiClient, error)
+	List() ([]models.ApiClient, error)
+	Revoke(id int) error
+	Touch(id int, at time.Time) error
+}
+
+type Stores struct {
+	Books     BookStore
+	Authors   AuthorStore
+	Series    SeriesStore
+	Users     UserStore
+	Borrows   BorrowStore
+	Invoices  InvoiceStore
+	Ratings   RatingStore
+	Audit     AuditStore
+	Sessions  SessionStore
+	TwoFactor TwoFactorStore
+	Resets    ResetStore
+	Clients   ApiClientStore
+}
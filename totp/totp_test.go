@@ -0,0 +1,88 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SHA-1 seed of RFC 6238 appendix B, "12345678901234567890", base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// The SHA-1 vectors of RFC 6238 appendix B. They are eight digits long; six
+// digit codes are their last six.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func TestCode(t *testing.T) {
+	for _, v := range rfcVectors {
+		got, err := Code(rfcSecret, Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := v.code[len(v.code)-Digits:]; got != want {
+			t.Errorf("Code at %d = %s, want %s", v.unix, got, want)
+		}
+	}
+	if got, _ := Code(strings.ToLower(rfcSecret), 1); got != "287082" {
+		t.Errorf("lower case secret: got %s, want 287082", got)
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code accepted a secret that isn't base32")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	tests := []struct {
+		name string
+		code string
+		step int64
+		ok   bool
+	}{
+		{"now", "050471", step, true},
+		{"with a space", "050 471", step, true},
+		{"previous step", "081804", step - 1, true},
+		{"two steps ago", "", step - 2, false},
+		{"next step", "", step + 1, true},
+		{"two steps ahead", "", step + 2, false},
+		{"wrong", "000000", 0, false},
+		{"too short", "50471", 0, false},
+		{"too long", "14050471", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := tt.code
+			if code == "" {
+				code, _ = Code(rfcSecret, tt.step)
+			}
+			got, ok := Validate(rfcSecret, code, now)
+			if ok != tt.ok || (ok && got != tt.step) {
+				t.Errorf("Validate(%q) = %d, %v; want %d, %v", code, got, ok, tt.step, tt.ok)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("secret %q is %d characters, want 32", secret, len(secret))
+	}
+	if _, err := Code(secret, 0); err != nil {
+		t.Errorf("generated secret doesn't decode: %v", err)
+	}
+}